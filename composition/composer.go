@@ -3,19 +3,36 @@ package composition
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
 
 	"github.com/bhameyie/dpipeliner/data"
-
-	"gopkg.in/yaml.v2"
+	"github.com/bhameyie/dpipeliner/spec"
 )
 
 //SystemComposer produces content needed for creation of docker compose file
 type SystemComposer struct {
+	// Catalog is the tracked service collection the candidates come from
+	Catalog string
+	// Environment, Labels and Vars are available to the spec templates of the candidates
+	Environment string
+	Labels      map[string]string
+	Vars        map[string]string
+	// Format is the compose file format produced, FormatV1 when empty
+	Format string
+	// Bases are compose files the composed candidates are merged into, see mergeBases for the precedence
+	Bases []string
+	// Signer signs the snapshots when set
+	Signer SnapshotSigner
+	// LogOutput receives the marathon fields that could not be translated into the composition
+	LogOutput io.Writer
 }
 
 //NewComposer initializes a new composer
 func NewComposer() IComposer {
-	return &SystemComposer{}
+	return &SystemComposer{LogOutput: os.Stdout}
 }
 
 // PrepareComposerContent produce docker-compose file content
@@ -23,13 +40,84 @@ func (sc *SystemComposer) PrepareComposerContent(candidates []data.DeploymentCan
 	if candidates == nil || len(candidates) == 0 {
 		return nil, errors.New("No candidates found")
 	}
+	if err := validFormat(sc.Format); err != nil {
+		return nil, err
+	}
+
+	apps, services, err := sc.marathonApps(candidates)
+	if err != nil {
+		return nil, err
+	}
 
 	com := make(map[string]composeSpec)
-	for _, candidate := range candidates {
-		com[candidate.ServiceName] = composeSpec{Image: candidate.Image}
+	for i, candidate := range candidates {
+		if apps[i] == nil {
+			com[candidate.ServiceName] = composeSpec{Image: candidate.Image}
+			continue
+		}
+		svc, unsupported := composeService(apps[i], candidate.Image, services, namedVolumes(sc.Format))
+		for _, field := range unsupported {
+			sc.report(candidate.ServiceName, field+" is not supported")
+		}
+		com[candidate.ServiceName] = svc
 	}
 
-	return yaml.Marshal(com)
+	content, err := marshalCompose(sc.Format, com)
+	if err != nil || len(sc.Bases) == 0 {
+		return content, err
+	}
+	return sc.mergeBases(content)
+}
+
+// marathonApps parses the rendered marathon spec of the candidates, nil for the candidates without one, and maps
+// the app ids to the service names
+func (sc *SystemComposer) marathonApps(candidates []data.DeploymentCandidate) ([]*marathonApp, map[string]string, error) {
+	apps := make([]*marathonApp, len(candidates))
+	services := make(map[string]string)
+	for i, candidate := range candidates {
+		if candidate.Spec == "" {
+			continue
+		}
+		content, err := spec.Render(candidate.Spec, sc.templateContext(candidate))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", candidate.ServiceName, err)
+		}
+		app, unsupported, err := parseMarathonApp(content)
+		if err != nil {
+			sc.report(candidate.ServiceName, "spec is not a marathon app, only its image is composed")
+			continue
+		}
+		for _, field := range unsupported {
+			sc.report(candidate.ServiceName, field+" is not supported")
+		}
+		apps[i] = app
+		if id := appID(app); id != "" {
+			services[id] = candidate.ServiceName
+		}
+	}
+	return apps, services, nil
+}
+
+func (sc *SystemComposer) templateContext(candidate data.DeploymentCandidate) spec.Context {
+	return spec.Context{
+		Image:       candidate.Image,
+		Version:     candidate.Version,
+		Service:     candidate.ServiceName,
+		Environment: sc.Environment,
+		Labels:      sc.Labels,
+		Vars:        sc.Vars,
+	}
+}
+
+// report tells about a part of the candidate spec left out of the composition
+func (sc *SystemComposer) report(service, message string) {
+	sc.log(service + ": " + message)
+}
+
+func (sc *SystemComposer) log(message string) {
+	if sc.LogOutput != nil {
+		fmt.Fprintln(sc.LogOutput, message)
+	}
 }
 
 // PrepareFinalizableCandidatesSnapshotContent produces candidateSnapper content
@@ -38,15 +126,27 @@ func (sc *SystemComposer) PrepareFinalizableCandidatesSnapshotContent(candidates
 		return nil, errors.New("No candidates found")
 	}
 
-	var com []NonValidatedCandidates
+	snapshot := Snapshot{
+		FormatVersion: SnapshotFormatVersion,
+		ID:            SnapshotID(candidates),
+		Created:       time.Now().UTC(),
+		Catalog:       sc.Catalog,
+	}
 	for _, candidate := range candidates {
-		if !candidate.E2E && !candidate.Completed {
-			com = append(com,
+		if awaitingValidation(candidate) {
+			snapshot.Candidates = append(snapshot.Candidates,
 				NonValidatedCandidates{
-					Service: candidate.ServiceName,
-					Version: candidate.Version,
+					Service:  candidate.ServiceName,
+					Version:  candidate.Version,
+					Image:    candidate.Image,
+					SpecHash: SpecHash(candidate.Spec),
 				})
 		}
 	}
-	return json.Marshal(com)
+	if sc.Signer != nil {
+		if err := snapshot.Sign(sc.Signer); err != nil {
+			return nil, err
+		}
+	}
+	return json.MarshalIndent(snapshot, "", "  ")
 }
@@ -2,7 +2,14 @@ package composition
 
 import (
 	"bytes"
-	"encoding/json"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/bhameyie/dpipeliner/data"
@@ -13,6 +20,8 @@ import (
 
 func Test(t *testing.T) { TestingT(t) }
 
+var updateGolden = flag.Bool("update", false, "rewrite the golden files of the composer tests")
+
 type ComposerSuite struct{}
 
 var _ = Suite(&ComposerSuite{})
@@ -79,10 +88,11 @@ func (s *ComposerSuite) TestPrepareFinalizableCandidatesSnapshotContentProduceVa
 	content, err := sut.PrepareFinalizableCandidatesSnapshotContent(arr)
 	c.Assert(err, IsNil)
 
-	var candidates []NonValidatedCandidates
-	dec := json.NewDecoder(bytes.NewReader(content))
-	err = dec.Decode(&candidates)
+	snapshot, err := ReadSnapshot(content)
 	c.Assert(err, IsNil)
+	c.Assert(snapshot.FormatVersion, Equals, SnapshotFormatVersion)
+	c.Assert(snapshot.ID, Equals, SnapshotID(arr))
+	candidates := snapshot.Candidates
 	c.Assert(len(candidates), Equals, 2)
 	c.Assert(candidates[0].Service, Equals, "here")
 	c.Assert(candidates[0].Version, Equals, "2")
@@ -90,6 +100,18 @@ func (s *ComposerSuite) TestPrepareFinalizableCandidatesSnapshotContentProduceVa
 	c.Assert(candidates[1].Version, Equals, "4")
 }
 
+func (s *ComposerSuite) TestSnapshotIDCoversTheVersionsComposedNextToTheCandidates(c *C) {
+	api := data.DeploymentCandidate{ServiceName: "api", Version: "7", Image: "shop/api:7"}
+	db := data.DeploymentCandidate{ServiceName: "db", Version: "3", E2E: true}
+	alone := SnapshotID([]data.DeploymentCandidate{api})
+
+	c.Assert(SnapshotID([]data.DeploymentCandidate{api, db}), Not(Equals), alone)
+	db.Version = "4"
+	c.Assert(SnapshotID([]data.DeploymentCandidate{db, api}), Not(Equals), SnapshotID([]data.DeploymentCandidate{api, {ServiceName: "db", Version: "3", E2E: true}}))
+	api.Image = "shop/api:7-rebuilt"
+	c.Assert(SnapshotID([]data.DeploymentCandidate{api}), Not(Equals), alone)
+}
+
 func (s *ComposerSuite) TestPrepareFinalizableCandidatesSnapshotContentFailsWhenNilOrEmpty(c *C) {
 	arr := []data.DeploymentCandidate{}
 	content, err := sut.PrepareFinalizableCandidatesSnapshotContent(arr)
@@ -100,3 +122,311 @@ func (s *ComposerSuite) TestPrepareFinalizableCandidatesSnapshotContentFailsWhen
 	c.Assert(content, IsNil)
 	c.Assert(err, NotNil)
 }
+
+func (s *ComposerSuite) TestPrepareComposerContentTranslatesMarathonSpecs(c *C) {
+	api := data.DeploymentCandidate{
+		Image:       "shop/api:7",
+		Version:     "7",
+		ServiceName: "api",
+		Spec: `{
+			"id": "/shop/api",
+			"cmd": "./api --port 8080",
+			"env": {"MODE": "{{.Environment}}", "SECRET": {"secret": "db"}},
+			"labels": {"team": "shop"},
+			"mem": 256,
+			"dependencies": ["db", "/elsewhere/cache"],
+			"container": {
+				"type": "DOCKER",
+				"docker": {"image": "ignored", "portMappings": [{"containerPort": 8080, "hostPort": 80}, {"containerPort": 53, "protocol": "udp"}]},
+				"volumes": [{"containerPath": "/data", "hostPath": "/var/data", "mode": "RO"}, {"containerPath": "/state"}]
+			},
+			"healthChecks": [{"protocol": "HTTP", "path": "/health", "portIndex": 0, "intervalSeconds": 10, "maxConsecutiveFailures": 3}],
+			"fetch": [{"uri": "http://example.com/config"}]
+		}`,
+	}
+	db := data.DeploymentCandidate{
+		Image:       "postgres",
+		ServiceName: "db",
+		Spec:        `{"id": "/shop/db", "args": ["postgres", "-N", "50"], "healthChecks": [{"protocol": "COMMAND", "command": {"value": "pg_isready"}}]}`,
+	}
+	var report bytes.Buffer
+	composer := &SystemComposer{Environment: "e2e", LogOutput: &report}
+
+	content, err := composer.PrepareComposerContent([]data.DeploymentCandidate{api, db})
+	c.Assert(err, IsNil)
+	m := make(map[string]composeSpec)
+	c.Assert(yaml.Unmarshal(content, &m), IsNil)
+
+	c.Assert(m["api"].Image, Equals, "shop/api:7")
+	c.Assert(m["api"].Command, Equals, "./api --port 8080")
+	c.Assert(m["api"].Environment, DeepEquals, map[string]string{"MODE": "e2e"})
+	c.Assert(m["api"].Labels, DeepEquals, map[string]string{"team": "shop"})
+	c.Assert(m["api"].MemLimit, Equals, "256m")
+	c.Assert(m["api"].Ports, DeepEquals, []string{"80:8080", "53/udp"})
+	c.Assert(m["api"].Volumes, DeepEquals, []string{"/var/data:/data:ro"})
+	c.Assert(m["api"].Healthcheck.Test, DeepEquals, []string{"CMD-SHELL", "curl -f http://localhost:8080/health || exit 1"})
+	c.Assert(m["api"].Healthcheck.Interval, Equals, "10s")
+	c.Assert(m["api"].Healthcheck.Retries, Equals, 3)
+	c.Assert(m["api"].DependsOn, DeepEquals, []string{"db"})
+
+	c.Assert(m["db"].Command, DeepEquals, []interface{}{"postgres", "-N", "50"})
+	c.Assert(m["db"].Healthcheck.Test, DeepEquals, []string{"CMD-SHELL", "pg_isready"})
+
+	c.Assert(report.String(), Matches, "(?s).*api: marathon field fetch is not supported.*")
+	c.Assert(report.String(), Matches, "(?s).*api: env.SECRET is not supported.*")
+	c.Assert(report.String(), Matches, "(?s).*api: container.volumes /state without an absolute hostPath is not supported.*")
+	c.Assert(report.String(), Matches, "(?s).*api: dependencies /elsewhere/cache outside of the composition is not supported.*")
+}
+
+func (s *ComposerSuite) TestHealthChecksProbeTheirExplicitPort(c *C) {
+	app, _, err := parseMarathonApp([]byte(`{"id": "/web", "healthChecks": [{"protocol": "HTTP", "path": "/health", "port": 9000}]}`))
+	c.Assert(err, IsNil)
+
+	check, unsupported := healthcheck(app)
+	c.Assert(unsupported, HasLen, 0)
+	c.Assert(check.Test, DeepEquals, []string{"CMD-SHELL", "curl -f http://localhost:9000/health || exit 1"})
+	probe := readinessProbe(app)
+	c.Assert(probe, NotNil)
+	c.Assert(probe.HTTPGet.Port, Equals, 9000)
+}
+
+func (s *ComposerSuite) TestPrepareComposerContentKeepsOnlyImageOfOtherSpecs(c *C) {
+	var report bytes.Buffer
+	composer := &SystemComposer{LogOutput: &report}
+	cand := data.DeploymentCandidate{Image: "web", ServiceName: "web", Spec: "kind: Deployment"}
+
+	content, err := composer.PrepareComposerContent([]data.DeploymentCandidate{cand})
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "web:\n  image: web\n")
+	c.Assert(report.String(), Matches, "web: spec is not a marathon app.*\n")
+}
+
+var goldenCandidates = []data.DeploymentCandidate{
+	{
+		Image:       "shop/api:7",
+		Version:     "7",
+		ServiceName: "api",
+		Spec: `{
+			"id": "/shop/api",
+			"cmd": "./api",
+			"env": {"MODE": "e2e"},
+			"cpus": 0.5,
+			"mem": 256,
+			"dependencies": ["db"],
+			"container": {"docker": {"portMappings": [{"containerPort": 8080, "hostPort": 80}]}},
+			"healthChecks": [{"protocol": "HTTP", "path": "/health", "gracePeriodSeconds": 30}]
+		}`,
+	},
+	{
+		Image:       "postgres",
+		Version:     "12",
+		ServiceName: "db",
+		Spec: `{
+			"id": "/shop/db",
+			"container": {"volumes": [{"containerPath": "pgdata", "persistent": {"size": 100}}, {"containerPath": "/var/lib/postgresql/data", "hostPath": "pgdata", "mode": "RW"}, {"containerPath": "/etc/pg", "hostPath": "/srv/pg", "mode": "RO"}]}
+		}`,
+	},
+	{
+		Image:       "proxy",
+		ServiceName: "proxy",
+		Spec:        `{"id": "/shop/proxy", "container": {"docker": {"network": "HOST"}}}`,
+	},
+}
+
+func (s *ComposerSuite) TestPrepareComposerContentMatchesGoldenFiles(c *C) {
+	for _, format := range []string{FormatV1, FormatV2, FormatV3} {
+		composer := &SystemComposer{Format: format}
+		content, err := composer.PrepareComposerContent(goldenCandidates)
+		c.Assert(err, IsNil)
+
+		golden := filepath.Join("testdata", "docker-compose.v"+format+".yml")
+		if *updateGolden {
+			c.Assert(ioutil.WriteFile(golden, content, 0644), IsNil)
+		}
+		expected, err := ioutil.ReadFile(golden)
+		c.Assert(err, IsNil)
+		c.Assert(string(content), Equals, string(expected), Commentf("format %s", format))
+	}
+}
+
+func (s *ComposerSuite) TestPrepareComposerContentRejectsUnknownFormats(c *C) {
+	composer := &SystemComposer{Format: "4"}
+	content, err := composer.PrepareComposerContent(goldenCandidates)
+	c.Assert(content, IsNil)
+	c.Assert(err, ErrorMatches, "unknown compose format 4.*")
+}
+
+func (s *ComposerSuite) TestPrepareComposerContentMergesIntoBaseFiles(c *C) {
+	var report bytes.Buffer
+	composer := &SystemComposer{
+		Format:    FormatV3,
+		Bases:     []string{filepath.Join("testdata", "base.yml"), filepath.Join("testdata", "base.override.yml")},
+		LogOutput: &report,
+	}
+	content, err := composer.PrepareComposerContent(goldenCandidates)
+	c.Assert(err, IsNil)
+
+	golden := filepath.Join("testdata", "docker-compose.merged.yml")
+	if *updateGolden {
+		c.Assert(ioutil.WriteFile(golden, content, 0644), IsNil)
+	}
+	expected, err := ioutil.ReadFile(golden)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, string(expected))
+
+	c.Assert(report.String(), Matches, `(?s).*compose conflict: version is overridden by the composed candidates \(3.8 replaces 3.4\).*`)
+	c.Assert(report.String(), Matches, `(?s).*compose conflict: services.api.image is overridden by the composed candidates \(shop/api:7 replaces shop/api:latest\).*`)
+	c.Assert(report.String(), Not(Matches), `(?s).*depends_on.*`)
+	c.Assert(report.String(), Not(Matches), `(?s).*rabbit.*`)
+}
+
+func (s *ComposerSuite) TestPrepareComposerContentRejectsMismatchedBaseFiles(c *C) {
+	composer := &SystemComposer{Bases: []string{filepath.Join("testdata", "base.yml")}}
+	_, err := composer.PrepareComposerContent(goldenCandidates)
+	c.Assert(err, ErrorMatches, ".*base.yml is a versioned compose file but the compose format is 1")
+
+	base := filepath.Join(c.MkDir(), "flat.yml")
+	c.Assert(ioutil.WriteFile(base, []byte("db:\n  image: postgres\n"), 0644), IsNil)
+	composer = &SystemComposer{Format: FormatV2, Bases: []string{base}}
+	_, err = composer.PrepareComposerContent(goldenCandidates)
+	c.Assert(err, ErrorMatches, ".*flat.yml: unexpected top level key db.*")
+
+	c.Assert(ioutil.WriteFile(base, []byte("services:\n  api:\n    ports: {web: 80}\n"), 0644), IsNil)
+	_, err = composer.PrepareComposerContent(goldenCandidates)
+	c.Assert(err, ErrorMatches, "services.api.ports in the composed candidates cannot be merged.*")
+}
+
+func (s *ComposerSuite) TestKubernetesComposerMatchesGoldenFile(c *C) {
+	var report bytes.Buffer
+	composer := &KubernetesComposer{SystemComposer: SystemComposer{LogOutput: &report}}
+	content, err := composer.PrepareComposerContent(goldenCandidates)
+	c.Assert(err, IsNil)
+
+	golden := filepath.Join("testdata", "e2e-manifests.yml")
+	if *updateGolden {
+		c.Assert(ioutil.WriteFile(golden, content, 0644), IsNil)
+	}
+	expected, err := ioutil.ReadFile(golden)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, string(expected))
+	c.Assert(report.String(), Matches, "(?s).*db: container.volumes is not supported.*proxy: container.docker.network HOST is not supported.*")
+}
+
+func (s *ComposerSuite) TestKubernetesComposerDerivesNamespaceFromSnapshot(c *C) {
+	composer := &KubernetesComposer{}
+	reordered := []data.DeploymentCandidate{goldenCandidates[2], goldenCandidates[0], goldenCandidates[1]}
+	c.Assert(composer.namespace(goldenCandidates), Equals, composer.namespace(reordered))
+	c.Assert(composer.namespace(goldenCandidates), Matches, "e2e-[0-9a-f]{12}")
+
+	bumped := append([]data.DeploymentCandidate{}, goldenCandidates...)
+	bumped[0].Version = "8"
+	c.Assert(composer.namespace(bumped), Not(Equals), composer.namespace(goldenCandidates))
+
+	composer.Namespace = "e2e-mine"
+	c.Assert(composer.namespace(goldenCandidates), Equals, "e2e-mine")
+}
+
+func (s *ComposerSuite) TestKubernetesNamesStartWithALetter(c *C) {
+	c.Assert(kubeName("Shop_Cart"), Equals, "shop-cart")
+	c.Assert(kubeName("2fa"), Equals, "svc-2fa")
+	c.Assert(kubeName("__"), Equals, "svc")
+	c.Assert(len(kubeName("1"+strings.Repeat("a", 70))), Equals, 63)
+}
+
+func (s *ComposerSuite) TestSnapshotCarriesCandidateImagesAndSpecs(c *C) {
+	composer := &SystemComposer{Catalog: "fire_trackedservices"}
+	content, err := composer.PrepareFinalizableCandidatesSnapshotContent(goldenCandidates)
+	c.Assert(err, IsNil)
+
+	snapshot, err := ReadSnapshot(content)
+	c.Assert(err, IsNil)
+	c.Assert(snapshot.Catalog, Equals, "fire_trackedservices")
+	c.Assert(snapshot.Created.IsZero(), Equals, false)
+	c.Assert(snapshot.Candidates[0].Image, Equals, "shop/api:7")
+	c.Assert(snapshot.Candidates[0].Verify(goldenCandidates[0]), IsNil)
+
+	retagged := goldenCandidates[0]
+	retagged.Image = "shop/api:latest"
+	c.Assert(snapshot.Candidates[0].Verify(retagged), ErrorMatches, "api 7 image changed since the snapshot, shop/api:latest instead of shop/api:7")
+	respecced := goldenCandidates[0]
+	respecced.Spec = `{"id": "/shop/api"}`
+	c.Assert(snapshot.Candidates[0].Verify(respecced), ErrorMatches, "api 7 spec changed since the snapshot")
+}
+
+func (s *ComposerSuite) TestReadsFormerSnapshotFormat(c *C) {
+	snapshot, err := ReadSnapshot([]byte(`[{"Service": "here", "Version": "2"}]`))
+	c.Assert(err, IsNil)
+	c.Assert(snapshot.FormatVersion, Equals, 1)
+	c.Assert(snapshot.Candidates, DeepEquals, []NonValidatedCandidates{{Service: "here", Version: "2"}})
+	c.Assert(snapshot.Candidates[0].Verify(data.DeploymentCandidate{Image: "any"}), IsNil)
+
+	_, err = ReadSnapshot([]byte(`{"FormatVersion": 3}`))
+	c.Assert(err, ErrorMatches, "unsupported snapshot format version 3")
+}
+
+func writeEd25519Keys(c *C) (string, string) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	c.Assert(err, IsNil)
+	dir := c.MkDir()
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	c.Assert(err, IsNil)
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	c.Assert(err, IsNil)
+	privateFile, publicFile := filepath.Join(dir, "snapshot.key"), filepath.Join(dir, "snapshot.pub")
+	c.Assert(ioutil.WriteFile(privateFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600), IsNil)
+	c.Assert(ioutil.WriteFile(publicFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0644), IsNil)
+	return privateFile, publicFile
+}
+
+func signedSnapshot(c *C, signKey string) []byte {
+	signer, err := LoadSnapshotSigner(signKey)
+	c.Assert(err, IsNil)
+	composer := &SystemComposer{Signer: signer}
+	content, err := composer.PrepareFinalizableCandidatesSnapshotContent(goldenCandidates)
+	c.Assert(err, IsNil)
+	return content
+}
+
+func verifySnapshot(c *C, content []byte, verifyKey string) error {
+	verifier, err := LoadSnapshotVerifier(verifyKey)
+	c.Assert(err, IsNil)
+	snapshot, err := ReadSnapshot(content)
+	c.Assert(err, IsNil)
+	return snapshot.VerifySignature(verifier)
+}
+
+func (s *ComposerSuite) TestSignsAndVerifiesSnapshotsWithEd25519(c *C) {
+	private, public := writeEd25519Keys(c)
+	content := signedSnapshot(c, private)
+	c.Assert(verifySnapshot(c, content, public), IsNil)
+
+	tampered := bytes.Replace(content, []byte(`"Version": "12"`), []byte(`"Version": "13"`), 1)
+	c.Assert(verifySnapshot(c, tampered, public), ErrorMatches, "snapshot signature does not match its content.*")
+
+	_, otherPublic := writeEd25519Keys(c)
+	c.Assert(verifySnapshot(c, content, otherPublic), ErrorMatches, "snapshot is signed with key [0-9a-f]+ but the verification key is [0-9a-f]+")
+
+	_, err := LoadSnapshotSigner(public)
+	c.Assert(err, NotNil)
+}
+
+func (s *ComposerSuite) TestSignsAndVerifiesSnapshotsWithHMAC(c *C) {
+	secret := filepath.Join(c.MkDir(), "snapshot.secret")
+	c.Assert(ioutil.WriteFile(secret, []byte("a shared secret of the CI jobs\n"), 0600), IsNil)
+	content := signedSnapshot(c, secret)
+	c.Assert(verifySnapshot(c, content, secret), IsNil)
+
+	tampered := bytes.Replace(content, []byte(`"Image": "postgres"`), []byte(`"Image": "evil"`), 1)
+	c.Assert(verifySnapshot(c, tampered, secret), ErrorMatches, "snapshot signature does not match its content.*")
+
+	_, public := writeEd25519Keys(c)
+	c.Assert(verifySnapshot(c, content, public), ErrorMatches, "snapshot is signed with hmac-sha256 but the verification key is for ed25519")
+
+	unsigned, err := sut.PrepareFinalizableCandidatesSnapshotContent(goldenCandidates)
+	c.Assert(err, IsNil)
+	c.Assert(verifySnapshot(c, unsigned, secret), Equals, ErrSnapshotNotSigned)
+
+	c.Assert(ioutil.WriteFile(secret, []byte("short"), 0600), IsNil)
+	_, err = LoadSnapshotVerifier(secret)
+	c.Assert(err, ErrorMatches, ".*HMAC secrets must be at least 16 bytes long")
+}
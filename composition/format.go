@@ -0,0 +1,106 @@
+package composition
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Compose file formats produced by the composer
+const (
+	// FormatV1 is the legacy flat map of services
+	FormatV1 = "1"
+	// FormatV2 is the version 2.4 file format
+	FormatV2 = "2"
+	// FormatV3 is the version 3.8 file format
+	FormatV3 = "3"
+)
+
+// StackNetwork is the network shared by all services of the composed stack
+const StackNetwork = "e2e"
+
+var fileVersions = map[string]string{FormatV2: "2.4", FormatV3: "3.8"}
+
+type composeFile struct {
+	Version  string                 `yaml:"version"`
+	Services map[string]composeSpec `yaml:"services"`
+	Networks map[string]struct{}    `yaml:"networks"`
+	Volumes  map[string]struct{}    `yaml:"volumes,omitempty"`
+}
+
+type composeDeploy struct {
+	Resources composeResources `yaml:"resources"`
+}
+
+type composeResources struct {
+	Limits composeLimits `yaml:"limits"`
+}
+
+type composeLimits struct {
+	CPUs   string `yaml:"cpus,omitempty"`
+	Memory string `yaml:"memory,omitempty"`
+}
+
+// validFormat tells whether the format is one the composer can produce
+func validFormat(format string) error {
+	if format == "" || format == FormatV1 {
+		return nil
+	}
+	if _, ok := fileVersions[format]; !ok {
+		return fmt.Errorf("unknown compose format %s, expected one of %s, %s or %s", format, FormatV1, FormatV2, FormatV3)
+	}
+	return nil
+}
+
+// namedVolumes tells whether the format declares named volumes
+func namedVolumes(format string) bool {
+	_, ok := fileVersions[format]
+	return ok
+}
+
+// isNamedVolume tells whether the source of the volume is a named volume rather than a host path
+func isNamedVolume(volume string) bool {
+	return !strings.HasPrefix(volume, "/") && !strings.HasPrefix(volume, ".") && !strings.HasPrefix(volume, "~")
+}
+
+// marshalCompose writes the services in the given format. Versioned formats attach every service to the
+// stack network, unless it chose its own network mode, and declare the named volumes the services mount.
+func marshalCompose(format string, services map[string]composeSpec) ([]byte, error) {
+	version, ok := fileVersions[format]
+	if !ok {
+		return yaml.Marshal(services)
+	}
+
+	file := composeFile{
+		Version:  version,
+		Services: make(map[string]composeSpec),
+		Networks: map[string]struct{}{StackNetwork: {}},
+	}
+	for name, svc := range services {
+		if svc.NetworkMode == "" {
+			svc.Networks = []string{StackNetwork}
+		}
+		for _, volume := range svc.Volumes {
+			if source := strings.SplitN(volume, ":", 2)[0]; isNamedVolume(source) {
+				if file.Volumes == nil {
+					file.Volumes = make(map[string]struct{})
+				}
+				file.Volumes[source] = struct{}{}
+			}
+		}
+		if format == FormatV3 && (svc.CPUs > 0 || svc.MemLimit != "") {
+			svc.Deploy = &composeDeploy{}
+			if svc.CPUs > 0 {
+				svc.Deploy.Resources.Limits.CPUs = strconv.FormatFloat(svc.CPUs, 'f', -1, 64)
+			}
+			svc.Deploy.Resources.Limits.Memory = strings.ToUpper(svc.MemLimit)
+			svc.CPUs, svc.MemLimit = 0, ""
+		}
+		sort.Strings(svc.DependsOn)
+		file.Services[name] = svc
+	}
+	return yaml.Marshal(file)
+}
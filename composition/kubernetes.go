@@ -0,0 +1,282 @@
+package composition
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bhameyie/dpipeliner/data"
+	marathon "github.com/gambol99/go-marathon"
+
+	"gopkg.in/yaml.v2"
+)
+
+// KubernetesComposer produces the kubernetes manifests of an ephemeral environment holding the candidates:
+// a Namespace, plus a Deployment and a Service for every candidate
+type KubernetesComposer struct {
+	SystemComposer
+	// Namespace overrides the namespace derived from the snapshot
+	Namespace string
+}
+
+// NewKubernetesComposer initializes a composer producing kubernetes manifests
+func NewKubernetesComposer() IComposer {
+	return &KubernetesComposer{SystemComposer: *NewComposer().(*SystemComposer)}
+}
+
+type kubeMetadata struct {
+	Name      string            `yaml:"name,omitempty"`
+	Namespace string            `yaml:"namespace,omitempty"`
+	Labels    map[string]string `yaml:"labels,omitempty"`
+}
+
+type kubeManifest struct {
+	APIVersion string       `yaml:"apiVersion"`
+	Kind       string       `yaml:"kind"`
+	Metadata   kubeMetadata `yaml:"metadata"`
+	Spec       interface{}  `yaml:"spec,omitempty"`
+}
+
+type kubeDeploymentSpec struct {
+	Replicas int `yaml:"replicas"`
+	Selector struct {
+		MatchLabels map[string]string `yaml:"matchLabels"`
+	} `yaml:"selector"`
+	Template struct {
+		Metadata kubeMetadata `yaml:"metadata"`
+		Spec     struct {
+			Containers []kubeContainer `yaml:"containers"`
+		} `yaml:"spec"`
+	} `yaml:"template"`
+}
+
+type kubeContainer struct {
+	Name            string          `yaml:"name"`
+	Image           string          `yaml:"image"`
+	Command         []string        `yaml:"command,omitempty"`
+	Args            []string        `yaml:"args,omitempty"`
+	Env             []kubeEnvVar    `yaml:"env,omitempty"`
+	Ports           []kubePort      `yaml:"ports,omitempty"`
+	Resources       *kubeResources  `yaml:"resources,omitempty"`
+	ReadinessProbe  *kubeProbe      `yaml:"readinessProbe,omitempty"`
+	SecurityContext map[string]bool `yaml:"securityContext,omitempty"`
+}
+
+type kubeEnvVar struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+type kubePort struct {
+	Name          string `yaml:"name,omitempty"`
+	ContainerPort int    `yaml:"containerPort,omitempty"`
+	Port          int    `yaml:"port,omitempty"`
+	TargetPort    int    `yaml:"targetPort,omitempty"`
+	Protocol      string `yaml:"protocol,omitempty"`
+}
+
+type kubeResources struct {
+	Limits map[string]string `yaml:"limits"`
+}
+
+type kubeProbe struct {
+	HTTPGet             *kubeHTTPGet `yaml:"httpGet,omitempty"`
+	Exec                *kubeExec    `yaml:"exec,omitempty"`
+	InitialDelaySeconds int          `yaml:"initialDelaySeconds,omitempty"`
+	PeriodSeconds       int          `yaml:"periodSeconds,omitempty"`
+	TimeoutSeconds      int          `yaml:"timeoutSeconds,omitempty"`
+	FailureThreshold    int          `yaml:"failureThreshold,omitempty"`
+}
+
+type kubeHTTPGet struct {
+	Path   string `yaml:"path,omitempty"`
+	Port   int    `yaml:"port"`
+	Scheme string `yaml:"scheme,omitempty"`
+}
+
+type kubeExec struct {
+	Command []string `yaml:"command"`
+}
+
+type kubeServiceSpec struct {
+	Selector map[string]string `yaml:"selector"`
+	Ports    []kubePort        `yaml:"ports"`
+}
+
+var invalidNameChars = regexp.MustCompile("[^a-z0-9-]+")
+
+// kubeName turns the service name into a valid kubernetes object name, a DNS-1035 label starting with a letter
+func kubeName(name string) string {
+	name = strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if name == "" || name[0] < 'a' || name[0] > 'z' {
+		name = "svc-" + name
+	}
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return strings.Trim(name, "-")
+}
+
+// namespace is the namespace of the environment, e2e- followed by the start of the snapshot id unless overridden
+func (kc *KubernetesComposer) namespace(candidates []data.DeploymentCandidate) string {
+	if kc.Namespace != "" {
+		return kubeName(kc.Namespace)
+	}
+	return "e2e-" + SnapshotID(candidates)[:12]
+}
+
+// PrepareComposerContent produces the kubernetes manifests of the candidates as a multi document yaml
+func (kc *KubernetesComposer) PrepareComposerContent(candidates []data.DeploymentCandidate) ([]byte, error) {
+	if candidates == nil || len(candidates) == 0 {
+		return nil, errors.New("No candidates found")
+	}
+	apps, _, err := kc.marathonApps(candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := kc.namespace(candidates)
+	manifests := []kubeManifest{{APIVersion: "v1", Kind: "Namespace", Metadata: kubeMetadata{Name: namespace}}}
+	for i, candidate := range candidates {
+		app := apps[i]
+		if app == nil {
+			app = &marathonApp{Application: &marathon.Application{}}
+		}
+		name := kubeName(candidate.ServiceName)
+		container, unsupported := kubeContainerOf(app, name, candidate.Image)
+		for _, field := range unsupported {
+			kc.report(candidate.ServiceName, field+" is not supported")
+		}
+
+		labels := map[string]string{"app": name}
+		deployment := kubeDeploymentSpec{Replicas: 1}
+		deployment.Selector.MatchLabels = labels
+		deployment.Template.Metadata = kubeMetadata{Labels: labels}
+		deployment.Template.Spec.Containers = []kubeContainer{container}
+		manifests = append(manifests, kubeManifest{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+			Metadata:   kubeMetadata{Name: name, Namespace: namespace, Labels: labels},
+			Spec:       deployment,
+		})
+
+		if len(container.Ports) > 0 {
+			service := kubeServiceSpec{Selector: labels}
+			for _, port := range container.Ports {
+				service.Ports = append(service.Ports, kubePort{
+					Name: port.Name, Port: port.ContainerPort, TargetPort: port.ContainerPort, Protocol: port.Protocol,
+				})
+			}
+			manifests = append(manifests, kubeManifest{
+				APIVersion: "v1",
+				Kind:       "Service",
+				Metadata:   kubeMetadata{Name: name, Namespace: namespace, Labels: labels},
+				Spec:       service,
+			})
+		}
+	}
+
+	var buf bytes.Buffer
+	for i, manifest := range manifests {
+		content, err := yaml.Marshal(manifest)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(content)
+	}
+	return buf.Bytes(), nil
+}
+
+// kubeContainerOf derives the container running the app. Everything that cannot be translated is returned as unsupported.
+func kubeContainerOf(app *marathonApp, name, image string) (kubeContainer, []string) {
+	svc, unsupported := composeService(app, image, nil, false)
+	container := kubeContainer{Name: name, Image: svc.Image, Args: app.Args}
+	if app.Cmd != "" {
+		container.Command = []string{"/bin/sh", "-c", app.Cmd}
+	}
+
+	var names []string
+	for k := range svc.Environment {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		container.Env = append(container.Env, kubeEnvVar{Name: k, Value: svc.Environment[k]})
+	}
+
+	if app.Container != nil && app.Container.Docker != nil {
+		for i, pm := range app.Container.Docker.PortMappings {
+			port := kubePort{Name: "port" + strconv.Itoa(i), ContainerPort: pm.ContainerPort}
+			if protocol := strings.ToUpper(pm.Protocol); protocol != "" && protocol != "TCP" {
+				port.Protocol = protocol
+			}
+			container.Ports = append(container.Ports, port)
+		}
+		if app.Container.Docker.Privileged {
+			container.SecurityContext = map[string]bool{"privileged": true}
+		}
+	}
+	if svc.CPUs > 0 || svc.MemLimit != "" {
+		container.Resources = &kubeResources{Limits: map[string]string{}}
+		if svc.CPUs > 0 {
+			container.Resources.Limits["cpu"] = strconv.FormatFloat(svc.CPUs, 'f', -1, 64)
+		}
+		if app.Mem > 0 {
+			container.Resources.Limits["memory"] = fmt.Sprintf("%dMi", int(app.Mem))
+		}
+	}
+	container.ReadinessProbe = readinessProbe(app)
+
+	// compose specific reports do not apply to a pod, volumes and network modes do not translate at all
+	var reported []string
+	for _, field := range unsupported {
+		if !strings.HasPrefix(field, "dependencies ") && !strings.HasPrefix(field, "container.volumes ") {
+			reported = append(reported, field)
+		}
+	}
+	if app.Container != nil && len(app.Container.Volumes) > 0 {
+		reported = append(reported, "container.volumes")
+	}
+	if svc.NetworkMode != "" {
+		reported = append(reported, "container.docker.network "+app.Container.Docker.Network)
+	}
+	return container, reported
+}
+
+// readinessProbe translates the first marathon health check into a readiness probe
+func readinessProbe(app *marathonApp) *kubeProbe {
+	if len(app.HealthChecks) == 0 {
+		return nil
+	}
+	hc := app.HealthChecks[0]
+	probe := &kubeProbe{
+		InitialDelaySeconds: hc.GracePeriodSeconds,
+		PeriodSeconds:       hc.IntervalSeconds,
+		TimeoutSeconds:      hc.TimeoutSeconds,
+		FailureThreshold:    hc.MaxConsecutiveFailures,
+	}
+	protocol := strings.ToUpper(hc.Protocol)
+	switch {
+	case strings.HasSuffix(protocol, "COMMAND") && hc.Command != nil:
+		probe.Exec = &kubeExec{Command: []string{"/bin/sh", "-c", hc.Command.Value}}
+	case protocol == "" || strings.Contains(protocol, "HTTP"):
+		port := healthCheckPort(app)
+		if port == 0 {
+			return nil
+		}
+		probe.HTTPGet = &kubeHTTPGet{Path: hc.Path, Port: port}
+		if strings.HasSuffix(protocol, "HTTPS") {
+			probe.HTTPGet.Scheme = "HTTPS"
+		}
+	default:
+		return nil
+	}
+	return probe
+}
@@ -0,0 +1,262 @@
+package composition
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	marathon "github.com/gambol99/go-marathon"
+)
+
+// ignoredFields are marathon fields that only matter to the scheduler and have no compose equivalent worth reporting
+var ignoredFields = map[string]bool{
+	"id": true, "instances": true, "constraints": true, "acceptedResourceRoles": true, "upgradeStrategy": true,
+	"backoffSeconds": true, "backoffFactor": true, "maxLaunchDelaySeconds": true, "requirePorts": true,
+	"version": true, "versionInfo": true, "killSelection": true, "unreachableStrategy": true, "disk": true,
+}
+
+// translatedFields are marathon fields mapped onto compose service definitions
+var translatedFields = map[string]bool{
+	"env": true, "cmd": true, "args": true, "container": true, "labels": true, "healthChecks": true,
+	"dependencies": true, "cpus": true, "mem": true,
+}
+
+// marathonApp is a marathon app along with the explicit ports of its health checks, which go-marathon does not model
+type marathonApp struct {
+	*marathon.Application
+	healthCheckPorts []int
+}
+
+// appID is the absolute id of the app
+func appID(app *marathonApp) string {
+	if app.ID == "" {
+		return ""
+	}
+	return "/" + strings.Trim(app.ID, "/")
+}
+
+// dependencyIDs resolves the dependencies of the app, relative ones against the group of the app
+func dependencyIDs(app *marathonApp) []string {
+	var ids []string
+	for _, dep := range app.Dependencies {
+		if !strings.HasPrefix(dep, "/") {
+			dep = path.Join(path.Dir(appID(app)), dep)
+		}
+		ids = append(ids, path.Clean(dep))
+	}
+	return ids
+}
+
+// parseMarathonApp parses the spec into a marathon app, returning the fields that cannot be translated.
+// Env values other than strings, such as secrets, are left out of the app.
+func parseMarathonApp(content []byte) (*marathonApp, []string, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil, nil, err
+	}
+	var unsupported []string
+	for field := range fields {
+		if !ignoredFields[field] && !translatedFields[field] {
+			unsupported = append(unsupported, "marathon field "+field)
+		}
+	}
+	if raw, ok := fields["env"]; ok {
+		env := map[string]json.RawMessage{}
+		if err := json.Unmarshal(raw, &env); err != nil {
+			return nil, nil, err
+		}
+		for k, value := range env {
+			var s string
+			if err := json.Unmarshal(value, &s); err != nil {
+				unsupported = append(unsupported, "env."+k)
+				delete(env, k)
+			}
+		}
+		var err error
+		if fields["env"], err = json.Marshal(env); err != nil {
+			return nil, nil, err
+		}
+		if content, err = json.Marshal(fields); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	app := &marathonApp{Application: new(marathon.Application)}
+	if err := json.Unmarshal(content, app.Application); err != nil {
+		return nil, nil, err
+	}
+	var checks []struct {
+		Port int `json:"port"`
+	}
+	if raw, ok := fields["healthChecks"]; ok {
+		if err := json.Unmarshal(raw, &checks); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, check := range checks {
+		app.healthCheckPorts = append(app.healthCheckPorts, check.Port)
+	}
+	sort.Strings(unsupported)
+	return app, unsupported, nil
+}
+
+// healthcheck translates the first marathon health check into a compose healthcheck probing from within the container
+func healthcheck(app *marathonApp) (*composeHealthcheck, []string) {
+	if len(app.HealthChecks) == 0 {
+		return nil, nil
+	}
+	var unsupported []string
+	if len(app.HealthChecks) > 1 {
+		unsupported = append(unsupported, "healthChecks beyond the first")
+	}
+	hc := app.HealthChecks[0]
+	check := &composeHealthcheck{Retries: hc.MaxConsecutiveFailures}
+	if hc.IntervalSeconds > 0 {
+		check.Interval = fmt.Sprintf("%ds", hc.IntervalSeconds)
+	}
+	if hc.TimeoutSeconds > 0 {
+		check.Timeout = fmt.Sprintf("%ds", hc.TimeoutSeconds)
+	}
+	if hc.GracePeriodSeconds > 0 {
+		check.StartPeriod = fmt.Sprintf("%ds", hc.GracePeriodSeconds)
+	}
+
+	switch strings.ToUpper(hc.Protocol) {
+	case "COMMAND", "MESOS_COMMAND":
+		if hc.Command == nil {
+			return nil, append(unsupported, "healthChecks without command")
+		}
+		check.Test = []string{"CMD-SHELL", hc.Command.Value}
+	case "HTTP", "HTTPS", "MESOS_HTTP", "MESOS_HTTPS", "":
+		port := healthCheckPort(app)
+		if port == 0 {
+			return nil, append(unsupported, "healthChecks without a container port")
+		}
+		scheme := "http"
+		if strings.HasSuffix(strings.ToUpper(hc.Protocol), "HTTPS") {
+			scheme = "https"
+		}
+		url := fmt.Sprintf("%s://localhost:%d%s", scheme, port, hc.Path)
+		check.Test = []string{"CMD-SHELL", "curl -f " + url + " || exit 1"}
+	default:
+		return nil, append(unsupported, hc.Protocol+" healthChecks")
+	}
+	return check, unsupported
+}
+
+// healthCheckPort is the port probed by the first health check, its explicit port or the container port at its index
+func healthCheckPort(app *marathonApp) int {
+	if len(app.healthCheckPorts) > 0 && app.healthCheckPorts[0] != 0 {
+		return app.healthCheckPorts[0]
+	}
+	return containerPort(app, app.HealthChecks[0].PortIndex)
+}
+
+func containerPort(app *marathonApp, index int) int {
+	if app.Container == nil || app.Container.Docker == nil || index >= len(app.Container.Docker.PortMappings) {
+		return 0
+	}
+	return app.Container.Docker.PortMappings[index].ContainerPort
+}
+
+// volumeName names the compose volume standing for a marathon persistent volume of the app
+func volumeName(appID, containerPath string) string {
+	name := strings.Trim(appID+"/"+containerPath, "/")
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == ' ' {
+			return '-'
+		}
+		return r
+	}, name)
+}
+
+// composeService derives the compose service of the app. The apps of the composition map app ids to service
+// names so that dependencies become depends_on. Persistent volumes become named volumes when the format
+// has them. Everything that cannot be translated is returned as unsupported.
+func composeService(app *marathonApp, image string, services map[string]string, namedVolumes bool) (composeSpec, []string) {
+	svc := composeSpec{Image: image, Labels: app.Labels, CPUs: app.CPUs}
+	var unsupported []string
+
+	if len(app.Env) > 0 {
+		svc.Environment = make(map[string]string)
+		for k, value := range app.Env {
+			svc.Environment[k] = value
+		}
+	}
+	if app.Cmd != "" {
+		svc.Command = app.Cmd
+	} else if len(app.Args) > 0 {
+		svc.Command = app.Args
+	}
+	if app.Mem > 0 {
+		svc.MemLimit = fmt.Sprintf("%dm", int(app.Mem))
+	}
+
+	if app.Container != nil {
+		if app.Container.Type != "" && strings.ToUpper(app.Container.Type) != "DOCKER" {
+			unsupported = append(unsupported, "container.type "+app.Container.Type)
+		}
+		if docker := app.Container.Docker; docker != nil {
+			if svc.Image == "" {
+				svc.Image = docker.Image
+			}
+			svc.Privileged = docker.Privileged
+			switch strings.ToUpper(docker.Network) {
+			case "HOST":
+				svc.NetworkMode = "host"
+			case "NONE":
+				svc.NetworkMode = "none"
+			}
+			for _, pm := range docker.PortMappings {
+				port := fmt.Sprintf("%d", pm.ContainerPort)
+				if pm.HostPort > 0 {
+					port = fmt.Sprintf("%d:%d", pm.HostPort, pm.ContainerPort)
+				}
+				if pm.Protocol != "" && strings.ToLower(pm.Protocol) != "tcp" {
+					port += "/" + strings.ToLower(pm.Protocol)
+				}
+				svc.Ports = append(svc.Ports, port)
+			}
+			if len(docker.Parameters) > 0 {
+				unsupported = append(unsupported, "container.docker.parameters")
+			}
+		}
+		for _, v := range app.Container.Volumes {
+			source := v.HostPath
+			switch {
+			case strings.HasPrefix(source, "/"):
+			case !namedVolumes:
+				unsupported = append(unsupported, "container.volumes "+v.ContainerPath+" without an absolute hostPath")
+				continue
+			case source == "" && !strings.HasPrefix(v.ContainerPath, "/"):
+				// a persistent volume, mounted through the volumes whose hostPath refers to it
+				continue
+			case source == "":
+				source = volumeName(appID(app), v.ContainerPath)
+			default:
+				source = volumeName(appID(app), source)
+			}
+			volume := source + ":" + v.ContainerPath
+			if strings.ToUpper(v.Mode) == "RO" {
+				volume += ":ro"
+			}
+			svc.Volumes = append(svc.Volumes, volume)
+		}
+	}
+
+	check, reported := healthcheck(app)
+	svc.Healthcheck = check
+	unsupported = append(unsupported, reported...)
+
+	for _, dep := range dependencyIDs(app) {
+		name, ok := services[dep]
+		if !ok {
+			unsupported = append(unsupported, "dependencies "+dep+" outside of the composition")
+			continue
+		}
+		svc.DependsOn = append(svc.DependsOn, name)
+	}
+	return svc, unsupported
+}
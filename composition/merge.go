@@ -0,0 +1,169 @@
+package composition
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// topLevelKeys are the sections a versioned compose file may hold
+var topLevelKeys = map[string]bool{"version": true, "services": true, "networks": true, "volumes": true, "configs": true, "secrets": true}
+
+// setLikeKeys are the lists of a service whose items add up rather than replace each other
+var setLikeKeys = map[string]bool{
+	"depends_on": true, "networks": true, "volumes": true, "volumes_from": true, "ports": true, "expose": true,
+	"links": true, "external_links": true, "extra_hosts": true, "env_file": true, "secrets": true, "configs": true,
+	"devices": true, "dns": true, "dns_search": true, "cap_add": true, "cap_drop": true, "security_opt": true,
+	"tmpfs": true, "group_add": true,
+}
+
+// mergeError reports values of the same path that cannot be merged, e.g. a mapping over a list
+type mergeError struct {
+	Path   string
+	Source string
+}
+
+func (e *mergeError) Error() string {
+	return fmt.Sprintf("%s in %s cannot be merged with the value it overrides, their types differ", e.Path, e.Source)
+}
+
+// mergeBases deep-merges the composed content over the base files, in the following precedence order:
+//
+//  1. base files are merged in the given order, each overriding the ones before it
+//  2. the composed candidate services override all base files
+//
+// Mappings merge key by key, so a base may add to a candidate service (extra environment, networks...) or
+// declare services, networks and volumes of its own. Set-like lists such as depends_on, networks or ports
+// are united, keeping the items of the base first. Other scalars and lists are replaced as a whole by the
+// overriding file; the composed candidates replacing a different value of the bases is reported as a conflict,
+// while a base overriding an earlier one is intended and silent.
+func (sc *SystemComposer) mergeBases(composed []byte) ([]byte, error) {
+	merged := yaml.MapSlice{}
+	for _, base := range sc.Bases {
+		content, err := ioutil.ReadFile(base)
+		if err != nil {
+			return nil, err
+		}
+		doc := yaml.MapSlice{}
+		if err := yaml.Unmarshal(content, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %v", base, err)
+		}
+		if err := sc.checkLayout(base, doc); err != nil {
+			return nil, err
+		}
+		if merged, err = sc.merge(merged, doc, "", base, false); err != nil {
+			return nil, err
+		}
+	}
+
+	doc := yaml.MapSlice{}
+	if err := yaml.Unmarshal(composed, &doc); err != nil {
+		return nil, err
+	}
+	merged, err := sc.merge(merged, doc, "", "the composed candidates", true)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(merged)
+}
+
+// checkLayout ensures a base file is laid out like the composed file, a flat map of services for FormatV1
+// and a versioned file otherwise
+func (sc *SystemComposer) checkLayout(base string, doc yaml.MapSlice) error {
+	if !namedVolumes(sc.Format) {
+		if _, versioned := lookup(doc, "services"); versioned {
+			return fmt.Errorf("%s is a versioned compose file but the compose format is %s", base, FormatV1)
+		}
+		return nil
+	}
+	for _, item := range doc {
+		key := fmt.Sprint(item.Key)
+		if !topLevelKeys[key] && !strings.HasPrefix(key, "x-") {
+			return fmt.Errorf("%s: unexpected top level key %s, is it a version %s compose file?", base, key, FormatV1)
+		}
+	}
+	return nil
+}
+
+func lookup(doc yaml.MapSlice, key string) (interface{}, bool) {
+	for _, item := range doc {
+		if fmt.Sprint(item.Key) == key {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
+
+// merge overrides the base mapping with the given one, keeping the order of the keys of the base. Conflicts
+// are reported when asked to.
+func (sc *SystemComposer) merge(base, over yaml.MapSlice, path, source string, conflicts bool) (yaml.MapSlice, error) {
+	merged := append(yaml.MapSlice{}, base...)
+	for _, item := range over {
+		key := fmt.Sprint(item.Key)
+		at := key
+		if path != "" {
+			at = path + "." + key
+		}
+
+		index := -1
+		for i, existing := range merged {
+			if fmt.Sprint(existing.Key) == key {
+				index = i
+			}
+		}
+		if index < 0 {
+			merged = append(merged, item)
+			continue
+		}
+
+		previous := merged[index].Value
+		baseMap, baseIsMap := previous.(yaml.MapSlice)
+		overMap, overIsMap := item.Value.(yaml.MapSlice)
+		switch {
+		case baseIsMap && overIsMap:
+			value, err := sc.merge(baseMap, overMap, at, source, conflicts)
+			if err != nil {
+				return nil, err
+			}
+			merged[index].Value = value
+		case previous == nil || item.Value == nil:
+			// an empty entry such as `db:` or `e2e: {}` only declares the key
+			if item.Value != nil {
+				merged[index].Value = item.Value
+			}
+		case baseIsMap || overIsMap:
+			return nil, &mergeError{Path: at, Source: source}
+		case setLikeKeys[key] && isList(previous) && isList(item.Value):
+			merged[index].Value = union(previous.([]interface{}), item.Value.([]interface{}))
+		default:
+			if conflicts && !reflect.DeepEqual(previous, item.Value) {
+				sc.log(fmt.Sprintf("compose conflict: %s is overridden by %s (%v replaces %v)", at, source, item.Value, previous))
+			}
+			merged[index].Value = item.Value
+		}
+	}
+	return merged, nil
+}
+
+func isList(value interface{}) bool {
+	_, ok := value.([]interface{})
+	return ok
+}
+
+// union appends the items of over missing from base
+func union(base, over []interface{}) []interface{} {
+	res := append([]interface{}{}, base...)
+	for _, item := range over {
+		found := false
+		for _, existing := range res {
+			found = found || reflect.DeepEqual(existing, item)
+		}
+		if !found {
+			res = append(res, item)
+		}
+	}
+	return res
+}
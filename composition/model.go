@@ -10,10 +10,33 @@ type IComposer interface {
 
 //NonValidatedCandidates represents candidates for E2E testing
 type NonValidatedCandidates struct {
-	Service string
-	Version string
+	Service  string
+	Version  string
+	Image    string `json:",omitempty"`
+	SpecHash string `json:",omitempty"`
 }
 
 type composeSpec struct {
-	Image string
+	Image       string              `yaml:"image"`
+	Command     interface{}         `yaml:"command,omitempty"`
+	Environment map[string]string   `yaml:"environment,omitempty"`
+	Ports       []string            `yaml:"ports,omitempty"`
+	Volumes     []string            `yaml:"volumes,omitempty"`
+	Labels      map[string]string   `yaml:"labels,omitempty"`
+	NetworkMode string              `yaml:"network_mode,omitempty"`
+	Privileged  bool                `yaml:"privileged,omitempty"`
+	CPUs        float64             `yaml:"cpus,omitempty"`
+	MemLimit    string              `yaml:"mem_limit,omitempty"`
+	Healthcheck *composeHealthcheck `yaml:"healthcheck,omitempty"`
+	DependsOn   []string            `yaml:"depends_on,omitempty"`
+	Networks    []string            `yaml:"networks,omitempty"`
+	Deploy      *composeDeploy      `yaml:"deploy,omitempty"`
+}
+
+type composeHealthcheck struct {
+	Test        []string `yaml:"test"`
+	Interval    string   `yaml:"interval,omitempty"`
+	Timeout     string   `yaml:"timeout,omitempty"`
+	Retries     int      `yaml:"retries,omitempty"`
+	StartPeriod string   `yaml:"start_period,omitempty"`
 }
@@ -0,0 +1,203 @@
+package composition
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// Snapshot signature algorithms
+const (
+	HMACSHA256 = "hmac-sha256"
+	Ed25519    = "ed25519"
+)
+
+// ErrSnapshotNotSigned is returned when verifying a snapshot that carries no signature
+var ErrSnapshotNotSigned = errors.New("snapshot is not signed")
+
+// SnapshotSignature is the signature of the snapshot content, the snapshot without its signature
+type SnapshotSignature struct {
+	Algorithm string
+	KeyID     string
+	Value     string
+}
+
+// SnapshotSigner signs the snapshots written by the composer
+type SnapshotSigner interface {
+	Sign(payload []byte) (*SnapshotSignature, error)
+}
+
+// SnapshotVerifier verifies the signature of the snapshots read back
+type SnapshotVerifier interface {
+	Verify(payload []byte, signature *SnapshotSignature) error
+}
+
+type hmacKey []byte
+
+func (k hmacKey) keyID() string {
+	sum := sha256.Sum256(k)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (k hmacKey) mac(payload []byte) []byte {
+	mac := hmac.New(sha256.New, k)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (k hmacKey) Sign(payload []byte) (*SnapshotSignature, error) {
+	return &SnapshotSignature{Algorithm: HMACSHA256, KeyID: k.keyID(), Value: base64.StdEncoding.EncodeToString(k.mac(payload))}, nil
+}
+
+func (k hmacKey) Verify(payload []byte, signature *SnapshotSignature) error {
+	value, err := checkSignature(signature, HMACSHA256, k.keyID())
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(value, k.mac(payload)) {
+		return errTampered
+	}
+	return nil
+}
+
+type ed25519Signer ed25519.PrivateKey
+
+func (k ed25519Signer) Sign(payload []byte) (*SnapshotSignature, error) {
+	key := ed25519.PrivateKey(k)
+	value := ed25519.Sign(key, payload)
+	return &SnapshotSignature{
+		Algorithm: Ed25519,
+		KeyID:     ed25519Verifier(key.Public().(ed25519.PublicKey)).keyID(),
+		Value:     base64.StdEncoding.EncodeToString(value),
+	}, nil
+}
+
+type ed25519Verifier ed25519.PublicKey
+
+func (k ed25519Verifier) keyID() string {
+	sum := sha256.Sum256(k)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (k ed25519Verifier) Verify(payload []byte, signature *SnapshotSignature) error {
+	value, err := checkSignature(signature, Ed25519, k.keyID())
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(ed25519.PublicKey(k), payload, value) {
+		return errTampered
+	}
+	return nil
+}
+
+var errTampered = errors.New("snapshot signature does not match its content, the snapshot was modified after it was signed")
+
+// checkSignature ensures the signature was made with the expected algorithm and key, and decodes its value
+func checkSignature(signature *SnapshotSignature, algorithm, keyID string) ([]byte, error) {
+	if signature == nil {
+		return nil, ErrSnapshotNotSigned
+	}
+	if signature.Algorithm != algorithm {
+		return nil, fmt.Errorf("snapshot is signed with %s but the verification key is for %s", signature.Algorithm, algorithm)
+	}
+	if signature.KeyID != keyID {
+		return nil, fmt.Errorf("snapshot is signed with key %s but the verification key is %s", signature.KeyID, keyID)
+	}
+	value, err := base64.StdEncoding.DecodeString(signature.Value)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot signature is malformed: %v", err)
+	}
+	return value, nil
+}
+
+// readKeyFile reads a PEM block from the key file, nil when the file holds a raw HMAC secret
+func readKeyFile(path string) (*pem.Block, []byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if block, _ := pem.Decode(content); block != nil {
+		return block, nil, nil
+	}
+	secret := bytes.TrimSpace(content)
+	if len(secret) < 16 {
+		return nil, nil, fmt.Errorf("%s: HMAC secrets must be at least 16 bytes long", path)
+	}
+	return nil, secret, nil
+}
+
+// LoadSnapshotSigner reads the key signing snapshots from a file holding either an ed25519 private key in
+// PKCS #8 PEM form, or an HMAC secret
+func LoadSnapshotSigner(path string) (SnapshotSigner, error) {
+	block, secret, err := readKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return hmacKey(secret), nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: only ed25519 private keys can sign snapshots", path)
+	}
+	return ed25519Signer(private), nil
+}
+
+// LoadSnapshotVerifier reads the key verifying snapshots from a file holding either an ed25519 public key in
+// PKIX PEM form, or the HMAC secret the snapshots were signed with
+func LoadSnapshotVerifier(path string) (SnapshotVerifier, error) {
+	block, secret, err := readKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return hmacKey(secret), nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: only ed25519 public keys can verify snapshots", path)
+	}
+	return ed25519Verifier(public), nil
+}
+
+// payload is the signed content of the snapshot
+func (s Snapshot) payload() ([]byte, error) {
+	s.Signature = nil
+	return json.Marshal(s)
+}
+
+// Sign attaches the signature of its content to the snapshot
+func (s *Snapshot) Sign(signer SnapshotSigner) error {
+	payload, err := s.payload()
+	if err != nil {
+		return err
+	}
+	s.Signature, err = signer.Sign(payload)
+	return err
+}
+
+// VerifySignature ensures the snapshot was signed with the key of the verifier and not modified since
+func (s Snapshot) VerifySignature(verifier SnapshotVerifier) error {
+	payload, err := s.payload()
+	if err != nil {
+		return err
+	}
+	return verifier.Verify(payload, s.Signature)
+}
@@ -0,0 +1,92 @@
+package composition
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bhameyie/dpipeliner/data"
+)
+
+// SnapshotFormatVersion is the version of the snapshot files written by the composer. Version 1 snapshots
+// are the bare list of services and versions.
+const SnapshotFormatVersion = 2
+
+// Snapshot lists the candidates composed for E2E testing, along with what is needed to verify that the
+// candidates deployed afterwards are the ones that were tested
+type Snapshot struct {
+	FormatVersion int
+	ID            string
+	Created       time.Time
+	Catalog       string
+	Candidates    []NonValidatedCandidates
+	Signature     *SnapshotSignature `json:",omitempty"`
+}
+
+// awaitingValidation tells whether the candidate belongs to the snapshot
+func awaitingValidation(candidate data.DeploymentCandidate) bool {
+	return !candidate.E2E && !candidate.Completed
+}
+
+// SpecHash fingerprints the spec stored with a candidate
+func SpecHash(spec string) string {
+	sum := sha256.Sum256([]byte(spec))
+	return hex.EncodeToString(sum[:])
+}
+
+// SnapshotID identifies the snapshot of the candidates, i.e. the services, versions, images and specs
+// awaiting validation along with the validated versions composed next to them, so that a candidate rebuilt,
+// given another spec or tested against other versions makes another snapshot
+func SnapshotID(candidates []data.DeploymentCandidate) string {
+	var entries []string
+	composed := make(map[string]data.DeploymentCandidate)
+	for _, candidate := range candidates {
+		if awaitingValidation(candidate) {
+			entries = append(entries, candidate.ServiceName+"@"+candidate.Version+" "+candidate.Image+" "+SpecHash(candidate.Spec))
+		}
+		// the last candidate of a service is the one composed
+		composed[candidate.ServiceName] = candidate
+	}
+	for service, candidate := range composed {
+		if !awaitingValidation(candidate) {
+			entries = append(entries, service+"@"+candidate.Version+" validated")
+		}
+	}
+	sort.Strings(entries)
+	sum := sha256.Sum256([]byte(strings.Join(entries, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// ReadSnapshot reads the content of a snapshot file of any format version
+func ReadSnapshot(content []byte) (Snapshot, error) {
+	snapshot := Snapshot{}
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '[' {
+		snapshot.FormatVersion = 1
+		err := json.Unmarshal(trimmed, &snapshot.Candidates)
+		return snapshot, err
+	}
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return snapshot, err
+	}
+	if snapshot.FormatVersion < 2 || snapshot.FormatVersion > SnapshotFormatVersion {
+		return snapshot, fmt.Errorf("unsupported snapshot format version %d", snapshot.FormatVersion)
+	}
+	return snapshot, nil
+}
+
+// Verify ensures the candidate is the one that was snapshotted, i.e. has the same image and spec. Candidates
+// of version 1 snapshots carry neither and cannot be verified.
+func (nvc NonValidatedCandidates) Verify(candidate data.DeploymentCandidate) error {
+	if nvc.Image != "" && nvc.Image != candidate.Image {
+		return fmt.Errorf("%s %s image changed since the snapshot, %s instead of %s", nvc.Service, nvc.Version, candidate.Image, nvc.Image)
+	}
+	if nvc.SpecHash != "" && nvc.SpecHash != SpecHash(candidate.Spec) {
+		return fmt.Errorf("%s %s spec changed since the snapshot", nvc.Service, nvc.Version)
+	}
+	return nil
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bhameyie/dpipeliner/composition"
 	"github.com/bhameyie/dpipeliner/data"
 	"github.com/bhameyie/dpipeliner/deployer"
+	"github.com/bhameyie/dpipeliner/spec"
 )
 
 const snapshotFile = "candidateSnapper.json"
@@ -16,24 +20,40 @@ const composerFile = "docker-compose.yml"
 
 // Controller performs defined operations using internal constructs
 type Controller struct {
-	Repo     data.IRepository
-	Composer composition.IComposer
-	Deployer deployer.IDeployer
-}
+	Repo        data.IRepository
+	Composer    composition.IComposer
+	Deployer    deployer.IDeployer
+	Deployers   map[string]deployer.IDeployer
+	Environment string
+	Labels      map[string]string
+	Vars        map[string]string
+	LintRules   []string
+	HTTPClient  *http.Client
+	Parallel    int
+	// SnapshotVerifier rejects the snapshots it cannot verify when set
+	SnapshotVerifier composition.SnapshotVerifier
+	// SnapshotID selects the stored snapshot used instead of the snapshot file
+	SnapshotID string
+	// SkipSnapshotFile keeps composed snapshots in the repository only
+	SkipSnapshotFile bool
+	// Workspace locates the snapshot and composer files
+	Workspace Workspace
+	// Selection narrows down the candidates composed for E2E testing
+	Selection SelectionRules
 
-func readNonValidatedCandidates(content string) (candidates []composition.NonValidatedCandidates, err error) {
-	dec := json.NewDecoder(strings.NewReader(content))
-	err = dec.Decode(&candidates)
-	return
+	Environments []Environment
 }
 
 func (c *Controller) updateStateForNonValidatedCandidates(state, fileContent string) error {
-	candidates, err := readNonValidatedCandidates(fileContent)
+	snapshot, err := c.readSnapshot([]byte(fileContent), c.Workspace.SnapshotPath())
 	if err != nil {
 		return err
 	}
+	return c.completeStageForSnapshot(snapshot, state)
+}
 
-	for _, candidate := range candidates {
+func (c *Controller) completeStageForSnapshot(snapshot composition.Snapshot, state string) error {
+	for _, candidate := range snapshot.Candidates {
 		if err := c.CompleteStageFor(candidate.Service, candidate.Version, state); err != nil {
 			return err
 		}
@@ -46,81 +66,421 @@ func (c *Controller) Dispose() error {
 	return c.Repo.Dispose()
 }
 
-// DeploySnapshot deploys all candidates from the snapshotFile
+// readSnapshot reads the snapshot content, verifying its signature when the controller has a SnapshotVerifier
+func (c *Controller) readSnapshot(content []byte, source string) (composition.Snapshot, error) {
+	snapshot, err := composition.ReadSnapshot(content)
+	if err != nil {
+		return snapshot, err
+	}
+	if c.SnapshotVerifier != nil {
+		if err := snapshot.VerifySignature(c.SnapshotVerifier); err != nil {
+			return snapshot, errors.New(source + " failed verification: " + err.Error())
+		}
+	}
+	if snapshot.FormatVersion < 2 {
+		fmt.Println("snapshot format " + strconv.Itoa(snapshot.FormatVersion) + " does not record images and specs, candidates cannot be verified")
+	}
+	return snapshot, nil
+}
+
+// loadSnapshot reads the snapshot stored in the repository under SnapshotID, or the snapshot file when
+// no id is given. It also returns the state of the stored snapshot, empty when the snapshot is not stored.
+func (c *Controller) loadSnapshot() (composition.Snapshot, string, error) {
+	source := c.Workspace.SnapshotPath()
+	var content []byte
+	if c.SnapshotID != "" {
+		stored, err := c.Repo.FindSnapshot(c.SnapshotID)
+		if err != nil {
+			return composition.Snapshot{}, "", err
+		}
+		source, content = "snapshot "+stored.ID, []byte(stored.Content)
+	} else {
+		b, err := ioutil.ReadFile(source)
+		if err != nil {
+			return composition.Snapshot{}, "", err
+		}
+		content = b
+	}
+
+	snapshot, err := c.readSnapshot(content, source)
+	if err != nil || snapshot.ID == "" {
+		return snapshot, "", err
+	}
+	stored, err := c.Repo.FindSnapshot(snapshot.ID)
+	if _, notFound := err.(data.SnapshotNotFoundError); notFound {
+		// a snapshot file composed elsewhere has no lifecycle to follow
+		return snapshot, "", nil
+	}
+	if err != nil {
+		return snapshot, "", err
+	}
+	if stored.State == data.SnapshotRejected {
+		return snapshot, stored.State, errors.New("snapshot " + stored.ID + " was rejected")
+	}
+	return snapshot, stored.State, nil
+}
+
+// advanceSnapshot moves the stored snapshot to the given state
+func (c *Controller) advanceSnapshot(snapshot composition.Snapshot, stored, state string) error {
+	if stored == "" {
+		return nil
+	}
+	if err := c.Repo.ChangeSnapshotState(snapshot.ID, state); err != nil {
+		return err
+	}
+	fmt.Println("snapshot " + snapshot.ID + " is " + state)
+	return nil
+}
+
+// DeploySnapshot deploys all candidates from the snapshot, each one once the candidates it depends on
+// are deployed and up to Parallel at a time
 func (c *Controller) DeploySnapshot() error {
-	b, err := ioutil.ReadFile(snapshotFile)
+	snapshot, stored, err := c.loadSnapshot()
+	if err != nil {
+		return err
+	}
+	nodes := c.deployGraph(snapshot.Candidates)
+	deployInOrder(nodes, c.Parallel, c.TriggerCandidateDeployment)
+	if err := summarize(nodes); err != nil {
+		return err
+	}
+	return c.advanceSnapshot(snapshot, stored, deployedState(stored))
+}
+
+// snapshotProgress orders the states of the snapshot lifecycle
+var snapshotProgress = map[string]int{
+	data.SnapshotComposed: 1, data.SnapshotTesting: 2, data.SnapshotAccepted: 3, data.SnapshotDeployed: 4,
+}
+
+// deployedState is the state of a snapshot once deployed: testing until it is accepted, deployed afterwards
+func deployedState(stored string) string {
+	if stored == data.SnapshotComposed || stored == data.SnapshotTesting {
+		return data.SnapshotTesting
+	}
+	return data.SnapshotDeployed
+}
+
+// deployGraph places the snapshot candidates in a graph following the dependencies of their rendered specs.
+// Candidates that cannot be loaded, rendered or verified stay in the graph so that their dependents are skipped.
+func (c *Controller) deployGraph(cands []composition.NonValidatedCandidates) []*deployNode {
+	var nodes []*deployNode
+	dependencies := make(map[*deployNode][]string)
+	for _, cc := range cands {
+		n := &deployNode{service: cc.Service, version: cc.Version}
+		nodes = append(nodes, n)
+		candidate, err := c.Repo.FindCandidate(cc.Service, cc.Version)
+		if err != nil {
+			n.outcome, n.err = outcomeFailed, err
+			continue
+		}
+		content, err := c.renderSpec(cc.Service, candidate)
+		if err != nil {
+			// the template usually still names the app its dependents refer to
+			n.outcome, n.err = outcomeFailed, err
+			content = []byte(candidate.Spec)
+		}
+		n.appID, dependencies[n] = specDependencies(content)
+		if n.outcome != "" {
+			continue
+		}
+		if err := cc.Verify(candidate); err != nil {
+			n.outcome, n.err = outcomeRefused, err
+		}
+	}
+	linkDependencies(nodes, dependencies)
+	return nodes
+}
+
+func (c *Controller) groupDeployer() (deployer.IGroupDeployer, error) {
+	if dep, ok := c.Deployer.(deployer.IGroupDeployer); ok {
+		return dep, nil
+	}
+	return nil, errors.New("the marathon deployer does not support group deployments")
+}
+
+// DeploySnapshotAsGroup deploys all candidates from the snapshot as a single marathon group update
+func (c *Controller) DeploySnapshotAsGroup(groupID string) error {
+	groupDeployer, err := c.groupDeployer()
 	if err != nil {
 		return err
 	}
-	content := string(b)
-	cands, err2 := readNonValidatedCandidates(content)
-	if err2 != nil {
-		return err2
+	snapshot, stored, err := c.loadSnapshot()
+	if err != nil {
+		return err
 	}
+	cands := snapshot.Candidates
 
+	var specs [][]byte
 	for _, cc := range cands {
-		if err := c.TriggerCandidateDeployment(cc.Service, cc.Version); err != nil {
+		dep, _, err := c.deployerFor(cc.Service)
+		if err != nil {
 			return err
 		}
+		if dep != c.Deployer {
+			return errors.New(cc.Service + " is not deployed with marathon and cannot be part of a group")
+		}
+		candidate, err := c.Repo.FindCandidate(cc.Service, cc.Version)
+		if err != nil {
+			return err
+		}
+		if err := cc.Verify(candidate); err != nil {
+			return err
+		}
+		content, err := c.renderForDeployment(cc.Service, candidate)
+		if err != nil {
+			return err
+		}
+		specs = append(specs, content)
+	}
+
+	started := time.Now()
+	deployment, err := groupDeployer.DeployGroup(groupID, specs)
+	outcome := data.DeploymentSucceeded
+	if err != nil {
+		outcome = data.DeploymentFailed
+	}
+	record := c.deploymentRecord(started, deployment, outcome, err)
+	for _, cc := range cands {
+		if recordErr := c.Repo.RecordDeployment(cc.Service, cc.Version, record); recordErr != nil {
+			return recordErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Deployed group " + deployment.AppId + " with deployment " + strings.Join(deployment.DeploymentIds, ", "))
+	for _, cc := range cands {
+		if err := c.markDeployed(cc.Service, cc.Version); err != nil {
+			return err
+		}
+	}
+	return c.advanceSnapshot(snapshot, stored, deployedState(stored))
+}
+
+// RollbackSnapshotGroup reverts the marathon group to the version preceding its last deployment
+func (c *Controller) RollbackSnapshotGroup(groupID string) error {
+	groupDeployer, err := c.groupDeployer()
+	if err != nil {
+		return err
+	}
+	deployment, err := groupDeployer.RollbackGroup(groupID)
+	if err != nil {
+		return err
 	}
+	fmt.Println("Rolling back group " + deployment.AppId + " with deployment " + strings.Join(deployment.DeploymentIds, ", "))
 	return nil
 }
 
-// AcceptCandidateSnapshot marks as succesful all the versions listed in the snapshot file
+// AcceptCandidateSnapshot marks as succesful all the versions listed in the snapshot
 func (c *Controller) AcceptCandidateSnapshot() error {
-	return c.ChangeCandidateState("Succeeded")
+	return c.changeCandidateState("Succeeded", data.SnapshotAccepted)
 }
 
-// CompleteCandidateSnapshot marks as completed all the versions listed in the snapshot file
+// CompleteCandidateSnapshot marks as completed all the versions listed in the snapshot
 func (c *Controller) CompleteCandidateSnapshot() error {
-	return c.ChangeCandidateState("Completed")
+	return c.changeCandidateState("Completed", data.SnapshotTesting)
 }
 
 // ChangeCandidateState updates the state candidates in a snapsot
 func (c *Controller) ChangeCandidateState(state string) error {
-	b, err := ioutil.ReadFile(snapshotFile)
+	return c.changeCandidateState(state, "")
+}
+
+// changeCandidateState moves the stored snapshot to the given snapshot state, when there is one, before
+// completing the stage of its candidates
+func (c *Controller) changeCandidateState(stage, snapshotState string) error {
+	snapshot, stored, err := c.loadSnapshot()
 	if err != nil {
 		return err
 	}
+	// a stage may be completed again later on, the snapshot is left in the later states it reached since
+	if snapshotState != "" && snapshotProgress[stored] < snapshotProgress[snapshotState] {
+		if err := c.advanceSnapshot(snapshot, stored, snapshotState); err != nil {
+			return err
+		}
+	}
+	return c.completeStageForSnapshot(snapshot, stage)
+}
 
-	return c.updateStateForNonValidatedCandidates(state, string(b))
+// RejectCandidateSnapshot marks the stored snapshot as rejected so that it can no longer be used
+func (c *Controller) RejectCandidateSnapshot() error {
+	snapshot, stored, err := c.loadSnapshot()
+	if err != nil {
+		return err
+	}
+	if stored == "" {
+		return errors.New("snapshot " + snapshot.ID + " is not stored in the repository")
+	}
+	return c.advanceSnapshot(snapshot, stored, data.SnapshotRejected)
 }
 
-// AssignMarathonSpecificationFor assigns the content of a marathonspec to a candidate
+func (c *Controller) templateContext(name string, candidate data.DeploymentCandidate) spec.Context {
+	return spec.Context{
+		Image:       candidate.Image,
+		Version:     candidate.Version,
+		Service:     name,
+		Environment: c.Environment,
+		Labels:      c.Labels,
+		Vars:        c.Vars,
+	}
+}
+
+// renderSpec renders the candidate's spec template and applies the overlay of the environment to marathon specs
+func (c *Controller) renderSpec(name string, candidate data.DeploymentCandidate) ([]byte, error) {
+	content, err := spec.Render(candidate.Spec, c.templateContext(name, candidate))
+	if err != nil {
+		return nil, err
+	}
+	if i := c.environment(c.Environment); i >= 0 && len(c.Environments[i].Overlay) > 0 {
+		dep, _, err := c.deployerFor(name)
+		if err != nil {
+			return nil, err
+		}
+		if dep == c.Deployer {
+			if content, err = spec.Overlay(content, c.Environments[i].Overlay); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return content, nil
+}
+
+// renderForDeployment renders the spec about to be deployed and records it against the candidate
+func (c *Controller) renderForDeployment(name string, candidate data.DeploymentCandidate) ([]byte, error) {
+	content, err := c.renderSpec(name, candidate)
+	if err != nil {
+		return nil, err
+	}
+	return content, c.Repo.RecordRenderedSpec(name, candidate.Version, string(content))
+}
+
+// AssignMarathonSpecificationFor checks the marathonspec template, lints a preview of it rendered for the candidate
+// and assigns it. The template is rendered in full at deploy time, with the values of the deployment.
 func (c *Controller) AssignMarathonSpecificationFor(name, version, marathonSpec string) error {
 	b, err := ioutil.ReadFile(marathonSpec)
 	if err != nil {
 		return err
 	}
+	candidate, err := c.Repo.FindCandidate(name, version)
+	if err != nil {
+		return err
+	}
+	if err := spec.Check(string(b)); err != nil {
+		return err
+	}
+	dep, _, err := c.deployerFor(name)
+	if err != nil {
+		return err
+	}
+	if dep == c.Deployer {
+		// the vars and labels of the deployment are not known yet, the lint runs against a preview
+		preview, err := spec.Preview(string(b), c.templateContext(name, candidate))
+		if err != nil {
+			return err
+		}
+		if report := spec.Lint(name, preview, c.LintRules); report.Failed() {
+			return report
+		}
+	}
 	return c.Repo.AssignMarathonSpecToCandidate(name, version, string(b))
 }
 
-func writeSnapshotFile(c composition.IComposer, candidates []data.DeploymentCandidate) error {
-	content, err := c.PrepareFinalizableCandidatesSnapshotContent(candidates)
+// storeSnapshot composes the snapshot of the candidates and stores it in the repository, exporting it to the
+// snapshot file unless SkipSnapshotFile is set
+func (c *Controller) storeSnapshot(candidates []data.DeploymentCandidate) error {
+	content, err := c.Composer.PrepareFinalizableCandidatesSnapshotContent(candidates)
+	if err != nil {
+		return err
+	}
+	snapshot, err := composition.ReadSnapshot(content)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(snapshotFile, content, 0644)
+	if snapshot.ID != "" {
+		stored := data.StoredSnapshot{ID: snapshot.ID, Created: snapshot.Created.Unix(), Content: string(content)}
+		if err := c.Repo.SaveSnapshot(stored); err != nil {
+			return err
+		}
+		fmt.Println("Composed snapshot " + snapshot.ID)
+	}
+	if c.SkipSnapshotFile {
+		return nil
+	}
+	return c.Workspace.WriteFile(c.Workspace.SnapshotPath(), content)
 }
 
-func writeDockerComposeFile(c composition.IComposer, candidates []data.DeploymentCandidate) error {
-	content, err := c.PrepareComposerContent(candidates)
+func (c *Controller) writeDockerComposeFile(candidates []data.DeploymentCandidate) error {
+	content, err := c.Composer.PrepareComposerContent(candidates)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(composerFile, content, 0644)
+	return c.Workspace.WriteFile(c.Workspace.ComposePath(), content)
 }
 
-// ProduceCompositionAndSnapshotFiles produces a docker compose file and candidate snapshot file
+// ProduceCompositionAndSnapshotFiles produces a docker compose file and candidate snapshot, the latter being
+// stored in the repository and exported to the snapshot file
 func (c *Controller) ProduceCompositionAndSnapshotFiles() error {
 	candidates, err := c.Repo.GetCandidatesForE2E()
 	if err != nil {
 		return err
 	}
-	if err := writeDockerComposeFile(c.Composer, candidates); err != nil {
+	if candidates, err = c.selectCandidates(candidates); err != nil {
+		return err
+	}
+	return c.writeComposition(candidates)
+}
+
+// ProduceBaselineComposition produces a docker compose file and candidate snapshot holding the given candidate
+// and the version deployed to the environment of every other tracked service, so that a failure of the
+// E2E tests can be attributed to the candidate. Services excluded by the Selection rules are left out.
+func (c *Controller) ProduceBaselineComposition(name, version string) error {
+	rules := SelectionRules{Pins: map[string]string{name: version}, Exclude: c.Selection.Exclude, Fallback: true}
+	candidates, err := c.applyRules(rules, nil)
+	if err != nil {
 		return err
 	}
-	return writeSnapshotFile(c.Composer, candidates)
+	return c.writeComposition(candidates)
+}
+
+func (c *Controller) writeComposition(candidates []data.DeploymentCandidate) error {
+	if err := c.writeDockerComposeFile(candidates); err != nil {
+		return err
+	}
+	return c.storeSnapshot(candidates)
+}
+
+// markDeployed completes the Deployed stage and records the deployment against the current environment
+func (c *Controller) markDeployed(name, version string) error {
+	if err := c.CompleteStageFor(name, version, "Deployed"); err != nil {
+		return err
+	}
+	if c.Environment == "" {
+		return nil
+	}
+	return c.Repo.MarkDeployedIn(name, version, c.Environment)
+}
+
+// PromoteCandidate deploys the version of the service running in one environment to the next one
+func (c *Controller) PromoteCandidate(name, from string) error {
+	i := c.environment(from)
+	if i < 0 {
+		return errors.New(from + " is not a configured environment")
+	}
+	if i == len(c.Environments)-1 {
+		return errors.New(from + " is the last environment")
+	}
+	candidate, err := c.Repo.FindDeployedCandidate(name, from)
+	if err != nil {
+		return err
+	}
+
+	target := c.Environments[i+1]
+	promoted := *c
+	promoted.Environment = target.Name
+	promoted.Deployer = target.Deployer
+	fmt.Println("Promoting " + name + " " + candidate.Version + " from " + from + " to " + target.Name)
+	return promoted.TriggerCandidateDeployment(name, candidate.Version)
 }
 
 // CompleteStageFor marks a given stage as completed for the chosen candidate
@@ -128,18 +488,340 @@ func (c *Controller) CompleteStageFor(name, version, stage string) error {
 	return c.Repo.CompleteStage(name, version, stage)
 }
 
-// TriggerCandidateDeployment attempts to deploy a candidate to marathon
+// deployerFor selects the deployer configured for the tracked service, defaulting to marathon, as for the
+// services that are not tracked
+func (c *Controller) deployerFor(name string) (deployer.IDeployer, data.TrackedService, error) {
+	service, err := c.Repo.FindTrackedService(name)
+	if _, notTracked := err.(data.ServiceNotTrackedError); notTracked {
+		service, err = data.TrackedService{Name: name}, nil
+	}
+	if err != nil {
+		return nil, service, err
+	}
+	if service.Deployer == "" || service.Deployer == deployer.MarathonBackend {
+		return c.Deployer, service, nil
+	}
+	if dep, ok := c.Deployers[service.Deployer]; ok {
+		return dep, service, nil
+	}
+	return nil, service, errors.New("no " + service.Deployer + " deployer configured for " + name)
+}
+
+func rolloutOf(dep deployer.IDeployer, service data.TrackedService) (deployer.IRolloutDeployer, error) {
+	if service.Strategy == "" || service.Strategy == deployer.InPlaceStrategy {
+		return nil, errors.New(service.Name + " is deployed in place")
+	}
+	rollout, ok := dep.(deployer.IRolloutDeployer)
+	if !ok {
+		return nil, errors.New("the deployer of " + service.Name + " does not support " + service.Strategy + " rollouts")
+	}
+	return rollout, nil
+}
+
+// rolloutFor returns the deployer of a service using a blue/green or canary strategy
+func (c *Controller) rolloutFor(name string) (deployer.IRolloutDeployer, data.TrackedService, error) {
+	dep, service, err := c.deployerFor(name)
+	if err != nil {
+		return nil, service, err
+	}
+	rollout, err := rolloutOf(dep, service)
+	return rollout, service, err
+}
+
+func (c *Controller) deployWithStrategy(name string, content []byte) (*deployer.ExpectedDeployment, bool, error) {
+	dep, service, err := c.deployerFor(name)
+	if err != nil {
+		return nil, false, err
+	}
+	if service.Strategy == "" || service.Strategy == deployer.InPlaceStrategy {
+		deployment, err := dep.Deploy(content)
+		return deployment, true, err
+	}
+	rollout, err := rolloutOf(dep, service)
+	if err != nil {
+		return nil, false, err
+	}
+	switch service.Strategy {
+	case deployer.BlueGreenStrategy:
+		deployment, err := rollout.DeployBlueGreen(content)
+		return deployment, true, err
+	case deployer.CanaryStrategy:
+		deployment, err := rollout.DeployCanary(content, service.CanaryInstances)
+		return deployment, false, err
+	}
+	return nil, false, errors.New(service.Strategy + " is not a valid strategy")
+}
+
+// TriggerCandidateDeployment attempts to deploy a candidate using the deployer and strategy of its service,
+// recording the rendered spec sent to the deployer. Canary deployments are only marked as deployed once
+// promoted, others once healthy and smoke checked.
 func (c *Controller) TriggerCandidateDeployment(name, version string) error {
 	candidate, err := c.Repo.FindCandidate(name, version)
 	if err != nil {
 		return err
 	}
-	if deployment, err := c.Deployer.Deploy([]byte(candidate.MarathonSpec)); err != nil {
+	content, err := c.renderForDeployment(name, candidate)
+	if err != nil {
+		return err
+	}
+	started := time.Now()
+	deployment, complete, err := c.deployWithStrategy(name, content)
+	if err != nil {
+		return c.recordDeployment(name, version, started, deployment, data.DeploymentFailed, err)
+	}
+	if !complete {
+		fmt.Println("Started " + deployment.AppId + " with version " + version + ", awaiting promotion")
+		return c.recordDeployment(name, version, started, deployment, data.DeploymentPending, nil)
+	}
+	fmt.Println("Deployed " + deployment.AppId + " with version " + version)
+	if err := c.verifyDeployment(name, candidate, content); err != nil {
+		return c.recordDeployment(name, version, started, deployment, data.DeploymentFailed, err)
+	}
+	if err := c.recordDeployment(name, version, started, deployment, data.DeploymentSucceeded, nil); err != nil {
+		return err
+	}
+	return c.markDeployed(name, version)
+}
+
+func (c *Controller) deploymentRecord(started time.Time, deployment *deployer.ExpectedDeployment, outcome string, failure error) data.DeploymentRecord {
+	record := data.DeploymentRecord{
+		Environment: c.Environment,
+		Started:     started.Unix(),
+		Finished:    time.Now().Unix(),
+		Outcome:     outcome,
+	}
+	if deployment != nil {
+		record.AppID = deployment.AppId
+		record.AppVersion = deployment.AppVersion
+		record.DeploymentIds = deployment.DeploymentIds
+		record.NewDeployment = deployment.NewDeployment
+	}
+	if failure != nil {
+		record.Error = failure.Error()
+	}
+	return record
+}
+
+// recordDeployment stores the outcome of a deployment against the candidate, returning the failure
+// that ended it if any
+func (c *Controller) recordDeployment(name, version string, started time.Time, deployment *deployer.ExpectedDeployment, outcome string, failure error) error {
+	if err := c.Repo.RecordDeployment(name, version, c.deploymentRecord(started, deployment, outcome, failure)); err != nil {
+		return err
+	}
+	return failure
+}
+
+// checkDeployment waits for the tasks of the app to be healthy then runs the smoke checks of the service
+func (c *Controller) checkDeployment(dep deployer.IDeployer, service data.TrackedService, name string, candidate data.DeploymentCandidate, content []byte) error {
+	if checker, ok := dep.(deployer.IHealthChecker); ok {
+		if err := checker.WaitForHealthyTasks(content, service.HealthyTasks); err != nil {
+			return err
+		}
+	}
+	return runSmokeChecks(c.HTTPClient, service.SmokeChecks, c.templateContext(name, candidate))
+}
+
+// verifyDeployment gates a completed deployment on health and smoke checks, rolling it back on failure
+// when the service asks for it
+func (c *Controller) verifyDeployment(name string, candidate data.DeploymentCandidate, content []byte) error {
+	dep, service, err := c.deployerFor(name)
+	if err != nil {
 		return err
+	}
+	failure := c.checkDeployment(dep, service, name, candidate, content)
+	if failure == nil || !service.RollbackOnFailure {
+		return failure
+	}
+
+	var rolledBack *deployer.ExpectedDeployment
+	if service.Strategy == deployer.BlueGreenStrategy {
+		var rollout deployer.IRolloutDeployer
+		if rollout, err = rolloutOf(dep, service); err == nil {
+			rolledBack, err = rollout.Abort(service.Strategy, content)
+		}
+	} else if rollbacker, ok := dep.(deployer.IRollbacker); ok {
+		rolledBack, err = rollbacker.Rollback(content)
 	} else {
-		fmt.Println("Deployed " + deployment.AppId + " with version " + version)
-		return c.CompleteStageFor(name, version, "Deployed")
+		err = errors.New("the deployer of " + name + " does not support rollbacks")
+	}
+	if err != nil {
+		return fmt.Errorf("%v, and rolling back failed: %v", failure, err)
+	}
+	event := data.CandidateEvent{Environment: c.Environment, Action: "rollback", Detail: failure.Error()}
+	if err := c.Repo.LogCandidateEvent(name, candidate.Version, event); err != nil {
+		return err
+	}
+	return fmt.Errorf("%v, %s was rolled back", failure, rolledBack.AppId)
+}
+
+// PromoteRollout completes the blue/green or canary rollout of a candidate
+func (c *Controller) PromoteRollout(name, version string) error {
+	rollout, service, err := c.rolloutFor(name)
+	if err != nil {
+		return err
+	}
+	candidate, err := c.Repo.FindCandidate(name, version)
+	if err != nil {
+		return err
+	}
+	content, err := c.renderForDeployment(name, candidate)
+	if err != nil {
+		return err
+	}
+	started := time.Now()
+	deployment, err := rollout.Promote(service.Strategy, content)
+	if err != nil {
+		return c.recordDeployment(name, version, started, deployment, data.DeploymentFailed, err)
+	}
+	fmt.Println("Promoted " + deployment.AppId + " with version " + version)
+	if service.Strategy == deployer.CanaryStrategy {
+		if err := c.verifyDeployment(name, candidate, content); err != nil {
+			return c.recordDeployment(name, version, started, deployment, data.DeploymentFailed, err)
+		}
+	}
+	if err := c.recordDeployment(name, version, started, deployment, data.DeploymentSucceeded, nil); err != nil {
+		return err
 	}
+	return c.markDeployed(name, version)
+}
+
+// AbortRollout reverts the blue/green or canary rollout of a candidate
+func (c *Controller) AbortRollout(name, version string) error {
+	rollout, service, err := c.rolloutFor(name)
+	if err != nil {
+		return err
+	}
+	candidate, err := c.Repo.FindCandidate(name, version)
+	if err != nil {
+		return err
+	}
+	content, err := c.renderSpec(name, candidate)
+	if err != nil {
+		return err
+	}
+	deployment, err := rollout.Abort(service.Strategy, content)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Aborted rollout of version " + version + ", " + deployment.AppId + " restored")
+	return nil
+}
+
+// deployedCandidate finds the requested version of the service, or the one deployed to the current environment
+func (c *Controller) deployedCandidate(name, version string) (data.DeploymentCandidate, error) {
+	if version == "" || version == "-1" {
+		return c.Repo.FindDeployedCandidate(name, c.Environment)
+	}
+	return c.Repo.FindCandidate(name, version)
+}
+
+// operate applies a day-two operation to the app of a service and records the resulting deployments
+func (c *Controller) operate(name, version, operation string, op func(deployer.IDeployer, []byte) (*deployer.ExpectedDeployment, error)) error {
+	candidate, err := c.deployedCandidate(name, version)
+	if err != nil {
+		return err
+	}
+	dep, _, err := c.deployerFor(name)
+	if err != nil {
+		return err
+	}
+	content, err := c.renderSpec(name, candidate)
+	if err != nil {
+		return err
+	}
+	deployment, err := op(dep, content)
+	if err != nil {
+		return err
+	}
+	fmt.Println(operation + " " + deployment.AppId + " with deployment " + strings.Join(deployment.DeploymentIds, ", "))
+	return c.Repo.RecordDeploymentIds(name, candidate.Version, c.Environment, deployment.DeploymentIds)
+}
+
+// ScaleService sets the number of instances of the service's app
+func (c *Controller) ScaleService(name, version string, instances int) error {
+	return c.operate(name, version, "Scaled", func(dep deployer.IDeployer, content []byte) (*deployer.ExpectedDeployment, error) {
+		return dep.Scale(content, instances)
+	})
+}
+
+// RestartService restarts all the instances of the service's app
+func (c *Controller) RestartService(name, version string) error {
+	return c.operate(name, version, "Restarted", deployer.IDeployer.Restart)
+}
+
+// SuspendService scales the service's app down to 0 instances
+func (c *Controller) SuspendService(name, version string) error {
+	return c.operate(name, version, "Suspended", deployer.IDeployer.Suspend)
+}
+
+// CancelDeployments cancels the in-flight marathon deployments of the service's app, rolling them back
+// unless told otherwise, and logs the action against the candidate
+func (c *Controller) CancelDeployments(name, version string, rollback bool) error {
+	candidate, err := c.deployedCandidate(name, version)
+	if err != nil {
+		return err
+	}
+	dep, _, err := c.deployerFor(name)
+	if err != nil {
+		return err
+	}
+	canceller, ok := dep.(deployer.IDeploymentCanceller)
+	if !ok {
+		return errors.New("the deployer of " + name + " does not support cancelling deployments")
+	}
+	content, err := c.renderSpec(name, candidate)
+	if err != nil {
+		return err
+	}
+	cancelled, err := canceller.CancelDeployments(content, rollback)
+	if err != nil {
+		return err
+	}
+	if len(cancelled.Cancelled) == 0 {
+		fmt.Println("No deployment in progress for " + cancelled.AppId)
+		return nil
+	}
+
+	event := data.CandidateEvent{Environment: c.Environment, Action: "delete_deployment", Detail: strings.Join(cancelled.Cancelled, ", ")}
+	if rollback {
+		event.Action = "rollback_deployment"
+		event.Detail += " rolled back by " + strings.Join(cancelled.Rollbacks, ", ")
+	}
+	fmt.Println("Cancelled deployments of " + cancelled.AppId + ": " + event.Detail)
+	return c.Repo.LogCandidateEvent(name, candidate.Version, event)
+}
+
+// ShowDeployments prints the deployment records of a candidate
+func (c *Controller) ShowDeployments(name, version string) error {
+	candidate, err := c.Repo.FindCandidate(name, version)
+	if err != nil {
+		return err
+	}
+	for _, record := range candidate.Deployments {
+		fmt.Printf("%s %s %s app version %s deployments [%s] in %q from %s to %s %s\n",
+			record.Outcome, record.AppID, updateKind(record.NewDeployment), record.AppVersion,
+			strings.Join(record.DeploymentIds, ", "), record.Environment,
+			time.Unix(record.Started, 0).UTC().Format(time.RFC3339),
+			time.Unix(record.Finished, 0).UTC().Format(time.RFC3339), record.Error)
+	}
+	return nil
+}
+
+func updateKind(newDeployment bool) string {
+	if newDeployment {
+		return "created"
+	}
+	return "updated"
+}
+
+// FindBuildOf prints the candidate version whose deployment produced the marathon app version
+func (c *Controller) FindBuildOf(name, appVersion string) error {
+	candidate, err := c.Repo.FindCandidateByAppVersion(name, appVersion)
+	if err != nil {
+		return err
+	}
+	fmt.Println(name + " app version " + appVersion + " was deployed from " + candidate.Version + " (" + candidate.Image + ")")
+	return nil
 }
 
 // StartPipeline initiates candidate registration
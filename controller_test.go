@@ -1,11 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/bhameyie/dpipeliner/composition"
 	"github.com/bhameyie/dpipeliner/data"
+	"github.com/bhameyie/dpipeliner/deployer"
+	"github.com/bhameyie/dpipeliner/deployer/marathontest"
 
 	. "gopkg.in/check.v1"
 )
@@ -29,6 +40,15 @@ const (
           "version": "12"
       }
   ]
+  `
+	storedSnapJsContent = `{
+      "FormatVersion": 2,
+      "ID": "5eed",
+      "Candidates": [
+          {"service": "boom", "version": "1"},
+          {"service": "doom", "version": "12"}
+      ]
+  }
   `
 )
 
@@ -82,8 +102,568 @@ func (s *ControllerSuite) TestCanProduceCompositionAndSnapshotFiles(c *C) {
 
 	js, errJs := ioutil.ReadFile(snapper)
 	c.Assert(errJs, IsNil)
-	c.Assert(string(js), Equals, "hooo")
+	c.Assert(string(js), Equals, storedSnapJsContent)
+}
+
+func (s *ControllerSuite) TestStoresComposedSnapshotsWithoutExportingThem(c *C) {
+	rep := &AllGoodRepo{}
+	sut := &Controller{Composer: &AllGoodComposer{}, Repo: rep, SkipSnapshotFile: true}
+
+	c.Assert(sut.ProduceCompositionAndSnapshotFiles(), IsNil)
+	c.Assert(rep.Snapshots["5eed"].State, Equals, data.SnapshotComposed)
+	c.Assert(rep.Snapshots["5eed"].Content, Equals, storedSnapJsContent)
+	_, err := os.Stat(snapper)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *ControllerSuite) TestStoredSnapshotFollowsItsLifecycle(c *C) {
+	rep := &AllGoodRepo{}
+	c.Assert(rep.SaveSnapshot(data.StoredSnapshot{ID: "5eed", Content: storedSnapJsContent}), IsNil)
+	marathon := &DeployerSpy{}
+	sut := &Controller{Repo: rep, Deployer: marathon, SnapshotID: "5e"}
+
+	c.Assert(sut.DeploySnapshot(), IsNil)
+	c.Assert(len(marathon.Specs), Equals, 2)
+	c.Assert(rep.Snapshots["5eed"].State, Equals, data.SnapshotTesting)
+
+	c.Assert(sut.AcceptCandidateSnapshot(), IsNil)
+	c.Assert(rep.Snapshots["5eed"].State, Equals, data.SnapshotAccepted)
+	c.Assert(rep.Spies[len(rep.Spies)-1].StageName, Equals, "Succeeded")
+
+	c.Assert(sut.DeploySnapshot(), IsNil)
+	c.Assert(rep.Snapshots["5eed"].State, Equals, data.SnapshotDeployed)
+
+	c.Assert(sut.CompleteCandidateSnapshot(), IsNil)
+	c.Assert(sut.AcceptCandidateSnapshot(), IsNil)
+	c.Assert(rep.Snapshots["5eed"].State, Equals, data.SnapshotDeployed)
+	c.Assert(rep.Spies[len(rep.Spies)-1].StageName, Equals, "Succeeded")
+}
+
+func (s *ControllerSuite) TestCompletingMovesComposedSnapshotToTesting(c *C) {
+	rep := &AllGoodRepo{}
+	c.Assert(rep.SaveSnapshot(data.StoredSnapshot{ID: "5eed", Content: storedSnapJsContent}), IsNil)
+	sut := &Controller{Repo: rep, SnapshotID: "5e"}
+
+	c.Assert(sut.CompleteCandidateSnapshot(), IsNil)
+	c.Assert(rep.Snapshots["5eed"].State, Equals, data.SnapshotTesting)
+	c.Assert(rep.Spies[len(rep.Spies)-1].StageName, Equals, "Completed")
+}
+
+func (s *ControllerSuite) TestRefusesRejectedSnapshots(c *C) {
+	c.Assert(ioutil.WriteFile(snapper, []byte(storedSnapJsContent), 0644), IsNil)
+	rep := &AllGoodRepo{}
+	c.Assert(rep.SaveSnapshot(data.StoredSnapshot{ID: "5eed", Content: storedSnapJsContent}), IsNil)
+	marathon := &DeployerSpy{}
+	sut := &Controller{Repo: rep, Deployer: marathon}
+
+	c.Assert(sut.RejectCandidateSnapshot(), IsNil)
+	c.Assert(rep.Snapshots["5eed"].State, Equals, data.SnapshotRejected)
+	c.Assert(sut.DeploySnapshot(), ErrorMatches, "snapshot 5eed was rejected")
+	c.Assert(sut.AcceptCandidateSnapshot(), ErrorMatches, "snapshot 5eed was rejected")
+	c.Assert(len(marathon.Specs), Equals, 0)
+	c.Assert(len(rep.Spies), Equals, 0)
+}
+
+func (s *ControllerSuite) TestDoesNotDeployWhenTheSnapshotStateIsUnknown(c *C) {
+	c.Assert(ioutil.WriteFile(snapper, []byte(storedSnapJsContent), 0644), IsNil)
+	rep := &AllGoodRepo{SnapshotFailure: errors.New("no reachable servers")}
+	marathon := &DeployerSpy{}
+	sut := &Controller{Repo: rep, Deployer: marathon}
+
+	c.Assert(sut.DeploySnapshot(), ErrorMatches, "no reachable servers")
+	c.Assert(len(marathon.Specs), Equals, 0)
+
+	rep.SnapshotFailure = nil
+	c.Assert(sut.DeploySnapshot(), IsNil)
+	c.Assert(len(marathon.Specs), Equals, 2)
+}
+
+func (s *ControllerSuite) TestCanTriggerDeploymentWithTheServiceDeployer(c *C) {
+	kube := &DeployerSpy{}
+	rep := &AllGoodRepo{Service: data.TrackedService{Deployer: "kubernetes"}}
+	sut := &Controller{Repo: rep, Deployers: map[string]deployer.IDeployer{"kubernetes": kube}}
+
+	err := sut.TriggerCandidateDeployment("a", "po")
+	c.Assert(err, IsNil)
+	c.Assert(len(kube.Specs), Equals, 1)
+	c.Assert(rep.Spies[0].StageName, Equals, "Deployed")
+}
+
+func (s *ControllerSuite) TestCanTriggerDeploymentWithDefaultDeployer(c *C) {
+	marathon := &DeployerSpy{}
+	sut := &Controller{Repo: &AllGoodRepo{}, Deployer: marathon}
+
+	err := sut.TriggerCandidateDeployment("a", "po")
+	c.Assert(err, IsNil)
+	c.Assert(len(marathon.Specs), Equals, 1)
+}
+
+func (s *ControllerSuite) TestDeploysUntrackedServiceWithDefaultDeployer(c *C) {
+	marathon := &DeployerSpy{}
+	rep := &AllGoodRepo{Untracked: true}
+	sut := &Controller{Repo: rep, Deployer: marathon, Deployers: map[string]deployer.IDeployer{"docker": &DeployerSpy{}}}
+
+	c.Assert(sut.TriggerCandidateDeployment("a", "1"), IsNil)
+	c.Assert(len(marathon.Specs), Equals, 1)
+}
+
+func (s *ControllerSuite) TestCannotTriggerDeploymentWithUnconfiguredDeployer(c *C) {
+	rep := &AllGoodRepo{Service: data.TrackedService{Deployer: "kubernetes"}}
+	sut := &Controller{Repo: rep, Deployer: &DeployerSpy{}}
+
+	err := sut.TriggerCandidateDeployment("a", "po")
+	c.Assert(err, NotNil)
+	c.Assert(len(rep.Spies), Equals, 0)
+}
+
+func (s *ControllerSuite) TestDeploysSnapshotInDependencyOrder(c *C) {
+	c.Assert(ioutil.WriteFile(snapper, []byte(snapJsContent), 0644), IsNil)
+	rep := &AllGoodRepo{Candidates: map[string]data.DeploymentCandidate{
+		"boom": {ServiceName: "boom", Version: "1", Spec: `{"id": "/shop/boom", "dependencies": ["doom"]}`},
+		"doom": {ServiceName: "doom", Version: "12", Spec: `{"id": "/shop/doom", "dependencies": ["/elsewhere/db"]}`},
+	}}
+	marathon := &DeployerSpy{}
+	sut := &Controller{Repo: rep, Deployer: marathon, Parallel: 2}
+
+	err := sut.DeploySnapshot()
+	c.Assert(err, IsNil)
+	c.Assert(len(marathon.Specs), Equals, 2)
+	c.Assert(marathon.Specs[0], Matches, ".*/shop/doom.*")
+	c.Assert(marathon.Specs[1], Matches, ".*/shop/boom.*")
+}
+
+func (s *ControllerSuite) TestSkipsDependentsOfSnapshotCandidatesThatFailToRender(c *C) {
+	c.Assert(ioutil.WriteFile(snapper, []byte(snapJsContent), 0644), IsNil)
+	rep := &AllGoodRepo{Candidates: map[string]data.DeploymentCandidate{
+		"boom": {ServiceName: "boom", Version: "1", Spec: `{"id": "/shop/boom", "dependencies": ["doom"]}`},
+		"doom": {ServiceName: "doom", Version: "12", Spec: `{"id": "/shop/doom", "team": "{{.Vars.team}}"}`},
+	}}
+	marathon := &DeployerSpy{}
+	sut := &Controller{Repo: rep, Deployer: marathon}
+
+	err := sut.DeploySnapshot()
+	c.Assert(err, NotNil)
+	c.Assert(len(marathon.Specs), Equals, 0)
+	c.Assert(len(rep.Spies), Equals, 0)
+}
+
+func (s *ControllerSuite) TestDoesNotDeploySnapshotCycles(c *C) {
+	c.Assert(ioutil.WriteFile(snapper, []byte(snapJsContent), 0644), IsNil)
+	rep := &AllGoodRepo{Candidates: map[string]data.DeploymentCandidate{
+		"boom": {ServiceName: "boom", Version: "1", Spec: `{"id": "/boom", "dependencies": ["/doom"]}`},
+		"doom": {ServiceName: "doom", Version: "12", Spec: `{"id": "/doom", "dependencies": ["/boom"]}`},
+	}}
+	marathon := &DeployerSpy{}
+	sut := &Controller{Repo: rep, Deployer: marathon}
+
+	err := sut.DeploySnapshot()
+	c.Assert(err, NotNil)
+	c.Assert(len(marathon.Specs), Equals, 0)
+}
+
+func (s *ControllerSuite) TestRefusesSnapshotCandidatesChangedSinceComposed(c *C) {
+	boom := data.DeploymentCandidate{ServiceName: "boom", Version: "1", Image: "boom:1", Spec: `{"id": "/boom", "dependencies": ["/doom"]}`}
+	doom := data.DeploymentCandidate{ServiceName: "doom", Version: "12", Image: "doom:12", Spec: `{"id": "/doom"}`}
+	content, err := composition.NewComposer().PrepareFinalizableCandidatesSnapshotContent([]data.DeploymentCandidate{boom, doom})
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(snapper, content, 0644), IsNil)
+
+	doom.Image = "doom:latest"
+	rep := &AllGoodRepo{Candidates: map[string]data.DeploymentCandidate{"boom": boom, "doom": doom}}
+	marathon := &GroupDeployerSpy{}
+	sut := &Controller{Repo: rep, Deployer: marathon}
+
+	err = sut.DeploySnapshot()
+	c.Assert(err, NotNil)
+	c.Assert(len(marathon.Specs), Equals, 0)
+
+	err = sut.DeploySnapshotAsGroup("/prod")
+	c.Assert(err, ErrorMatches, "doom 12 image changed since the snapshot, doom:latest instead of doom:12")
+	c.Assert(len(marathon.Groups), Equals, 0)
+}
+
+func (s *ControllerSuite) TestRefusesTamperedSnapshots(c *C) {
+	secret := filepath.Join(c.MkDir(), "snapshot.secret")
+	c.Assert(ioutil.WriteFile(secret, []byte("a shared secret of the CI jobs"), 0600), IsNil)
+	signer, err := composition.LoadSnapshotSigner(secret)
+	c.Assert(err, IsNil)
+	verifier, err := composition.LoadSnapshotVerifier(secret)
+	c.Assert(err, IsNil)
+
+	boom := data.DeploymentCandidate{ServiceName: "boom", Version: "1", Image: "boom:1"}
+	composer := &composition.SystemComposer{Signer: signer}
+	content, err := composer.PrepareFinalizableCandidatesSnapshotContent([]data.DeploymentCandidate{boom})
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(snapper, bytes.Replace(content, []byte(`"1"`), []byte(`"2"`), 1), 0644), IsNil)
+
+	rep := &AllGoodRepo{Candidate: boom}
+	marathon := &DeployerSpy{}
+	sut := &Controller{Repo: rep, Deployer: marathon, SnapshotVerifier: verifier}
 
+	c.Assert(sut.DeploySnapshot(), ErrorMatches, "candidateSnapper.json failed verification: snapshot signature does not match its content.*")
+	c.Assert(sut.AcceptCandidateSnapshot(), ErrorMatches, "candidateSnapper.json failed verification.*")
+	c.Assert(len(marathon.Specs), Equals, 0)
+	c.Assert(len(rep.Spies), Equals, 0)
+
+	c.Assert(ioutil.WriteFile(snapper, []byte(snapJsContent), 0644), IsNil)
+	c.Assert(sut.CompleteCandidateSnapshot(), ErrorMatches, "candidateSnapper.json failed verification: snapshot is not signed")
+}
+
+func (s *ControllerSuite) TestCanDeploySnapshotAsGroup(c *C) {
+	c.Assert(ioutil.WriteFile(snapper, []byte(snapJsContent), 0644), IsNil)
+	rep := &AllGoodRepo{Candidate: data.DeploymentCandidate{Spec: `{"id": "/prod/{{.Service}}"}`}}
+	marathon := &GroupDeployerSpy{}
+	sut := &Controller{Repo: rep, Deployer: marathon}
+
+	err := sut.DeploySnapshotAsGroup("/prod")
+	c.Assert(err, IsNil)
+	c.Assert(rep.RenderedSpec, Equals, `{"id": "/prod/doom"}`)
+	c.Assert(marathon.GroupID, Equals, "/prod")
+	c.Assert(len(marathon.Groups), Equals, 2)
+	c.Assert(len(marathon.Specs), Equals, 0)
+	c.Assert(len(rep.Spies), Equals, 2)
+	c.Assert(rep.Spies[1].ServiceName, Equals, "doom")
+	c.Assert(rep.Spies[1].StageName, Equals, "Deployed")
+}
+
+func (s *ControllerSuite) TestCannotDeploySnapshotAsGroupWithoutGroupSupport(c *C) {
+	c.Assert(ioutil.WriteFile(snapper, []byte(snapJsContent), 0644), IsNil)
+	rep := &AllGoodRepo{}
+	sut := &Controller{Repo: rep, Deployer: &DeployerSpy{}}
+
+	err := sut.DeploySnapshotAsGroup("/prod")
+	c.Assert(err, NotNil)
+	c.Assert(len(rep.Spies), Equals, 0)
+}
+
+func (s *ControllerSuite) TestCanRollbackSnapshotGroup(c *C) {
+	marathon := &GroupDeployerSpy{}
+	sut := &Controller{Repo: &AllGoodRepo{}, Deployer: marathon}
+
+	err := sut.RollbackSnapshotGroup("/prod")
+	c.Assert(err, IsNil)
+	c.Assert(marathon.RolledBack, Equals, "/prod")
+}
+
+func (s *ControllerSuite) TestRendersSpecTemplateOnDeployment(c *C) {
+	marathon := &DeployerSpy{}
+	rep := &AllGoodRepo{Candidate: data.DeploymentCandidate{
+		Image:   "group/image:2",
+		Version: "2",
+		Spec:    `{"id": "{{.Service}}", "image": "{{.Image}}", "env": "{{.Environment}}", "team": "{{.Vars.team}}"}`,
+	}}
+	sut := &Controller{Repo: rep, Deployer: marathon, Environment: "staging", Vars: map[string]string{"team": "fire"}}
+
+	err := sut.TriggerCandidateDeployment("a", "2")
+	c.Assert(err, IsNil)
+	rendered := `{"id": "a", "image": "group/image:2", "env": "staging", "team": "fire"}`
+	c.Assert(marathon.Specs[0], Equals, rendered)
+	c.Assert(rep.RenderedSpec, Equals, rendered)
+}
+
+func (s *ControllerSuite) TestCannotDeployWhenSpecTemplateFailsToRender(c *C) {
+	marathon := &DeployerSpy{}
+	rep := &AllGoodRepo{Candidate: data.DeploymentCandidate{Spec: `{"team": "{{.Vars.team}}"}`}}
+	sut := &Controller{Repo: rep, Deployer: marathon}
+
+	err := sut.TriggerCandidateDeployment("a", "2")
+	c.Assert(err, NotNil)
+	c.Assert(len(marathon.Specs), Equals, 0)
+}
+
+func (s *ControllerSuite) TestValidatesSpecTemplateWhenAttaching(c *C) {
+	spec := "marathon.test.json"
+	defer os.Remove(spec)
+	rep := &AllGoodRepo{Candidate: data.DeploymentCandidate{Image: "group/image"}}
+	sut := &Controller{Repo: rep}
+
+	c.Assert(ioutil.WriteFile(spec, []byte(`{"id": "{{.Service"}`), 0644), IsNil)
+	c.Assert(sut.AssignMarathonSpecificationFor("a", "1", spec), NotNil)
+	c.Assert(rep.AssignedSpec, Equals, "")
+
+	c.Assert(ioutil.WriteFile(spec, []byte(`{"id": "{{.Owner}}"}`), 0644), IsNil)
+	c.Assert(sut.AssignMarathonSpecificationFor("a", "1", spec), ErrorMatches, "template references unknown field .Owner")
+	c.Assert(rep.AssignedSpec, Equals, "")
+
+	valid := `{"id": "{{.Service}}", "team": "{{.Vars.team}}", "container": {"docker": {"image": "{{.Image}}"}}}`
+	c.Assert(ioutil.WriteFile(spec, []byte(valid), 0644), IsNil)
+	c.Assert(sut.AssignMarathonSpecificationFor("a", "1", spec), IsNil)
+	c.Assert(rep.AssignedSpec, Equals, valid)
+}
+
+func (s *ControllerSuite) TestLintsMarathonSpecWhenAttaching(c *C) {
+	spec := "marathon.test.json"
+	defer os.Remove(spec)
+	rep := &AllGoodRepo{}
+	sut := &Controller{Repo: rep, LintRules: []string{"health"}}
+
+	c.Assert(ioutil.WriteFile(spec, []byte(`{"id": "b", "container": {"docker": {"image": "i"}}}`), 0644), IsNil)
+	err := sut.AssignMarathonSpecificationFor("a", "1", spec)
+	c.Assert(err, NotNil)
+	c.Assert(err.Error(), Matches, "(?s).*\\[id\\].*\\[health\\].*")
+	c.Assert(rep.AssignedSpec, Equals, "")
+}
+
+func (s *ControllerSuite) TestSkipsMarathonLintForOtherDeployers(c *C) {
+	spec := "manifest.test.yml"
+	defer os.Remove(spec)
+	rep := &AllGoodRepo{Service: data.TrackedService{Deployer: "kubernetes"}}
+	sut := &Controller{Repo: rep, Deployers: map[string]deployer.IDeployer{"kubernetes": &DeployerSpy{}}}
+
+	c.Assert(ioutil.WriteFile(spec, []byte("kind: Deployment"), 0644), IsNil)
+	c.Assert(sut.AssignMarathonSpecificationFor("a", "1", spec), IsNil)
+	c.Assert(rep.AssignedSpec, Equals, "kind: Deployment")
+}
+
+func (s *ControllerSuite) TestCanaryDeploymentAwaitsPromotion(c *C) {
+	marathon := &RolloutDeployerSpy{}
+	rep := &AllGoodRepo{Service: data.TrackedService{Strategy: "canary", CanaryInstances: 2}}
+	sut := &Controller{Repo: rep, Deployer: marathon}
+
+	c.Assert(sut.TriggerCandidateDeployment("a", "2"), IsNil)
+	c.Assert(marathon.Calls, DeepEquals, []string{"canary 2"})
+	c.Assert(len(rep.Spies), Equals, 0)
+
+	c.Assert(sut.PromoteRollout("a", "2"), IsNil)
+	c.Assert(marathon.Calls, DeepEquals, []string{"canary 2", "promote canary"})
+	c.Assert(rep.Spies[0].StageName, Equals, "Deployed")
+}
+
+func (s *ControllerSuite) TestBlueGreenDeploymentCompletesImmediately(c *C) {
+	marathon := &RolloutDeployerSpy{}
+	rep := &AllGoodRepo{Service: data.TrackedService{Strategy: "bluegreen"}}
+	sut := &Controller{Repo: rep, Deployer: marathon}
+
+	c.Assert(sut.TriggerCandidateDeployment("a", "2"), IsNil)
+	c.Assert(marathon.Calls, DeepEquals, []string{"bluegreen"})
+	c.Assert(rep.Spies[0].StageName, Equals, "Deployed")
+
+	c.Assert(sut.AbortRollout("a", "2"), IsNil)
+	c.Assert(marathon.Calls, DeepEquals, []string{"bluegreen", "abort bluegreen"})
+}
+
+func (s *ControllerSuite) TestAbortsRolloutWithTheSpecOfTheEnvironment(c *C) {
+	marathon := &RolloutDeployerSpy{}
+	rep := &AllGoodRepo{
+		Service:   data.TrackedService{Strategy: "bluegreen"},
+		Candidate: data.DeploymentCandidate{Spec: `{"id": "app", "instances": 1}`},
+	}
+	sut := &Controller{Repo: rep, Deployer: marathon, Environment: "production", Environments: []Environment{
+		{Name: "production", Overlay: map[string]interface{}{"instances": 4}},
+	}}
+
+	c.Assert(sut.AbortRollout("a", "2"), IsNil)
+	c.Assert(marathon.Aborted, Matches, `.*"instances":4.*`)
+}
+
+func (s *ControllerSuite) TestCannotPromoteInPlaceDeployment(c *C) {
+	sut := &Controller{Repo: &AllGoodRepo{}, Deployer: &RolloutDeployerSpy{}}
+	c.Assert(sut.PromoteRollout("a", "2"), NotNil)
+}
+
+func (s *ControllerSuite) TestCannotUseStrategyUnsupportedByDeployer(c *C) {
+	rep := &AllGoodRepo{Service: data.TrackedService{Strategy: "canary"}}
+	sut := &Controller{Repo: rep, Deployer: &DeployerSpy{}}
+	c.Assert(sut.TriggerCandidateDeployment("a", "2"), NotNil)
+}
+
+func (s *ControllerSuite) TestCanPromoteCandidateToNextEnvironment(c *C) {
+	staging, production := &DeployerSpy{}, &DeployerSpy{}
+	rep := &AllGoodRepo{Candidate: data.DeploymentCandidate{Version: "3", Spec: `{"id": "a", "env": {"ENV": "{{.Environment}}"}}`}}
+	sut := &Controller{Repo: rep, Deployer: staging, Environment: "staging", Environments: []Environment{
+		{Name: "staging", Deployer: staging},
+		{Name: "production", Deployer: production, Overlay: map[string]interface{}{"instances": 4}},
+	}}
+
+	err := sut.PromoteCandidate("a", "staging")
+	c.Assert(err, IsNil)
+	c.Assert(len(staging.Specs), Equals, 0)
+	c.Assert(production.Specs, DeepEquals, []string{`{"env":{"ENV":"production"},"id":"a","instances":4}`})
+	c.Assert(rep.Spies[0].StageName, Equals, "Deployed")
+	c.Assert(rep.DeployedIn, DeepEquals, []string{"a@3 in production"})
+	c.Assert(sut.Environment, Equals, "staging")
+}
+
+func (s *ControllerSuite) TestCannotPromoteFromLastOrUnknownEnvironment(c *C) {
+	sut := &Controller{Repo: &AllGoodRepo{}, Environments: []Environment{{Name: "staging"}, {Name: "production"}}}
+	c.Assert(sut.PromoteCandidate("a", "production"), NotNil)
+	c.Assert(sut.PromoteCandidate("a", "qa"), NotNil)
+}
+
+func (s *ControllerSuite) TestRecordsDeploymentInCurrentEnvironment(c *C) {
+	rep := &AllGoodRepo{}
+	sut := &Controller{Repo: rep, Deployer: &DeployerSpy{}, Environment: "dev"}
+
+	c.Assert(sut.TriggerCandidateDeployment("a", "1"), IsNil)
+	c.Assert(rep.DeployedIn, DeepEquals, []string{"a@1 in dev"})
+}
+
+func (s *ControllerSuite) TestCanScaleRestartAndSuspendDeployedService(c *C) {
+	marathon := &DeployerSpy{}
+	rep := &AllGoodRepo{Candidate: data.DeploymentCandidate{Version: "4", Spec: `{"id": "a"}`}}
+	sut := &Controller{Repo: rep, Deployer: marathon, Environment: "staging"}
+
+	c.Assert(sut.ScaleService("a", "-1", 3), IsNil)
+	c.Assert(sut.RestartService("a", "-1"), IsNil)
+	c.Assert(sut.SuspendService("a", "4"), IsNil)
+	c.Assert(marathon.Operations, DeepEquals, []string{"scale 3", "restart", "suspend"})
+	c.Assert(rep.Recorded["a@4 in staging"], DeepEquals, []string{"suspended"})
+	c.Assert(len(rep.Spies), Equals, 0)
+	c.Assert(rep.RenderedSpec, Equals, "", Commentf("only deployments record the rendered spec"))
+}
+
+func (s *ControllerSuite) TestOperatesWithTheDeployerOfTheService(c *C) {
+	kube := &DeployerSpy{}
+	rep := &AllGoodRepo{Service: data.TrackedService{Deployer: "kubernetes"}}
+	sut := &Controller{Repo: rep, Deployer: &DeployerSpy{}, Deployers: map[string]deployer.IDeployer{"kubernetes": kube}}
+
+	c.Assert(sut.RestartService("a", "1"), IsNil)
+	c.Assert(kube.Operations, DeepEquals, []string{"restart"})
+}
+
+func (s *ControllerSuite) TestCancelsDeploymentsAndLogsAgainstCandidate(c *C) {
+	marathon := &CancellingDeployerSpy{}
+	rep := &AllGoodRepo{Candidate: data.DeploymentCandidate{Version: "2", Spec: `{"id": "a"}`}}
+	sut := &Controller{Repo: rep, Deployer: marathon, Environment: "staging"}
+
+	c.Assert(sut.CancelDeployments("a", "2", true), IsNil)
+	c.Assert(marathon.Rollback, Equals, true)
+	c.Assert(rep.Events, DeepEquals, []data.CandidateEvent{
+		{Environment: "staging", Action: "rollback_deployment", Detail: "d1 rolled back by r1"},
+	})
+
+	c.Assert(sut.CancelDeployments("a", "2", false), IsNil)
+	c.Assert(rep.Events[1].Action, Equals, "delete_deployment")
+	c.Assert(rep.Events[1].Detail, Equals, "d1")
+}
+
+func (s *ControllerSuite) TestCannotCancelWithDeployerWithoutSupport(c *C) {
+	sut := &Controller{Repo: &AllGoodRepo{}, Deployer: &DeployerSpy{}}
+	c.Assert(sut.CancelDeployments("a", "2", true), NotNil)
+}
+
+func (s *ControllerSuite) TestMarksDeployedOnceHealthyAndSmokeChecked(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	marathon := &HealthDeployerSpy{}
+	rep := &AllGoodRepo{Service: data.TrackedService{HealthyTasks: 2, SmokeChecks: []data.SmokeCheck{{URL: server.URL}}}}
+	sut := &Controller{Repo: rep, Deployer: marathon}
+
+	c.Assert(sut.TriggerCandidateDeployment("a", "1"), IsNil)
+	c.Assert(marathon.Healthy, Equals, 2)
+	c.Assert(rep.Spies[0].StageName, Equals, "Deployed")
+}
+
+func (s *ControllerSuite) TestDoesNotMarkDeployedWhenUnhealthy(c *C) {
+	marathon := &HealthDeployerSpy{Unhealthy: errors.New("unhealthy")}
+	rep := &AllGoodRepo{}
+	sut := &Controller{Repo: rep, Deployer: marathon}
+
+	c.Assert(sut.TriggerCandidateDeployment("a", "1"), NotNil)
+	c.Assert(len(rep.Spies), Equals, 0)
+	c.Assert(marathon.RolledBack, Equals, false)
+}
+
+func (s *ControllerSuite) TestRollsBackWhenSmokeChecksFail(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	marathon := &HealthDeployerSpy{}
+	rep := &AllGoodRepo{Service: data.TrackedService{RollbackOnFailure: true, SmokeChecks: []data.SmokeCheck{{URL: server.URL}}}}
+	sut := &Controller{Repo: rep, Deployer: marathon}
+
+	c.Assert(sut.TriggerCandidateDeployment("a", "1"), NotNil)
+	c.Assert(marathon.RolledBack, Equals, true)
+	c.Assert(len(rep.Spies), Equals, 0)
+	c.Assert(rep.Events[0].Action, Equals, "rollback")
+}
+
+func (s *ControllerSuite) TestAbortsBlueGreenWhenUnhealthy(c *C) {
+	marathon := &RolloutDeployerSpy{}
+	rep := &AllGoodRepo{Service: data.TrackedService{Name: "a", Strategy: "bluegreen", RollbackOnFailure: true,
+		SmokeChecks: []data.SmokeCheck{{URL: "http://127.0.0.1:1/unreachable"}}}}
+	sut := &Controller{Repo: rep, Deployer: marathon}
+
+	c.Assert(sut.TriggerCandidateDeployment("a", "1"), NotNil)
+	c.Assert(marathon.Calls, DeepEquals, []string{"bluegreen", "abort bluegreen"})
+}
+
+func (s *ControllerSuite) TestReportsFailedBlueGreenAbort(c *C) {
+	marathon := &RolloutDeployerSpy{AbortFailure: errors.New("no previous colour")}
+	rep := &AllGoodRepo{Service: data.TrackedService{Name: "a", Strategy: "bluegreen", RollbackOnFailure: true,
+		SmokeChecks: []data.SmokeCheck{{URL: "http://127.0.0.1:1/unreachable"}}}}
+	sut := &Controller{Repo: rep, Deployer: marathon}
+
+	err := sut.TriggerCandidateDeployment("a", "1")
+	c.Assert(err, ErrorMatches, ".*rolling back failed: no previous colour")
+	c.Assert(len(rep.Events), Equals, 0)
+}
+
+func newMarathonTestController(server *marathontest.Server, rep *AllGoodRepo) *Controller {
+	marathon := deployer.NewDeployer(server.URL).(*deployer.MarathonDeployer)
+	marathon.LogOutput = nil
+	marathon.Timeout = 200 * time.Millisecond
+	marathon.PollInterval = time.Millisecond
+	return &Controller{Repo: rep, Deployer: marathon}
+}
+
+func (s *ControllerSuite) TestDeploysCandidateToMarathonEndToEnd(c *C) {
+	server := marathontest.NewServer()
+	defer server.Close()
+	rep := &AllGoodRepo{Candidate: data.DeploymentCandidate{Version: "2", Image: "group/web:2",
+		Spec: `{"id": "/web", "instances": 2, "container": {"docker": {"image": "{{.Image}}"}}}`}}
+
+	err := newMarathonTestController(server, rep).TriggerCandidateDeployment("web", "2")
+	c.Assert(err, IsNil)
+	app, ok := server.App("/web")
+	c.Assert(ok, Equals, true)
+	c.Assert(app["container"], DeepEquals, map[string]interface{}{"docker": map[string]interface{}{"image": "group/web:2"}})
+	c.Assert(rep.Spies[0].StageName, Equals, "Deployed")
+	c.Assert(len(rep.Deployments), Equals, 1)
+	record := rep.Deployments[0]
+	c.Assert(record.AppID, Equals, "/web")
+	c.Assert(record.AppVersion, Equals, app["version"])
+	c.Assert(record.NewDeployment, Equals, true)
+	c.Assert(record.Outcome, Equals, data.DeploymentSucceeded)
+	c.Assert(len(record.DeploymentIds), Equals, 1)
+}
+
+func (s *ControllerSuite) TestRollsBackFailedMarathonDeploymentEndToEnd(c *C) {
+	server := marathontest.NewServer()
+	defer server.Close()
+	c.Assert(server.AddApp(`{"id": "/web", "instances": 2}`), IsNil)
+	server.Script("/web", marathontest.Fail, 0)
+	rep := &AllGoodRepo{Service: data.TrackedService{RollbackOnFailure: true},
+		Candidate: data.DeploymentCandidate{Version: "3", Spec: `{"id": "/web", "instances": 4}`}}
+
+	err := newMarathonTestController(server, rep).TriggerCandidateDeployment("web", "3")
+	c.Assert(err, NotNil)
+	c.Assert(len(rep.Spies), Equals, 0)
+	c.Assert(rep.Events[0].Action, Equals, "rollback")
+	app, _ := server.App("/web")
+	c.Assert(app["instances"], Equals, float64(2))
+	c.Assert(rep.Deployments[0].Outcome, Equals, data.DeploymentFailed)
+	c.Assert(rep.Deployments[0].Error, Equals, err.Error())
+}
+
+func (s *ControllerSuite) TestRecordsPendingCanaryAndPromotion(c *C) {
+	marathon := &RolloutDeployerSpy{}
+	rep := &AllGoodRepo{
+		Service:   data.TrackedService{Name: "a", Strategy: "canary"},
+		Candidate: data.DeploymentCandidate{Spec: `{"id": "app"}`},
+	}
+	sut := &Controller{Repo: rep, Deployer: marathon, Environment: "prod"}
+
+	c.Assert(sut.TriggerCandidateDeployment("a", "1"), IsNil)
+	rep.RenderedSpec = ""
+	c.Assert(sut.PromoteRollout("a", "1"), IsNil)
+	c.Assert(rep.RenderedSpec, Equals, `{"id": "app"}`)
+	c.Assert(len(rep.Deployments), Equals, 2)
+	c.Assert(rep.Deployments[0].Outcome, Equals, data.DeploymentPending)
+	c.Assert(rep.Deployments[0].AppID, Equals, "app-canary")
+	c.Assert(rep.Deployments[1].Outcome, Equals, data.DeploymentSucceeded)
+	c.Assert(rep.Deployments[1].Environment, Equals, "prod")
 }
 
 //stubs
@@ -97,7 +677,23 @@ type RepoSpy struct {
 }
 
 type AllGoodRepo struct {
-	Spies []RepoSpy
+	Spies        []RepoSpy
+	Service      data.TrackedService
+	Candidate    data.DeploymentCandidate
+	Candidates   map[string]data.DeploymentCandidate
+	AssignedSpec string
+	RenderedSpec string
+	DeployedIn   []string
+	Recorded     map[string][]string
+	Events       []data.CandidateEvent
+	Deployments  []data.DeploymentRecord
+	Snapshots    map[string]data.StoredSnapshot
+	Tracked      []string
+	E2E          []data.DeploymentCandidate
+	// SnapshotFailure is returned by FindSnapshot for unknown snapshots instead of not found
+	SnapshotFailure error
+	// Untracked makes FindTrackedService report that the service is not tracked
+	Untracked bool
 }
 
 func (s *AllGoodRepo) CompleteStage(name, version, stage string) error {
@@ -114,17 +710,25 @@ func (s *AllGoodRepo) RegisterNewCandidate(name, image, version string) error {
 	return nil
 }
 func (s *AllGoodRepo) AssignMarathonSpecToCandidate(name, version, specContent string) error {
+	s.AssignedSpec = specContent
+	return nil
+}
+func (s *AllGoodRepo) RecordRenderedSpec(name, version, specContent string) error {
+	s.RenderedSpec = specContent
 	return nil
 }
 func (s *AllGoodRepo) MarkCandidateAsSucceeded(name, version string) error {
 	return nil
 }
 func (s *AllGoodRepo) GetCandidatesForE2E() ([]data.DeploymentCandidate, error) {
-	return nil, nil
+	return s.E2E, nil
 }
 
 func (s *AllGoodRepo) FindCandidate(name, version string) (data.DeploymentCandidate, error) {
-	return data.DeploymentCandidate{}, nil
+	if candidate, ok := s.Candidates[name]; ok {
+		return candidate, nil
+	}
+	return s.Candidate, nil
 }
 
 func (s *AllGoodRepo) Dispose() error {
@@ -132,7 +736,194 @@ func (s *AllGoodRepo) Dispose() error {
 }
 
 func (s *AllGoodRepo) GetTrackedServices() ([]string, error) {
-	return nil, nil
+	return s.Tracked, nil
+}
+
+func (s *AllGoodRepo) FindTrackedService(name string) (data.TrackedService, error) {
+	if s.Untracked {
+		return data.TrackedService{}, data.ServiceNotTrackedError{Name: name}
+	}
+	return s.Service, nil
+}
+
+func (s *AllGoodRepo) MarkDeployedIn(name, version, environment string) error {
+	s.DeployedIn = append(s.DeployedIn, name+"@"+version+" in "+environment)
+	return nil
+}
+
+func (s *AllGoodRepo) FindDeployedCandidate(name, environment string) (data.DeploymentCandidate, error) {
+	return s.Candidate, nil
+}
+
+func (s *AllGoodRepo) RecordDeploymentIds(name, version, environment string, ids []string) error {
+	if s.Recorded == nil {
+		s.Recorded = make(map[string][]string)
+	}
+	s.Recorded[name+"@"+version+" in "+environment] = ids
+	return nil
+}
+
+func (s *AllGoodRepo) LogCandidateEvent(name, version string, event data.CandidateEvent) error {
+	s.Events = append(s.Events, event)
+	return nil
+}
+
+func (s *AllGoodRepo) RecordDeployment(name, version string, record data.DeploymentRecord) error {
+	s.Deployments = append(s.Deployments, record)
+	return nil
+}
+
+func (s *AllGoodRepo) FindCandidateByAppVersion(name, appVersion string) (data.DeploymentCandidate, error) {
+	return s.Candidate, nil
+}
+
+func (s *AllGoodRepo) SaveSnapshot(snapshot data.StoredSnapshot) error {
+	if s.Snapshots == nil {
+		s.Snapshots = make(map[string]data.StoredSnapshot)
+	}
+	snapshot.State = data.SnapshotComposed
+	s.Snapshots[snapshot.ID] = snapshot
+	return nil
+}
+
+func (s *AllGoodRepo) FindSnapshot(id string) (data.StoredSnapshot, error) {
+	for key, snapshot := range s.Snapshots {
+		if strings.HasPrefix(key, id) {
+			return snapshot, nil
+		}
+	}
+	if s.SnapshotFailure != nil {
+		return data.StoredSnapshot{}, s.SnapshotFailure
+	}
+	return data.StoredSnapshot{}, data.SnapshotNotFoundError{ID: id}
+}
+
+func (s *AllGoodRepo) ChangeSnapshotState(id, state string) error {
+	snapshot, err := s.FindSnapshot(id)
+	if err != nil {
+		return err
+	}
+	snapshot.State = state
+	s.Snapshots[snapshot.ID] = snapshot
+	return nil
+}
+
+type DeployerSpy struct {
+	Specs      []string
+	Operations []string
+}
+
+func (s *DeployerSpy) Deploy(jsonContent []byte) (*deployer.ExpectedDeployment, error) {
+	s.Specs = append(s.Specs, string(jsonContent))
+	return &deployer.ExpectedDeployment{AppId: "app"}, nil
+}
+
+func (s *DeployerSpy) Scale(jsonContent []byte, instances int) (*deployer.ExpectedDeployment, error) {
+	s.Operations = append(s.Operations, fmt.Sprintf("scale %d", instances))
+	return &deployer.ExpectedDeployment{AppId: "app", DeploymentIds: []string{"scaled"}}, nil
+}
+
+func (s *DeployerSpy) Restart(jsonContent []byte) (*deployer.ExpectedDeployment, error) {
+	s.Operations = append(s.Operations, "restart")
+	return &deployer.ExpectedDeployment{AppId: "app", DeploymentIds: []string{"restarted"}}, nil
+}
+
+func (s *DeployerSpy) Suspend(jsonContent []byte) (*deployer.ExpectedDeployment, error) {
+	s.Operations = append(s.Operations, "suspend")
+	return &deployer.ExpectedDeployment{AppId: "app", DeploymentIds: []string{"suspended"}}, nil
+}
+
+type GroupDeployerSpy struct {
+	DeployerSpy
+	GroupID    string
+	Groups     []string
+	RolledBack string
+}
+
+func (s *GroupDeployerSpy) DeployGroup(groupID string, specs [][]byte) (*deployer.ExpectedDeployment, error) {
+	s.GroupID = groupID
+	for _, spec := range specs {
+		s.Groups = append(s.Groups, string(spec))
+	}
+	return &deployer.ExpectedDeployment{AppId: groupID, DeploymentIds: []string{"dep"}}, nil
+}
+
+func (s *GroupDeployerSpy) RollbackGroup(groupID string) (*deployer.ExpectedDeployment, error) {
+	s.RolledBack = groupID
+	return &deployer.ExpectedDeployment{AppId: groupID, DeploymentIds: []string{"dep"}}, nil
+}
+
+type RolloutDeployerSpy struct {
+	DeployerSpy
+	Calls        []string
+	Aborted      string
+	AbortFailure error
+}
+
+func (s *RolloutDeployerSpy) DeployBlueGreen(jsonContent []byte) (*deployer.ExpectedDeployment, error) {
+	s.Calls = append(s.Calls, "bluegreen")
+	return &deployer.ExpectedDeployment{AppId: "app-blue"}, nil
+}
+
+func (s *RolloutDeployerSpy) DeployCanary(jsonContent []byte, instances int) (*deployer.ExpectedDeployment, error) {
+	s.Calls = append(s.Calls, fmt.Sprintf("canary %d", instances))
+	return &deployer.ExpectedDeployment{AppId: "app-canary"}, nil
+}
+
+func (s *RolloutDeployerSpy) Promote(strategy string, jsonContent []byte) (*deployer.ExpectedDeployment, error) {
+	s.Calls = append(s.Calls, "promote "+strategy)
+	return &deployer.ExpectedDeployment{AppId: "app"}, nil
+}
+
+func (s *RolloutDeployerSpy) Abort(strategy string, jsonContent []byte) (*deployer.ExpectedDeployment, error) {
+	s.Calls = append(s.Calls, "abort "+strategy)
+	s.Aborted = string(jsonContent)
+	if s.AbortFailure != nil {
+		return nil, s.AbortFailure
+	}
+	return &deployer.ExpectedDeployment{AppId: "app"}, nil
+}
+
+type CancellingDeployerSpy struct {
+	DeployerSpy
+	Rollback bool
+}
+
+func (s *CancellingDeployerSpy) CancelDeployments(jsonContent []byte, rollback bool) (*deployer.CancelledDeployment, error) {
+	s.Rollback = rollback
+	cancelled := &deployer.CancelledDeployment{AppId: "app", Cancelled: []string{"d1"}}
+	if rollback {
+		cancelled.Rollbacks = []string{"r1"}
+	}
+	return cancelled, nil
+}
+
+type HealthDeployerSpy struct {
+	DeployerSpy
+	Unhealthy  error
+	Healthy    int
+	RolledBack bool
+}
+
+func (s *HealthDeployerSpy) WaitForHealthyTasks(jsonContent []byte, healthy int) error {
+	s.Healthy = healthy
+	return s.Unhealthy
+}
+
+func (s *HealthDeployerSpy) Rollback(jsonContent []byte) (*deployer.ExpectedDeployment, error) {
+	s.RolledBack = true
+	return &deployer.ExpectedDeployment{AppId: "app"}, nil
+}
+
+// ComposerSpy records the candidates it composes
+type ComposerSpy struct {
+	AllGoodComposer
+	Candidates []data.DeploymentCandidate
+}
+
+func (s *ComposerSpy) PrepareComposerContent(candidates []data.DeploymentCandidate) ([]byte, error) {
+	s.Candidates = candidates
+	return s.AllGoodComposer.PrepareComposerContent(candidates)
 }
 
 type AllGoodComposer struct {
@@ -143,5 +934,5 @@ func (s *AllGoodComposer) PrepareComposerContent(candidates []data.DeploymentCan
 }
 
 func (s *AllGoodComposer) PrepareFinalizableCandidatesSnapshotContent(candidates []data.DeploymentCandidate) ([]byte, error) {
-	return []byte("hooo"), nil
+	return []byte(storedSnapJsContent), nil
 }
@@ -2,8 +2,59 @@ package data
 
 // TrackedService represents a micro service that is allowed and tracked in the deployment pipeline
 type TrackedService struct {
-	Name        string `json:"Name" bson:"Name"`
-	Description string `json:"Description" bson:"Description"`
+	Name            string `json:"Name" bson:"Name"`
+	Description     string `json:"Description" bson:"Description"`
+	Deployer        string `json:"Deployer" bson:"Deployer"`
+	Strategy        string `json:"Strategy" bson:"Strategy"`
+	CanaryInstances int    `json:"CanaryInstances" bson:"CanaryInstances"`
+
+	HealthyTasks      int          `json:"HealthyTasks" bson:"HealthyTasks"`
+	SmokeChecks       []SmokeCheck `json:"SmokeChecks" bson:"SmokeChecks"`
+	RollbackOnFailure bool         `json:"RollbackOnFailure" bson:"RollbackOnFailure"`
+}
+
+// SmokeCheck is an HTTP probe run against a service once it is deployed and healthy
+type SmokeCheck struct {
+	URL            string `json:"URL" bson:"URL"`
+	ExpectedStatus int    `json:"ExpectedStatus" bson:"ExpectedStatus"`
+	BodyMatch      string `json:"BodyMatch" bson:"BodyMatch"`
+}
+
+// EnvironmentState records the deployment of a candidate to a named environment
+type EnvironmentState struct {
+	Deployed      bool     `json:"Deployed" bson:"Deployed"`
+	DeployedAt    int64    `json:"DeployedAt" bson:"DeployedAt"`
+	DeploymentIds []string `json:"DeploymentIds" bson:"DeploymentIds"`
+}
+
+// CandidateEvent records an operation performed against a candidate outside of the pipeline stages
+type CandidateEvent struct {
+	At          int64  `json:"At" bson:"At"`
+	Environment string `json:"Environment" bson:"Environment"`
+	Action      string `json:"Action" bson:"Action"`
+	Detail      string `json:"Detail" bson:"Detail"`
+}
+
+const (
+	// DeploymentSucceeded is the outcome of a deployment that completed and passed its checks
+	DeploymentSucceeded = "succeeded"
+	// DeploymentFailed is the outcome of a deployment that could not be completed or failed its checks
+	DeploymentFailed = "failed"
+	// DeploymentPending is the outcome of a rollout that awaits promotion
+	DeploymentPending = "pending"
+)
+
+// DeploymentRecord describes one deployment of a candidate by the pipeline
+type DeploymentRecord struct {
+	AppID         string   `json:"AppID" bson:"AppID"`
+	AppVersion    string   `json:"AppVersion" bson:"AppVersion"`
+	DeploymentIds []string `json:"DeploymentIds" bson:"DeploymentIds"`
+	NewDeployment bool     `json:"NewDeployment" bson:"NewDeployment"`
+	Environment   string   `json:"Environment" bson:"Environment"`
+	Started       int64    `json:"Started" bson:"Started"`
+	Finished      int64    `json:"Finished" bson:"Finished"`
+	Outcome       string   `json:"Outcome" bson:"Outcome"`
+	Error         string   `json:"Error" bson:"Error"`
 }
 
 // DeploymentCandidate represents candidate deployments that go through the deployment pipeline
@@ -17,8 +68,61 @@ type DeploymentCandidate struct {
 	Unit            bool   `json:"Unit" bson:"Unit"`
 	E2E             bool   `json:"E2E" bson:"E2E"`
 	Deployed        bool   `json:"Deployed" bson:"Deployed"`
-	MarathonSpec    string `json:"MarathonSpec" bson:"MarathonSpec"`
+	Spec            string `json:"MarathonSpec" bson:"MarathonSpec"` // a marathon app or kubernetes manifests
+	RenderedSpec    string `json:"RenderedSpec" bson:"RenderedSpec"`
 	ServiceName     string `json:"ServiceName" bson:"ServiceName"`
+
+	DeploymentIds []string                    `json:"DeploymentIds" bson:"DeploymentIds"`
+	Environments  map[string]EnvironmentState `json:"Environments" bson:"Environments"`
+	Events        []CandidateEvent            `json:"Events" bson:"Events"`
+	Deployments   []DeploymentRecord          `json:"Deployments" bson:"Deployments"`
+}
+
+// Snapshot states, in lifecycle order
+const (
+	// SnapshotComposed is the state of a snapshot that was just composed
+	SnapshotComposed = "composed"
+	// SnapshotTesting is the state of a snapshot deployed to the E2E environment
+	SnapshotTesting = "testing"
+	// SnapshotAccepted is the state of a snapshot whose candidates passed E2E testing
+	SnapshotAccepted = "accepted"
+	// SnapshotDeployed is the state of a snapshot whose candidates were deployed past E2E testing
+	SnapshotDeployed = "deployed"
+	// SnapshotRejected is the state of a snapshot that must not be deployed
+	SnapshotRejected = "rejected"
+)
+
+// SnapshotStateChange records a transition of a snapshot
+type SnapshotStateChange struct {
+	At    int64  `json:"At" bson:"At"`
+	State string `json:"State" bson:"State"`
+}
+
+// SnapshotNotFoundError is returned when no stored snapshot matches an id
+type SnapshotNotFoundError struct {
+	ID string
+}
+
+func (e SnapshotNotFoundError) Error() string {
+	return "snapshot " + e.ID + " not found"
+}
+
+// ServiceNotTrackedError is returned when a service has no tracked entry
+type ServiceNotTrackedError struct {
+	Name string
+}
+
+func (e ServiceNotTrackedError) Error() string {
+	return "service " + e.Name + " is not tracked"
+}
+
+// StoredSnapshot is a snapshot of candidates kept in the repository, its content being the snapshot file
+type StoredSnapshot struct {
+	ID      string                `json:"ID" bson:"_id"`
+	State   string                `json:"State" bson:"State"`
+	Created int64                 `json:"Created" bson:"Created"`
+	Content string                `json:"Content" bson:"Content"`
+	History []SnapshotStateChange `json:"History" bson:"History"`
 }
 
 // IRepository defines the set of operations applicable to the tables/collection used through the pipeline
@@ -27,7 +131,19 @@ type IRepository interface {
 	CompleteStage(name, version, stage string) error
 	RegisterNewCandidate(name, image, version string) error
 	AssignMarathonSpecToCandidate(name, version, specContent string) error
+	RecordRenderedSpec(name, version, specContent string) error
 	MarkCandidateAsSucceeded(name, version string) error
 	GetCandidatesForE2E() ([]DeploymentCandidate, error)
+	GetTrackedServices() ([]string, error)
+	FindTrackedService(name string) (TrackedService, error)
+	MarkDeployedIn(name, version, environment string) error
+	FindDeployedCandidate(name, environment string) (DeploymentCandidate, error)
+	RecordDeploymentIds(name, version, environment string, ids []string) error
+	LogCandidateEvent(name, version string, event CandidateEvent) error
+	RecordDeployment(name, version string, record DeploymentRecord) error
+	FindCandidateByAppVersion(name, appVersion string) (DeploymentCandidate, error)
+	SaveSnapshot(snapshot StoredSnapshot) error
+	FindSnapshot(id string) (StoredSnapshot, error)
+	ChangeSnapshotState(id, state string) error
 	Dispose() error
 }
@@ -3,6 +3,7 @@ package data
 import (
 	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 	"time"
 
@@ -82,12 +83,161 @@ func (r *CandidateRepository) AssignMarathonSpecToCandidate(name, version, specC
 	return c.Update(bson.M{"Version": version}, bson.M{"$set": bson.M{"MarathonSpec": specContent}})
 }
 
+// RecordRenderedSpec stores the spec that was rendered from the template and sent to the deployer
+func (r *CandidateRepository) RecordRenderedSpec(name, version, specContent string) error {
+	c := r.Session.DB(dbName).C(name)
+	return c.Update(bson.M{"Version": version}, bson.M{"$set": bson.M{"RenderedSpec": specContent}})
+}
+
+// MarkDeployedIn records that a candidate has been deployed to the given environment
+func (r *CandidateRepository) MarkDeployedIn(name, version, environment string) error {
+	c := r.Session.DB(dbName).C(name)
+	state := "Environments." + environment
+	return c.Update(bson.M{"Version": version}, bson.M{"$set": bson.M{
+		state + ".Deployed":   true,
+		state + ".DeployedAt": time.Now().Unix(),
+	}})
+}
+
+// RecordDeploymentIds stores the ids of the last deployments performed for the candidate, also
+// against the environment when one is given
+func (r *CandidateRepository) RecordDeploymentIds(name, version, environment string, ids []string) error {
+	c := r.Session.DB(dbName).C(name)
+	update := bson.M{"DeploymentIds": ids}
+	if environment != "" {
+		update["Environments."+environment+".DeploymentIds"] = ids
+	}
+	return c.Update(bson.M{"Version": version}, bson.M{"$set": update})
+}
+
+// LogCandidateEvent appends the event to the candidate's history, timestamping it when needed
+func (r *CandidateRepository) LogCandidateEvent(name, version string, event CandidateEvent) error {
+	if event.At == 0 {
+		event.At = time.Now().Unix()
+	}
+	c := r.Session.DB(dbName).C(name)
+	return c.Update(bson.M{"Version": version}, bson.M{"$push": bson.M{"Events": event}})
+}
+
+// RecordDeployment appends the record to the candidate's deployments, and keeps the marathon version
+// of the app that the candidate last successfully deployed
+func (r *CandidateRepository) RecordDeployment(name, version string, record DeploymentRecord) error {
+	c := r.Session.DB(dbName).C(name)
+	update := bson.M{"$push": bson.M{"Deployments": record}}
+	if record.Outcome == DeploymentSucceeded && record.AppVersion != "" {
+		update["$set"] = bson.M{"MarathonVersion": record.AppVersion}
+	}
+	return c.Update(bson.M{"Version": version}, update)
+}
+
+// FindCandidateByAppVersion retrieves the candidate whose deployment produced the given app version
+func (r *CandidateRepository) FindCandidateByAppVersion(name, appVersion string) (DeploymentCandidate, error) {
+	res := DeploymentCandidate{}
+	c := r.Session.DB(dbName).C(name)
+	err := c.Find(bson.M{"Deployments.AppVersion": appVersion}).One(&res)
+	return res, err
+}
+
+func (r *CandidateRepository) snapshots() *mgo.Collection {
+	return r.Session.DB(dbName).C(r.Catalog + "_snapshots")
+}
+
+// snapshotTransitions lists the states each snapshot state may move to
+var snapshotTransitions = map[string][]string{
+	SnapshotComposed: {SnapshotTesting, SnapshotAccepted, SnapshotRejected},
+	SnapshotTesting:  {SnapshotAccepted, SnapshotRejected},
+	SnapshotAccepted: {SnapshotDeployed, SnapshotRejected},
+}
+
+// SaveSnapshot stores a newly composed snapshot. A snapshot composed again replaces the stored one while
+// it is still composed. Once its lifecycle has moved on, the stored one is kept as is when the content is
+// the same, as when a compose job is rerun, and the snapshot is refused when rejected or changed.
+func (r *CandidateRepository) SaveSnapshot(snapshot StoredSnapshot) error {
+	now := time.Now().Unix()
+	if snapshot.Created == 0 {
+		snapshot.Created = now
+	}
+	snapshot.State = SnapshotComposed
+	snapshot.History = []SnapshotStateChange{{At: now, State: SnapshotComposed}}
+	err := r.snapshots().Insert(snapshot)
+	if !mgo.IsDup(err) {
+		return err
+	}
+	stored := StoredSnapshot{}
+	if err := r.snapshots().FindId(snapshot.ID).One(&stored); err != nil {
+		return err
+	}
+	switch {
+	case stored.State == SnapshotRejected:
+		return fmt.Errorf("snapshot %s was rejected and cannot be composed again", stored.ID)
+	case stored.State == SnapshotComposed:
+	case stored.Content == snapshot.Content:
+		return nil
+	default:
+		return fmt.Errorf("snapshot %s is %s and cannot be composed again with a different content", stored.ID, stored.State)
+	}
+	return r.snapshots().Update(bson.M{"_id": snapshot.ID, "State": SnapshotComposed},
+		bson.M{"$set": bson.M{"Content": snapshot.Content, "Created": snapshot.Created}})
+}
+
+// FindSnapshot retrieves the snapshot with the given id, or the only one whose id starts with it
+func (r *CandidateRepository) FindSnapshot(id string) (StoredSnapshot, error) {
+	var found []StoredSnapshot
+	err := r.snapshots().Find(bson.M{"_id": bson.RegEx{Pattern: "^" + regexp.QuoteMeta(id)}}).Limit(2).All(&found)
+	switch {
+	case err != nil:
+		return StoredSnapshot{}, err
+	case len(found) == 0:
+		return StoredSnapshot{}, SnapshotNotFoundError{ID: id}
+	case len(found) > 1:
+		return StoredSnapshot{}, errors.New("snapshot id " + id + " is ambiguous")
+	}
+	return found[0], nil
+}
+
+// ChangeSnapshotState moves the snapshot to the given state when its lifecycle allows it. Changing to the
+// current state is a no-op.
+func (r *CandidateRepository) ChangeSnapshotState(id, state string) error {
+	snapshot, err := r.FindSnapshot(id)
+	if err != nil {
+		return err
+	}
+	if snapshot.State == state {
+		return nil
+	}
+	allowed := false
+	for _, next := range snapshotTransitions[snapshot.State] {
+		allowed = allowed || next == state
+	}
+	if !allowed {
+		return fmt.Errorf("snapshot %s is %s and cannot become %s", snapshot.ID, snapshot.State, state)
+	}
+	return r.snapshots().UpdateId(snapshot.ID, bson.M{
+		"$set":  bson.M{"State": state},
+		"$push": bson.M{"History": SnapshotStateChange{At: time.Now().Unix(), State: state}},
+	})
+}
+
+// FindDeployedCandidate retrieves the candidate most recently deployed to the environment,
+// or the latest candidate marked as Deployed when no environment is given
+func (r *CandidateRepository) FindDeployedCandidate(name, environment string) (DeploymentCandidate, error) {
+	res := DeploymentCandidate{}
+	c := r.Session.DB(dbName).C(name)
+	if environment == "" {
+		err := c.Find(bson.M{"Deployed": true}).Sort("-Started").One(&res)
+		return res, err
+	}
+	state := "Environments." + environment
+	err := c.Find(bson.M{state + ".Deployed": true}).Sort("-" + state + ".DeployedAt").One(&res)
+	return res, err
+}
+
 // MarkCandidateAsSucceeded mark a candidate deployment as having succeeded
 func (r *CandidateRepository) MarkCandidateAsSucceeded(name, version string) error {
 	return r.CompleteStage(name, version, "Completed")
 }
 
-// GetCandidatesForE2E gets candidates that have passed unit testing and have a marathon spec
+// GetCandidatesForE2E gets candidates that have passed unit testing and have a spec
 func (r *CandidateRepository) GetCandidatesForE2E() ([]DeploymentCandidate, error) {
 	var candidates []DeploymentCandidate
 	servs, err := r.getTrackedServices()
@@ -115,13 +265,40 @@ func (r *CandidateRepository) accumulate(name string) ([]DeploymentCandidate, er
 	return found, err
 }
 
+// GetTrackedServices lists the names of the tracked services of the catalog
+func (r *CandidateRepository) GetTrackedServices() ([]string, error) {
+	servs, err := r.getTrackedServices()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, s := range servs {
+		names = append(names, s.Name)
+	}
+	return names, nil
+}
+
 func (r *CandidateRepository) getTrackedServices() ([]TrackedService, error) {
-	c := r.Session.DB(dbName).C(r.Catalog + "_trackedservices")
+	c := r.trackedServices()
 	var res []TrackedService
 	err := c.Find(bson.M{}).All(&res)
 	return res, err
 }
 
+func (r *CandidateRepository) trackedServices() *mgo.Collection {
+	return r.Session.DB(dbName).C(r.Catalog + "_trackedservices")
+}
+
+// FindTrackedService retrieves the tracked service with the given name
+func (r *CandidateRepository) FindTrackedService(name string) (TrackedService, error) {
+	res := TrackedService{}
+	err := r.trackedServices().Find(bson.M{"Name": name}).One(&res)
+	if err == mgo.ErrNotFound {
+		return res, ServiceNotTrackedError{Name: name}
+	}
+	return res, err
+}
+
 //Dispose closes the open session
 func (r *CandidateRepository) Dispose() error {
 	//todo should possibly surround with a recover
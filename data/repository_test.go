@@ -26,7 +26,7 @@ func (s *RepoSuite) SetUpSuite(c *C) {
 		sut.Session = session
 		c := session.DB(dbName).C("testy_trackedservices")
 		c.Insert(&TrackedService{Name: "cans"})
-		c.Insert(&TrackedService{Name: "bottles"})
+		c.Insert(&TrackedService{Name: "bottles", Deployer: "kubernetes"})
 	} else {
 		c.Fatal(err)
 	}
@@ -99,7 +99,7 @@ func (s *RepoSuite) TestCanRegisterNewCandidatec(c *C) {
 
 	c.Assert(cand.Started, Not(Equals), 0)
 	c.Assert(cand.ServiceName, Equals, "bottles")
-	c.Assert(cand.MarathonSpec, Equals, "")
+	c.Assert(cand.Spec, Equals, "")
 	c.Assert(cand.MarathonVersion, Equals, "")
 	c.Assert(cand.Image, Equals, "wolo")
 	c.Assert(cand.Version, Equals, "loo")
@@ -130,7 +130,20 @@ func (s *RepoSuite) TestCanAssignMarathonSpecToCandidate(c *C) {
 	c.Assert(err, IsNil)
 	cand, err2 := sut.FindCandidate("cans", "v1")
 	c.Assert(err2, IsNil)
-	c.Assert(cand.MarathonSpec, Equals, "spec")
+	c.Assert(cand.Spec, Equals, "spec")
+}
+
+func (s *RepoSuite) TestCanRecordRenderedSpec(c *C) {
+	coll1 := session.DB(dbName).C("cans")
+	ser1 := &DeploymentCandidate{Version: "v1", Spec: "{{.Image}}"}
+	c.Assert(coll1.Insert(ser1), IsNil)
+
+	err := sut.RecordRenderedSpec("cans", "v1", "group/image")
+	c.Assert(err, IsNil)
+	cand, err2 := sut.FindCandidate("cans", "v1")
+	c.Assert(err2, IsNil)
+	c.Assert(cand.Spec, Equals, "{{.Image}}")
+	c.Assert(cand.RenderedSpec, Equals, "group/image")
 }
 
 func (s *RepoSuite) TestFailsOnFindCandidateWhenNonePresent(c *C) {
@@ -141,9 +154,9 @@ func (s *RepoSuite) TestFailsOnFindCandidateWhenNonePresent(c *C) {
 func (s *RepoSuite) TestCanGetCandidatesForE2E(c *C) {
 	coll1 := session.DB(dbName).C("cans")
 	coll2 := session.DB(dbName).C("bottles")
-	ser1 := &DeploymentCandidate{Version: "v1", Unit: true, MarathonSpec: "p"}
+	ser1 := &DeploymentCandidate{Version: "v1", Unit: true, Spec: "p"}
 	ser2 := &DeploymentCandidate{Version: "v2", Unit: true}
-	ser3 := &DeploymentCandidate{Version: "v3", Unit: true, MarathonSpec: "pp"}
+	ser3 := &DeploymentCandidate{Version: "v3", Unit: true, Spec: "pp"}
 
 	c.Assert(coll1.Insert(ser1), IsNil)
 	c.Assert(coll1.Insert(ser2), IsNil)
@@ -160,3 +173,168 @@ func (s *RepoSuite) TestCanGetCandidatesForE2EEvenWhenNone(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(len(cands), Equals, 0)
 }
+
+func (s *RepoSuite) TestCanFindTrackedService(c *C) {
+	serv, err := sut.FindTrackedService("bottles")
+	c.Assert(err, IsNil)
+	c.Assert(serv.Name, Equals, "bottles")
+	c.Assert(serv.Deployer, Equals, "kubernetes")
+}
+
+func (s *RepoSuite) TestCanListTrackedServices(c *C) {
+	names, err := sut.GetTrackedServices()
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{"cans", "bottles"})
+}
+
+func (s *RepoSuite) TestFailsOnFindTrackedServiceWhenNotTracked(c *C) {
+	_, err := sut.FindTrackedService("jars")
+	c.Assert(err, Equals, ServiceNotTrackedError{Name: "jars"})
+}
+
+func (s *RepoSuite) TestCanMarkCandidateDeployedInEnvironment(c *C) {
+	coll1 := session.DB(dbName).C("cans")
+	c.Assert(coll1.Insert(&DeploymentCandidate{Version: "v1"}), IsNil)
+
+	err := sut.MarkDeployedIn("cans", "v1", "staging")
+	c.Assert(err, IsNil)
+
+	cand, err2 := sut.FindCandidate("cans", "v1")
+	c.Assert(err2, IsNil)
+	c.Assert(cand.Environments["staging"].Deployed, Equals, true)
+	c.Assert(cand.Environments["staging"].DeployedAt, Not(Equals), int64(0))
+	_, inProd := cand.Environments["production"]
+	c.Assert(inProd, Equals, false)
+}
+
+func (s *RepoSuite) TestCanRecordDeploymentIdsForEnvironment(c *C) {
+	coll1 := session.DB(dbName).C("cans")
+	c.Assert(coll1.Insert(&DeploymentCandidate{Version: "v1"}), IsNil)
+	c.Assert(sut.MarkDeployedIn("cans", "v1", "staging"), IsNil)
+
+	err := sut.RecordDeploymentIds("cans", "v1", "staging", []string{"d1", "d2"})
+	c.Assert(err, IsNil)
+
+	cand, err2 := sut.FindCandidate("cans", "v1")
+	c.Assert(err2, IsNil)
+	c.Assert(cand.DeploymentIds, DeepEquals, []string{"d1", "d2"})
+	c.Assert(cand.Environments["staging"].DeploymentIds, DeepEquals, []string{"d1", "d2"})
+	c.Assert(cand.Environments["staging"].Deployed, Equals, true)
+}
+
+func (s *RepoSuite) TestCanLogEventsAgainstCandidate(c *C) {
+	coll1 := session.DB(dbName).C("cans")
+	c.Assert(coll1.Insert(&DeploymentCandidate{Version: "v1"}), IsNil)
+
+	c.Assert(sut.LogCandidateEvent("cans", "v1", CandidateEvent{Action: "first"}), IsNil)
+	c.Assert(sut.LogCandidateEvent("cans", "v1", CandidateEvent{Action: "second", Environment: "staging"}), IsNil)
+
+	cand, err := sut.FindCandidate("cans", "v1")
+	c.Assert(err, IsNil)
+	c.Assert(len(cand.Events), Equals, 2)
+	c.Assert(cand.Events[0].Action, Equals, "first")
+	c.Assert(cand.Events[0].At, Not(Equals), int64(0))
+	c.Assert(cand.Events[1].Environment, Equals, "staging")
+}
+
+func (s *RepoSuite) TestCanRecordDeploymentsAndFindThemByAppVersion(c *C) {
+	coll1 := session.DB(dbName).C("cans")
+	c.Assert(coll1.Insert(&DeploymentCandidate{Version: "v1"}), IsNil)
+	c.Assert(coll1.Insert(&DeploymentCandidate{Version: "v2"}), IsNil)
+
+	failed := DeploymentRecord{AppID: "/cans", AppVersion: "2016-01-01T00:00:01.000Z", Outcome: DeploymentFailed}
+	c.Assert(sut.RecordDeployment("cans", "v1", failed), IsNil)
+	succeeded := DeploymentRecord{AppID: "/cans", AppVersion: "2016-01-01T00:00:02.000Z", Outcome: DeploymentSucceeded,
+		Environment: "staging", DeploymentIds: []string{"d1"}}
+	c.Assert(sut.RecordDeployment("cans", "v2", succeeded), IsNil)
+
+	cand, err := sut.FindCandidate("cans", "v2")
+	c.Assert(err, IsNil)
+	c.Assert(cand.MarathonVersion, Equals, "2016-01-01T00:00:02.000Z")
+	c.Assert(cand.Deployments, DeepEquals, []DeploymentRecord{succeeded})
+
+	cand, err = sut.FindCandidate("cans", "v1")
+	c.Assert(err, IsNil)
+	c.Assert(cand.MarathonVersion, Equals, "")
+
+	found, err := sut.FindCandidateByAppVersion("cans", "2016-01-01T00:00:02.000Z")
+	c.Assert(err, IsNil)
+	c.Assert(found.Version, Equals, "v2")
+
+	_, err = sut.FindCandidateByAppVersion("cans", "unknown")
+	c.Assert(err, NotNil)
+}
+
+func (s *RepoSuite) TestCanFindCandidateDeployedInEnvironment(c *C) {
+	coll1 := session.DB(dbName).C("cans")
+	c.Assert(coll1.Insert(&DeploymentCandidate{Version: "v1", Environments: map[string]EnvironmentState{
+		"staging": {Deployed: true, DeployedAt: 10}, "production": {Deployed: true, DeployedAt: 10}}}), IsNil)
+	c.Assert(coll1.Insert(&DeploymentCandidate{Version: "v2", Environments: map[string]EnvironmentState{
+		"staging": {Deployed: true, DeployedAt: 20}}}), IsNil)
+
+	staging, err := sut.FindDeployedCandidate("cans", "staging")
+	c.Assert(err, IsNil)
+	c.Assert(staging.Version, Equals, "v2")
+
+	production, err := sut.FindDeployedCandidate("cans", "production")
+	c.Assert(err, IsNil)
+	c.Assert(production.Version, Equals, "v1")
+
+	_, err = sut.FindDeployedCandidate("cans", "dev")
+	c.Assert(err, NotNil)
+}
+
+func (s *RepoSuite) TestCanFindLatestDeployedCandidate(c *C) {
+	coll1 := session.DB(dbName).C("cans")
+	c.Assert(coll1.Insert(&DeploymentCandidate{Version: "v1", Started: 1, Deployed: true}), IsNil)
+	c.Assert(coll1.Insert(&DeploymentCandidate{Version: "v2", Started: 2, Deployed: true}), IsNil)
+	c.Assert(coll1.Insert(&DeploymentCandidate{Version: "v3", Started: 3}), IsNil)
+
+	cand, err := sut.FindDeployedCandidate("cans", "")
+	c.Assert(err, IsNil)
+	c.Assert(cand.Version, Equals, "v2")
+}
+
+func (s *RepoSuite) TestCanStoreSnapshotsAndFollowTheirLifecycle(c *C) {
+	defer session.DB(dbName).C("testy_snapshots").DropCollection()
+	c.Assert(sut.SaveSnapshot(StoredSnapshot{ID: "abcdef0123", Content: "{}"}), IsNil)
+	c.Assert(sut.SaveSnapshot(StoredSnapshot{ID: "abcdef9876", Content: "{}"}), IsNil)
+
+	snapshot, err := sut.FindSnapshot("abcdef01")
+	c.Assert(err, IsNil)
+	c.Assert(snapshot.ID, Equals, "abcdef0123")
+	c.Assert(snapshot.State, Equals, SnapshotComposed)
+	_, err = sut.FindSnapshot("abcdef")
+	c.Assert(err, ErrorMatches, "snapshot id abcdef is ambiguous")
+	_, err = sut.FindSnapshot("0000")
+	c.Assert(err, ErrorMatches, "snapshot 0000 not found")
+
+	c.Assert(sut.ChangeSnapshotState("abcdef0123", SnapshotTesting), IsNil)
+	c.Assert(sut.ChangeSnapshotState("abcdef0123", SnapshotAccepted), IsNil)
+	c.Assert(sut.ChangeSnapshotState("abcdef0123", SnapshotTesting), ErrorMatches, "snapshot abcdef0123 is accepted and cannot become testing")
+	c.Assert(sut.ChangeSnapshotState("abcdef0123", SnapshotDeployed), IsNil)
+	c.Assert(sut.ChangeSnapshotState("abcdef0123", SnapshotRejected), NotNil)
+
+	snapshot, err = sut.FindSnapshot("abcdef0123")
+	c.Assert(err, IsNil)
+	c.Assert(snapshot.State, Equals, SnapshotDeployed)
+	c.Assert(len(snapshot.History), Equals, 4)
+
+	c.Assert(sut.SaveSnapshot(StoredSnapshot{ID: "abcdef0123", Content: "{}"}), IsNil)
+	c.Assert(sut.SaveSnapshot(StoredSnapshot{ID: "abcdef0123", Content: "{\"changed\": true}"}), ErrorMatches,
+		"snapshot abcdef0123 is deployed and cannot be composed again with a different content")
+	snapshot, err = sut.FindSnapshot("abcdef0123")
+	c.Assert(err, IsNil)
+	c.Assert(snapshot.State, Equals, SnapshotDeployed)
+	c.Assert(snapshot.Content, Equals, "{}")
+	c.Assert(len(snapshot.History), Equals, 4)
+
+	c.Assert(sut.SaveSnapshot(StoredSnapshot{ID: "abcdef9876", Content: "{\"again\": true}"}), IsNil)
+	snapshot, err = sut.FindSnapshot("abcdef9876")
+	c.Assert(err, IsNil)
+	c.Assert(snapshot.Content, Equals, "{\"again\": true}")
+
+	c.Assert(sut.ChangeSnapshotState("abcdef9876", SnapshotRejected), IsNil)
+	c.Assert(sut.SaveSnapshot(StoredSnapshot{ID: "abcdef9876", Content: "{\"again\": true}"}), ErrorMatches,
+		"snapshot abcdef9876 was rejected and cannot be composed again")
+}
@@ -0,0 +1,443 @@
+package deployer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	marathon "github.com/gambol99/go-marathon"
+)
+
+// DockerDeployer runs marathon apps as containers on a single docker engine
+type DockerDeployer struct {
+	URL          string
+	Timeout      time.Duration
+	PollInterval time.Duration
+	Client       *http.Client
+}
+
+type dockerPortBinding struct {
+	HostPort string
+}
+
+type dockerHostConfig struct {
+	Binds        []string                       `json:",omitempty"`
+	PortBindings map[string][]dockerPortBinding `json:",omitempty"`
+	Privileged   bool                           `json:",omitempty"`
+}
+
+type dockerHealthcheck struct {
+	Test     []string
+	Interval time.Duration `json:",omitempty"`
+	Timeout  time.Duration `json:",omitempty"`
+	Retries  int           `json:",omitempty"`
+}
+
+type dockerContainerConfig struct {
+	Image        string
+	Cmd          []string            `json:",omitempty"`
+	Env          []string            `json:",omitempty"`
+	Labels       map[string]string   `json:",omitempty"`
+	ExposedPorts map[string]struct{} `json:",omitempty"`
+	Healthcheck  *dockerHealthcheck  `json:",omitempty"`
+	HostConfig   dockerHostConfig
+}
+
+type dockerContainer struct {
+	ID    string `json:"Id"`
+	State struct {
+		Status   string
+		Running  bool
+		ExitCode int
+		Health   *struct {
+			Status string
+		}
+	}
+}
+
+// NewDockerDeployer initializes a deployer targeting a docker engine, e.g. unix:///var/run/docker.sock
+func NewDockerDeployer(host string) IDeployer {
+	dep := &DockerDeployer{
+		URL:          strings.TrimSuffix(strings.Replace(host, "tcp://", "http://", 1), "/"),
+		Timeout:      5 * time.Minute,
+		PollInterval: time.Second,
+		Client:       http.DefaultClient,
+	}
+	if strings.HasPrefix(host, "unix://") {
+		socket := strings.TrimPrefix(host, "unix://")
+		dep.URL = "http://docker"
+		dep.Client = &http.Client{
+			Transport: &http.Transport{
+				Dial: func(_, _ string) (net.Conn, error) {
+					return net.Dial("unix", socket)
+				},
+			},
+		}
+	}
+	return dep
+}
+
+// previousSuffix names the container of the previous deployment while the new one starts
+const previousSuffix = "-previous"
+
+var volumeName = regexp.MustCompile("^[a-zA-Z0-9][a-zA-Z0-9_.-]*$")
+
+func containerName(appID string) string {
+	return strings.Replace(strings.Trim(appID, "/"), "/", "_", -1)
+}
+
+func toContainerConfig(app *marathon.Application) (*dockerContainerConfig, error) {
+	if app.Container == nil || app.Container.Docker == nil || app.Container.Docker.Image == "" {
+		return nil, errors.New(app.ID + " does not define a docker image")
+	}
+	docker := app.Container.Docker
+	config := &dockerContainerConfig{
+		Image:  docker.Image,
+		Labels: map[string]string{"dpipeliner.app": app.ID},
+	}
+	config.HostConfig.Privileged = docker.Privileged
+
+	if len(app.Args) > 0 {
+		config.Cmd = app.Args
+	} else if app.Cmd != "" {
+		config.Cmd = []string{"/bin/sh", "-c", app.Cmd}
+	}
+
+	for k, v := range app.Env {
+		config.Env = append(config.Env, k+"="+v)
+	}
+	sort.Strings(config.Env)
+
+	for k, v := range app.Labels {
+		config.Labels[k] = v
+	}
+
+	for _, mapping := range docker.PortMappings {
+		protocol := mapping.Protocol
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		port := strconv.Itoa(mapping.ContainerPort) + "/" + protocol
+		if config.ExposedPorts == nil {
+			config.ExposedPorts = make(map[string]struct{})
+			config.HostConfig.PortBindings = make(map[string][]dockerPortBinding)
+		}
+		config.ExposedPorts[port] = struct{}{}
+		binding := dockerPortBinding{}
+		if mapping.HostPort != 0 {
+			binding.HostPort = strconv.Itoa(mapping.HostPort)
+		}
+		config.HostConfig.PortBindings[port] = append(config.HostConfig.PortBindings[port], binding)
+	}
+
+	for _, volume := range app.Container.Volumes {
+		bind, err := toBind(app.ID, volume.HostPath, volume.ContainerPath, volume.Mode)
+		if err != nil {
+			return nil, err
+		}
+		if bind != "" {
+			config.HostConfig.Binds = append(config.HostConfig.Binds, bind)
+		}
+	}
+
+	for _, check := range app.HealthChecks {
+		if check.Protocol == "COMMAND" && check.Command != nil {
+			config.Healthcheck = &dockerHealthcheck{
+				Test:     []string{"CMD-SHELL", check.Command.Value},
+				Interval: time.Duration(check.IntervalSeconds) * time.Second,
+				Timeout:  time.Duration(check.TimeoutSeconds) * time.Second,
+				Retries:  check.MaxConsecutiveFailures,
+			}
+			break
+		}
+	}
+	return config, nil
+}
+
+// toBind turns a marathon volume into a docker bind, empty for the persistent and external volumes that only
+// marathon manages. A relative host path names a persistent volume of the app, kept as a named volume.
+func toBind(appID, hostPath, containerPath, mode string) (string, error) {
+	if hostPath == "" {
+		return "", nil
+	}
+	if !path.IsAbs(containerPath) {
+		return "", errors.New(appID + " mounts " + hostPath + " at relative path " + containerPath)
+	}
+	source := hostPath
+	if !path.IsAbs(source) {
+		source = containerName(appID) + "-" + source
+		if !volumeName.MatchString(source) {
+			return "", errors.New(appID + " mounts " + hostPath + " which is neither an absolute path nor a volume name")
+		}
+	}
+	mode = strings.ToLower(mode)
+	if mode == "" {
+		mode = "rw"
+	}
+	return source + ":" + containerPath + ":" + mode, nil
+}
+
+func (dep *DockerDeployer) do(method, path string, body interface{}, res interface{}) (int, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return 0, err
+		}
+	}
+	req, err := http.NewRequest(method, dep.URL+path, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := dep.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode == http.StatusNotFound {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotModified {
+		return resp.StatusCode, fmt.Errorf("docker %s %s failed (status %d): %s", method, path, resp.StatusCode, content)
+	}
+	if res != nil && len(content) > 0 {
+		err = json.Unmarshal(content, res)
+	}
+	return resp.StatusCode, err
+}
+
+func (dep *DockerDeployer) inspect(name string) (*dockerContainer, error) {
+	container := new(dockerContainer)
+	status, err := dep.do("GET", "/containers/"+name+"/json", nil, container)
+	if err != nil || status == http.StatusNotFound {
+		return nil, err
+	}
+	return container, nil
+}
+
+func (dep *DockerDeployer) create(name string, config *dockerContainerConfig) (string, error) {
+	created := new(dockerContainer)
+	path := "/containers/create?name=" + url.QueryEscape(name)
+	status, err := dep.do("POST", path, config, created)
+	if err != nil {
+		return "", err
+	}
+	if status == http.StatusNotFound {
+		if _, err := dep.do("POST", "/images/create?fromImage="+url.QueryEscape(config.Image), nil, nil); err != nil {
+			return "", err
+		}
+		if status, err = dep.do("POST", path, config, created); err != nil {
+			return "", err
+		}
+		if status == http.StatusNotFound {
+			return "", errors.New("image " + config.Image + " could not be pulled")
+		}
+	}
+	return created.ID, nil
+}
+
+func (dep *DockerDeployer) waitForHealth(name string) (string, error) {
+	deadline := time.Now().Add(dep.Timeout)
+	for {
+		container, err := dep.inspect(name)
+		if err != nil {
+			return "", err
+		}
+		if container == nil {
+			return "", errors.New("container " + name + " disappeared")
+		}
+		if !container.State.Running {
+			return "", fmt.Errorf("container %s is %s (exit code %d)", name, container.State.Status, container.State.ExitCode)
+		}
+		if container.State.Health == nil {
+			return container.State.Status, nil
+		}
+		switch container.State.Health.Status {
+		case "healthy":
+			return "healthy", nil
+		case "unhealthy":
+			return "", errors.New("container " + name + " is unhealthy")
+		}
+		if time.Now().After(deadline) {
+			return "", errors.New("timed out waiting for container " + name + " to become healthy")
+		}
+		time.Sleep(dep.PollInterval)
+	}
+}
+
+// start creates the container and starts it, returning its id once it is healthy
+func (dep *DockerDeployer) start(name string, config *dockerContainerConfig) (string, error) {
+	id, err := dep.create(name, config)
+	if err != nil {
+		return "", err
+	}
+	if _, err := dep.do("POST", "/containers/"+id+"/start", nil, nil); err != nil {
+		return "", err
+	}
+	health, err := dep.waitForHealth(name)
+	if err != nil {
+		return "", err
+	}
+	fmt.Println("Container " + name + " is " + health)
+	return id, nil
+}
+
+// restore removes the container that failed to replace the previous one and brings the latter back
+func (dep *DockerDeployer) restore(previous *dockerContainer, name string, failure error) error {
+	steps := [][2]string{
+		{"DELETE", "/containers/" + name + "?force=1"},
+		{"POST", "/containers/" + previous.ID + "/rename?name=" + url.QueryEscape(name)},
+	}
+	if previous.State.Running {
+		steps = append(steps, [2]string{"POST", "/containers/" + previous.ID + "/start"})
+	}
+	for _, step := range steps {
+		if _, err := dep.do(step[0], step[1], nil, nil); err != nil {
+			return fmt.Errorf("%v, and restoring the previous container failed: %v", failure, err)
+		}
+	}
+	return fmt.Errorf("%v, the previous container was restored", failure)
+}
+
+// recoverAside brings back the container that an interrupted deployment set aside, if any
+func (dep *DockerDeployer) recoverAside(name string) error {
+	aside, err := dep.inspect(name + previousSuffix)
+	if err != nil || aside == nil {
+		return err
+	}
+	if _, err := dep.do("POST", "/containers/"+aside.ID+"/rename?name="+url.QueryEscape(name), nil, nil); err != nil {
+		return fmt.Errorf("cannot restore the container of %s set aside by an interrupted deployment: %v", name, err)
+	}
+	_, err = dep.do("POST", "/containers/"+aside.ID+"/start", nil, nil)
+	return err
+}
+
+// Deploy replaces the app's container with one built from the marathon spec and waits for it to be healthy.
+// The previous container is stopped and set aside until then, and restored when the new one fails.
+func (dep *DockerDeployer) Deploy(jsonContent []byte) (*ExpectedDeployment, error) {
+	app, err := parseContent(jsonContent)
+	if err != nil {
+		return nil, err
+	}
+	config, err := toContainerConfig(app)
+	if err != nil {
+		return nil, err
+	}
+	name := containerName(app.ID)
+
+	previous, err := dep.inspect(name)
+	if err != nil {
+		return nil, err
+	}
+	if previous == nil {
+		if err := dep.recoverAside(name); err != nil {
+			return nil, err
+		}
+		if previous, err = dep.inspect(name); err != nil {
+			return nil, err
+		}
+	} else if _, err := dep.do("DELETE", "/containers/"+name+previousSuffix+"?force=1", nil, nil); err != nil {
+		// left over by an interrupted deployment that brought its container up
+		return nil, err
+	}
+	if previous != nil {
+		if _, err := dep.do("POST", "/containers/"+previous.ID+"/rename?name="+url.QueryEscape(name+previousSuffix), nil, nil); err != nil {
+			return nil, err
+		}
+		if _, err := dep.do("POST", "/containers/"+previous.ID+"/stop", nil, nil); err != nil {
+			return nil, dep.restore(previous, name, err)
+		}
+	}
+
+	id, err := dep.start(name, config)
+	if err != nil && previous != nil {
+		return nil, dep.restore(previous, name, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		if _, err := dep.do("DELETE", "/containers/"+previous.ID+"?force=1", nil, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return &ExpectedDeployment{
+		AppId:         app.ID,
+		NewDeployment: previous == nil,
+		DeploymentIds: []string{id},
+	}, nil
+}
+
+// existing returns the name of the app's container, failing when it was never deployed
+func (dep *DockerDeployer) existing(jsonContent []byte) (*marathon.Application, string, error) {
+	app, err := parseContent(jsonContent)
+	if err != nil {
+		return nil, "", err
+	}
+	name := containerName(app.ID)
+	container, err := dep.inspect(name)
+	if err != nil {
+		return nil, "", err
+	}
+	if container == nil {
+		return nil, "", errors.New("container " + name + " does not exist")
+	}
+	return app, container.ID, nil
+}
+
+// Scale starts (1) or stops (0) the app's container, a single engine cannot run more instances
+func (dep *DockerDeployer) Scale(jsonContent []byte, instances int) (*ExpectedDeployment, error) {
+	if instances > 1 || instances < 0 {
+		return nil, fmt.Errorf("the docker deployer can only scale to 0 or 1 instance, not %d", instances)
+	}
+	app, id, err := dep.existing(jsonContent)
+	if err != nil {
+		return nil, err
+	}
+	expected := &ExpectedDeployment{AppId: app.ID, DeploymentIds: []string{id}}
+	if instances == 0 {
+		_, err := dep.do("POST", "/containers/"+id+"/stop", nil, nil)
+		return expected, err
+	}
+	if _, err := dep.do("POST", "/containers/"+id+"/start", nil, nil); err != nil {
+		return nil, err
+	}
+	if _, err := dep.waitForHealth(containerName(app.ID)); err != nil {
+		return nil, err
+	}
+	return expected, nil
+}
+
+// Restart restarts the app's container and waits for it to be healthy
+func (dep *DockerDeployer) Restart(jsonContent []byte) (*ExpectedDeployment, error) {
+	app, id, err := dep.existing(jsonContent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dep.do("POST", "/containers/"+id+"/restart", nil, nil); err != nil {
+		return nil, err
+	}
+	if _, err := dep.waitForHealth(containerName(app.ID)); err != nil {
+		return nil, err
+	}
+	return &ExpectedDeployment{AppId: app.ID, DeploymentIds: []string{id}}, nil
+}
+
+// Suspend stops the app's container
+func (dep *DockerDeployer) Suspend(jsonContent []byte) (*ExpectedDeployment, error) {
+	return dep.Scale(jsonContent, 0)
+}
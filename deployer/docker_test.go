@@ -0,0 +1,224 @@
+package deployer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDockerAPI serves the previous container as "old" and the one it creates as "abc"
+type fakeDockerAPI struct {
+	existing  bool
+	renamed   bool
+	deleted   bool
+	pulled    bool
+	started   string
+	health    string
+	created   *dockerContainerConfig
+	missing   bool
+	failStart bool
+	actions   []string
+}
+
+func (f *fakeDockerAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method + " " + r.URL.Path {
+	case "GET /containers/elApp/json":
+		switch {
+		case f.created != nil:
+			w.Write([]byte(`{"Id": "abc", "State": {"Status": "running", "Running": true, "Health": {"Status": "` + f.health + `"}}}`))
+		case f.existing && !f.renamed:
+			w.Write([]byte(`{"Id": "old", "State": {"Status": "running", "Running": true, "Health": {"Status": "healthy"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case "GET /containers/elApp-previous/json":
+		if !f.existing || !f.renamed {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"Id": "old", "State": {"Status": "exited", "Running": false}}`))
+	case "DELETE /containers/elApp":
+		if f.created == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f.created = nil
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE /containers/elApp-previous":
+		if f.existing && f.renamed {
+			f.deleted = true
+			f.existing = false
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE /containers/old":
+		f.deleted = true
+		f.existing = false
+		w.WriteHeader(http.StatusNoContent)
+	case "POST /containers/old/rename":
+		f.renamed = r.URL.Query().Get("name") != "elApp"
+		f.actions = append(f.actions, r.URL.Path+"?name="+r.URL.Query().Get("name"))
+		w.WriteHeader(http.StatusNoContent)
+	case "POST /images/create":
+		f.pulled = true
+		f.missing = false
+	case "POST /containers/create":
+		if f.missing {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f.created = &dockerContainerConfig{}
+		json.NewDecoder(r.Body).Decode(f.created)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"Id": "abc"}`))
+	case "POST /containers/abc/start":
+		if f.failStart {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		f.started = "abc"
+		w.WriteHeader(http.StatusNoContent)
+	case "POST /containers/old/start", "POST /containers/old/stop", "POST /containers/old/restart":
+		f.actions = append(f.actions, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func newTestDockerDeployer(url string) *DockerDeployer {
+	dep := NewDockerDeployer(url).(*DockerDeployer)
+	dep.PollInterval = time.Millisecond
+	dep.Timeout = time.Second
+	return dep
+}
+
+func TestCanTranslateMarathonSpecToContainerConfig(t *testing.T) {
+	app, _ := parseContent([]byte(jsonContent))
+	config, err := toContainerConfig(app)
+	assert.Nil(t, err, "should not throw")
+
+	assert.Equal(t, "group/image", config.Image)
+	assert.Equal(t, []string{"/bin/sh", "-c", "env && sleep 300"}, config.Cmd)
+	assert.Equal(t, []string{"LD_LIBRARY_PATH=/usr/local/lib/myLib"}, config.Env)
+	assert.Equal(t, "staging", config.Labels["environment"])
+	assert.Equal(t, "elApp", config.Labels["dpipeliner.app"])
+	assert.Contains(t, config.ExposedPorts, "8080/tcp")
+	assert.Contains(t, config.ExposedPorts, "161/udp")
+	assert.Equal(t, []string{"/var/data/a:/etc/a:ro", "/var/data/b:/etc/b:rw"}, config.HostConfig.Binds)
+	assert.Equal(t, []string{"CMD-SHELL", "curl -f -X GET http://$HOST:$PORT0/health"}, config.Healthcheck.Test)
+}
+
+func TestCanTranslatePersistentVolumesToNamedVolumes(t *testing.T) {
+	app, _ := parseContent([]byte(`{"id": "/shop/db", "container": {"docker": {"image": "postgres"}, "volumes": [
+		{"containerPath": "pgdata", "mode": "RW", "persistent": {"size": 100}},
+		{"containerPath": "/var/lib/postgresql/data", "hostPath": "pgdata"}]}}`))
+	config, err := toContainerConfig(app)
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, []string{"shop_db-pgdata:/var/lib/postgresql/data:rw"}, config.HostConfig.Binds)
+}
+
+func TestCannotTranslateVolumeMountedAtRelativePath(t *testing.T) {
+	app, _ := parseContent([]byte(`{"id": "nope", "container": {"docker": {"image": "postgres"}, "volumes": [
+		{"containerPath": "data", "hostPath": "/var/data"}]}}`))
+	_, err := toContainerConfig(app)
+	assert.NotNil(t, err, "should fail")
+}
+
+func TestCannotTranslateMarathonSpecWithoutImage(t *testing.T) {
+	app, _ := parseContent([]byte(`{"id": "nope", "cmd": "sleep 1"}`))
+	_, err := toContainerConfig(app)
+	assert.NotNil(t, err, "should fail")
+}
+
+func TestCanReplaceContainerOnDockerEngine(t *testing.T) {
+	api := &fakeDockerAPI{existing: true, health: "healthy"}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	expected, err := newTestDockerDeployer(server.URL).Deploy([]byte(jsonContent))
+	assert.Nil(t, err, "should not throw")
+	assert.True(t, api.deleted, "should remove the previous container")
+	assert.Equal(t, []string{"/containers/old/rename?name=elApp-previous", "/containers/old/stop"}, api.actions)
+	assert.Equal(t, "abc", api.started)
+	assert.Equal(t, "group/image", api.created.Image)
+	assert.False(t, expected.NewDeployment, "should replace the container")
+	assert.Equal(t, []string{"abc"}, expected.DeploymentIds)
+}
+
+func TestDockerRestoresPreviousContainerWhenNewOneFails(t *testing.T) {
+	api := &fakeDockerAPI{existing: true, failStart: true}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	_, err := newTestDockerDeployer(server.URL).Deploy([]byte(jsonContent))
+	assert.NotNil(t, err, "should fail")
+	assert.False(t, api.deleted, "should keep the previous container")
+	assert.Nil(t, api.created, "should remove the new container")
+	assert.False(t, api.renamed, "should give the previous container its name back")
+	assert.Equal(t, []string{"/containers/old/rename?name=elApp-previous", "/containers/old/stop",
+		"/containers/old/rename?name=elApp", "/containers/old/start"}, api.actions)
+}
+
+func TestDockerRecoversContainerSetAsideByInterruptedDeployment(t *testing.T) {
+	api := &fakeDockerAPI{existing: true, renamed: true, failStart: true}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	_, err := newTestDockerDeployer(server.URL).Deploy([]byte(jsonContent))
+	assert.NotNil(t, err, "should fail")
+	assert.False(t, api.deleted, "should keep the container set aside")
+	assert.False(t, api.renamed, "should give the container set aside its name back")
+	assert.Equal(t, "/containers/old/rename?name=elApp", api.actions[0])
+	assert.Equal(t, "/containers/old/start", api.actions[len(api.actions)-1])
+}
+
+func TestCanPullMissingImageOnDockerEngine(t *testing.T) {
+	api := &fakeDockerAPI{missing: true, health: "healthy"}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	expected, err := newTestDockerDeployer(server.URL).Deploy([]byte(jsonContent))
+	assert.Nil(t, err, "should not throw")
+	assert.True(t, api.pulled, "should pull the image")
+	assert.True(t, expected.NewDeployment, "should be a new container")
+}
+
+func TestDockerDeployFailsWhenContainerIsUnhealthy(t *testing.T) {
+	api := &fakeDockerAPI{health: "unhealthy"}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	_, err := newTestDockerDeployer(server.URL).Deploy([]byte(jsonContent))
+	assert.NotNil(t, err, "should fail")
+}
+
+func TestCanRestartAndSuspendDockerContainer(t *testing.T) {
+	api := &fakeDockerAPI{existing: true, health: "healthy"}
+	server := httptest.NewServer(api)
+	defer server.Close()
+	dep := newTestDockerDeployer(server.URL)
+
+	restarted, err := dep.Restart([]byte(jsonContent))
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, []string{"old"}, restarted.DeploymentIds)
+	_, err = dep.Suspend([]byte(jsonContent))
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, []string{"/containers/old/restart", "/containers/old/stop"}, api.actions)
+}
+
+func TestDockerCannotScaleBeyondOneInstance(t *testing.T) {
+	_, err := newTestDockerDeployer("http://localhost").Scale([]byte(jsonContent), 3)
+	assert.NotNil(t, err, "should fail")
+}
+
+func TestDockerCannotRestartMissingContainer(t *testing.T) {
+	server := httptest.NewServer(&fakeDockerAPI{})
+	defer server.Close()
+
+	_, err := newTestDockerDeployer(server.URL).Restart([]byte(jsonContent))
+	assert.NotNil(t, err, "should fail")
+}
@@ -0,0 +1,151 @@
+package deployer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bhameyie/dpipeliner/deployer/marathontest"
+	"github.com/stretchr/testify/assert"
+)
+
+const e2eSpec = `{"id": "/web", "instances": 2, "healthChecks": [{"protocol": "HTTP", "path": "/health"}]}`
+
+func newTestMarathonDeployer(server *marathontest.Server) *MarathonDeployer {
+	dep := NewDeployer(server.URL).(*MarathonDeployer)
+	dep.LogOutput = nil
+	dep.Timeout = 200 * time.Millisecond
+	dep.PollInterval = time.Millisecond
+	return dep
+}
+
+func TestDeploysAgainstMarathonEndToEnd(t *testing.T) {
+	server := marathontest.NewServer()
+	defer server.Close()
+	dep := newTestMarathonDeployer(server)
+
+	created, err := dep.Deploy([]byte(e2eSpec))
+	assert.Nil(t, err, "should not throw")
+	assert.True(t, created.NewDeployment, "should create the app")
+	assert.Nil(t, dep.WaitForHealthyTasks([]byte(e2eSpec), 0), "should be healthy")
+
+	updated, err := dep.Deploy([]byte(`{"id": "/web", "instances": 3}`))
+	assert.Nil(t, err, "should not throw")
+	assert.False(t, updated.NewDeployment, "should update the app")
+	assert.Equal(t, 1, len(updated.DeploymentIds))
+	app, _ := server.App("/web")
+	assert.Equal(t, float64(3), app["instances"])
+}
+
+func TestScalesRestartsAndSuspendsEndToEnd(t *testing.T) {
+	server := marathontest.NewServer()
+	defer server.Close()
+	assert.Nil(t, server.AddApp(e2eSpec))
+	dep := newTestMarathonDeployer(server)
+
+	_, err := dep.Scale([]byte(e2eSpec), 4)
+	assert.Nil(t, err, "should scale")
+	_, err = dep.Restart([]byte(e2eSpec))
+	assert.Nil(t, err, "should restart")
+	_, err = dep.Suspend([]byte(e2eSpec))
+	assert.Nil(t, err, "should suspend")
+
+	app, _ := server.App("/web")
+	assert.Equal(t, float64(0), app["instances"])
+	assert.Contains(t, server.Requests(), "POST /v2/apps/web/restart")
+}
+
+func TestDetectsUnhealthyDeploymentAndRollsBack(t *testing.T) {
+	server := marathontest.NewServer()
+	defer server.Close()
+	assert.Nil(t, server.AddApp(e2eSpec))
+	server.Script("/web", marathontest.Fail, 0)
+	dep := newTestMarathonDeployer(server)
+
+	_, err := dep.Deploy([]byte(`{"id": "/web", "instances": 5}`))
+	assert.Nil(t, err, "should submit the deployment")
+	assert.NotNil(t, dep.WaitForHealthyTasks([]byte(e2eSpec), 0), "should not become healthy")
+
+	server.Script("/web", marathontest.Succeed, 0)
+	_, err = dep.Rollback([]byte(e2eSpec))
+	assert.Nil(t, err, "should roll back")
+	app, _ := server.App("/web")
+	assert.Equal(t, float64(2), app["instances"])
+}
+
+func TestTimesOutOnHangingDeployment(t *testing.T) {
+	server := marathontest.NewServer()
+	defer server.Close()
+	server.Script("/web-canary", marathontest.Hang, 0)
+	dep := newTestMarathonDeployer(server)
+
+	_, err := dep.Scale([]byte(e2eSpec), 1)
+	assert.NotNil(t, err, "should not find an app to scale")
+
+	_, err = dep.DeployCanary([]byte(e2eSpec), 1)
+	assert.NotNil(t, err, "should time out")
+}
+
+func TestForcesPastAndCancelsStuckDeployments(t *testing.T) {
+	server := marathontest.NewServer()
+	defer server.Close()
+	assert.Nil(t, server.AddApp(e2eSpec))
+	server.Script("/web", marathontest.Hang, 0)
+	dep := newTestMarathonDeployer(server)
+
+	_, err := dep.Deploy([]byte(`{"id": "/web", "instances": 3}`))
+	assert.Nil(t, err, "should submit the deployment")
+	_, err = dep.Deploy([]byte(`{"id": "/web", "instances": 4}`))
+	assert.NotNil(t, err, "should be locked by the stuck deployment")
+
+	cancelled, err := dep.CancelDeployments([]byte(e2eSpec), true)
+	assert.Nil(t, err, "should cancel")
+	assert.Equal(t, 1, len(cancelled.Cancelled))
+	assert.Equal(t, 1, len(cancelled.Rollbacks))
+	app, _ := server.App("/web")
+	assert.Equal(t, float64(2), app["instances"])
+
+	_, err = dep.Deploy([]byte(`{"id": "/web", "instances": 3}`))
+	assert.Nil(t, err, "should submit the deployment")
+	dep.Force = true
+	_, err = dep.Deploy([]byte(`{"id": "/web", "instances": 4}`))
+	assert.Nil(t, err, "should force past the lock")
+}
+
+func TestDeploysBlueGreenEndToEnd(t *testing.T) {
+	server := marathontest.NewServer()
+	defer server.Close()
+	assert.Nil(t, server.AddApp(`{"id": "/web-blue", "instances": 2}`))
+	dep := newTestMarathonDeployer(server)
+
+	deployed, err := dep.DeployBlueGreen([]byte(e2eSpec))
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, "/web-green", deployed.AppId)
+	blue, _ := server.App("/web-blue")
+	assert.Equal(t, float64(0), blue["instances"])
+
+	_, err = dep.Promote(BlueGreenStrategy, []byte(e2eSpec))
+	assert.Nil(t, err, "should promote")
+	_, exists := server.App("/web-blue")
+	assert.False(t, exists, "should remove the previous colour")
+}
+
+func TestDeploysAndRollsBackGroupEndToEnd(t *testing.T) {
+	server := marathontest.NewServer()
+	defer server.Close()
+	dep := newTestMarathonDeployer(server)
+
+	deployed, err := dep.DeployGroup("shop", [][]byte{[]byte(`{"id": "web"}`), []byte(`{"id": "api"}`)})
+	assert.Nil(t, err, "should not throw")
+	assert.True(t, deployed.NewDeployment, "should create the group")
+	_, err = dep.DeployGroup("shop", [][]byte{[]byte(`{"id": "/shop/web", "instances": 3}`)})
+	assert.Nil(t, err, "should update the group")
+	_, exists := server.App("/shop/api")
+	assert.True(t, exists, "should keep apps left out")
+	web, _ := server.App("/shop/web")
+	assert.Equal(t, float64(3), web["instances"])
+
+	_, err = dep.RollbackGroup("shop")
+	assert.Nil(t, err, "should roll back")
+	web, _ = server.App("/shop/web")
+	assert.NotEqual(t, float64(3), web["instances"], "should restore the previous group")
+}
@@ -0,0 +1,141 @@
+package deployer
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	marathon "github.com/gambol99/go-marathon"
+)
+
+// IGroupDeployer deploys several applications as a single marathon group deployment
+type IGroupDeployer interface {
+	DeployGroup(groupID string, specs [][]byte) (*ExpectedDeployment, error)
+	RollbackGroup(groupID string) (*ExpectedDeployment, error)
+}
+
+type marathonGroup struct {
+	ID      string                  `json:"id,omitempty"`
+	Apps    []*marathon.Application `json:"apps,omitempty"`
+	Version string                  `json:"version,omitempty"`
+}
+
+type groupUpdate struct {
+	Version      string `json:"version"`
+	DeploymentID string `json:"deploymentId"`
+}
+
+func normalizeGroupID(groupID string) string {
+	return "/" + strings.Trim(groupID, "/")
+}
+
+// groupMember resolves the app id declared by a spec within the group. Relative ids are relative to the
+// group, as marathon resolves them, and absolute ids must already belong to the group. The apps of a group
+// update are its direct children, nested ones would belong to its subgroups.
+func groupMember(groupID, id string) (string, error) {
+	prefix := strings.TrimSuffix(groupID, "/") + "/"
+	if !strings.HasPrefix(id, "/") {
+		id = prefix + strings.Trim(id, "/")
+	}
+	if !strings.HasPrefix(id, prefix) {
+		return "", errors.New("app " + id + " is not part of group " + groupID)
+	}
+	if strings.Contains(strings.TrimSuffix(id[len(prefix):], "/"), "/") {
+		return "", errors.New("app " + id + " belongs to a subgroup of " + groupID + ", only its direct children can be deployed with it")
+	}
+	return strings.TrimSuffix(id, "/"), nil
+}
+
+// mergeApps replaces the apps of the group by the deployed ones sharing their id and adds the others,
+// so that the group update leaves the apps missing from the specs untouched
+func mergeApps(current, deployed []*marathon.Application) []*marathon.Application {
+	byID := make(map[string]*marathon.Application)
+	for _, app := range deployed {
+		byID[app.ID] = app
+	}
+	var merged []*marathon.Application
+	for _, app := range current {
+		if replaced, ok := byID[app.ID]; ok {
+			merged = append(merged, replaced)
+			delete(byID, app.ID)
+			continue
+		}
+		// the version of an app in an update asks marathon to restore it
+		app.Version = ""
+		app.DeploymentID = nil
+		merged = append(merged, app)
+	}
+	for _, app := range deployed {
+		if _, ok := byID[app.ID]; ok {
+			merged = append(merged, app)
+		}
+	}
+	return merged
+}
+
+func (dep *MarathonDeployer) updateGroup(method, groupID string, group *marathonGroup) (*ExpectedDeployment, error) {
+	p := "/v2/groups"
+	if method == "PUT" {
+		p += groupID
+	}
+	updated := new(groupUpdate)
+	status, err := dep.request(method, p, group, updated)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, errors.New("group " + groupID + " not found")
+	}
+	return &ExpectedDeployment{
+		AppId:         groupID,
+		AppVersion:    updated.Version,
+		DeploymentIds: []string{updated.DeploymentID},
+	}, nil
+}
+
+// DeployGroup submits all the marathon apps as one group update, producing a single deployment. The apps
+// are merged into the current definition of the group so that its other apps are kept.
+func (dep *MarathonDeployer) DeployGroup(groupID string, specs [][]byte) (*ExpectedDeployment, error) {
+	if len(specs) == 0 {
+		return nil, errors.New("No candidates to deploy")
+	}
+	groupID = normalizeGroupID(groupID)
+	var apps []*marathon.Application
+	for _, spec := range specs {
+		app, err := parseContent(spec)
+		if err != nil {
+			return nil, err
+		}
+		if app.ID, err = groupMember(groupID, app.ID); err != nil {
+			return nil, err
+		}
+		apps = append(apps, app)
+	}
+
+	current := new(marathonGroup)
+	status, err := dep.request("GET", "/v2/groups"+groupID, nil, current)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		deployed, err := dep.updateGroup("POST", groupID, &marathonGroup{ID: groupID, Apps: apps})
+		if err == nil {
+			deployed.NewDeployment = true
+		}
+		return deployed, err
+	}
+	return dep.updateGroup("PUT", groupID, &marathonGroup{ID: groupID, Apps: mergeApps(current.Apps, apps)})
+}
+
+// RollbackGroup restores the group to the version that preceded its latest deployment
+func (dep *MarathonDeployer) RollbackGroup(groupID string) (*ExpectedDeployment, error) {
+	groupID = normalizeGroupID(groupID)
+	var versions []string
+	if _, err := dep.request("GET", "/v2/groups"+groupID+"/versions", nil, &versions); err != nil {
+		return nil, err
+	}
+	if len(versions) < 2 {
+		return nil, errors.New("no previous version of " + groupID + " to roll back to")
+	}
+	return dep.updateGroup("PUT", groupID, &marathonGroup{Version: versions[1]})
+}
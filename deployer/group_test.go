@@ -0,0 +1,128 @@
+package deployer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	marathon "github.com/gambol99/go-marathon"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeGroupAPI struct {
+	versions []string
+	current  marathonGroup
+	method   string
+	group    marathonGroup
+}
+
+func (f *fakeGroupAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/v2/groups/prod/versions":
+		if len(f.versions) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(f.versions)
+	case r.Method == "GET" && r.URL.Path == "/v2/groups/prod":
+		if len(f.versions) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(f.current)
+	case r.Method == "POST" && r.URL.Path == "/v2/groups", r.Method == "PUT" && r.URL.Path == "/v2/groups/prod":
+		f.method = r.Method
+		json.NewDecoder(r.Body).Decode(&f.group)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"version": "v3", "deploymentId": "dep-1"}`))
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func TestResolvesAppIdsWithinGroup(t *testing.T) {
+	id, err := groupMember("/prod", "elApp")
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, "/prod/elApp", id)
+
+	id, err = groupMember("/prod", "/prod/elApp")
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, "/prod/elApp", id)
+
+	_, err = groupMember("/prod", "/prod/api/elApp")
+	assert.NotNil(t, err, "should refuse apps of a subgroup")
+	_, err = groupMember("/prod", "api/elApp")
+	assert.NotNil(t, err, "should refuse apps of a subgroup")
+
+	_, err = groupMember("/prod", "/web/elApp")
+	assert.NotNil(t, err, "should refuse apps of another group")
+}
+
+func TestCanCreateGroupFromSpecs(t *testing.T) {
+	api := &fakeGroupAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	dep := &MarathonDeployer{URL: server.URL}
+	deployed, err := dep.DeployGroup("prod", [][]byte{[]byte(jsonContent), []byte(`{"id": "other"}`)})
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, "POST", api.method)
+	assert.Equal(t, "/prod", api.group.ID)
+	assert.Equal(t, 2, len(api.group.Apps))
+	assert.Equal(t, "/prod/elApp", api.group.Apps[0].ID)
+	assert.True(t, deployed.NewDeployment, "should be a new group")
+	assert.Equal(t, []string{"dep-1"}, deployed.DeploymentIds)
+}
+
+func TestCanUpdateExistingGroupFromSpecs(t *testing.T) {
+	api := &fakeGroupAPI{versions: []string{"v2", "v1"}, current: marathonGroup{ID: "/prod", Version: "v2", Apps: []*marathon.Application{
+		{ID: "/prod/db", Instances: 1, Version: "v2"},
+		{ID: "/prod/elApp", Instances: 1, Version: "v2"},
+	}}}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	dep := &MarathonDeployer{URL: server.URL}
+	deployed, err := dep.DeployGroup("/prod", [][]byte{[]byte(jsonContent)})
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, "PUT", api.method)
+	assert.False(t, deployed.NewDeployment, "should update the group")
+	assert.Equal(t, 2, len(api.group.Apps), "should keep the apps left out")
+	assert.Equal(t, "/prod/db", api.group.Apps[0].ID)
+	assert.Equal(t, "", api.group.Apps[0].Version)
+	assert.Equal(t, 3, api.group.Apps[1].Instances)
+}
+
+func TestCannotDeployAppsOutsideTheGroup(t *testing.T) {
+	api := &fakeGroupAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	dep := &MarathonDeployer{URL: server.URL}
+	_, err := dep.DeployGroup("/prod", [][]byte{[]byte(`{"id": "/web/elApp"}`)})
+	assert.NotNil(t, err, "should fail")
+	assert.Equal(t, "", api.method)
+}
+
+func TestCanRollbackGroupToPreviousVersion(t *testing.T) {
+	api := &fakeGroupAPI{versions: []string{"v2", "v1"}}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	dep := &MarathonDeployer{URL: server.URL}
+	deployed, err := dep.RollbackGroup("/prod")
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, "v1", api.group.Version)
+	assert.Equal(t, []string{"dep-1"}, deployed.DeploymentIds)
+}
+
+func TestCannotRollbackGroupWithoutHistory(t *testing.T) {
+	api := &fakeGroupAPI{versions: []string{"v1"}}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	dep := &MarathonDeployer{URL: server.URL}
+	_, err := dep.RollbackGroup("/prod")
+	assert.NotNil(t, err, "should fail")
+}
@@ -0,0 +1,97 @@
+package deployer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	marathon "github.com/gambol99/go-marathon"
+)
+
+// IHealthChecker waits for the tasks of a deployed app to pass their health checks
+type IHealthChecker interface {
+	WaitForHealthyTasks(jsonContent []byte, healthy int) error
+}
+
+// IRollbacker reverts an app to the configuration that preceded its last deployment
+type IRollbacker interface {
+	Rollback(jsonContent []byte) (*ExpectedDeployment, error)
+}
+
+// healthyTasks counts the healthy tasks of the app, or its running ones when it has no health checks
+func healthyTasks(app *marathon.Application) int {
+	if len(app.HealthChecks) == 0 {
+		return app.TasksRunning
+	}
+	return app.TasksHealthy
+}
+
+// WaitForHealthyTasks polls marathon until the deployments of the app are complete and the given number
+// of tasks, or all the instances of the app when healthy is 0, pass their health checks. Tasks of the
+// previous version are still healthy while a deployment is in flight, so they are not counted until then.
+func (dep *MarathonDeployer) WaitForHealthyTasks(jsonContent []byte, healthy int) error {
+	app, err := parseContent(jsonContent)
+	if err != nil {
+		return err
+	}
+	client, err := dep.client()
+	if err != nil {
+		return err
+	}
+	id, err := running(client, app)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(dep.Timeout)
+	for {
+		current, err := client.Application(id)
+		if err != nil {
+			return err
+		}
+		target := healthy
+		if target <= 0 {
+			target = current.Instances
+		}
+		inFlight := len(current.DeploymentID)
+		if inFlight == 0 && healthyTasks(current) >= target {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %d healthy tasks of %s, %d healthy and %d unhealthy with %d deployments in flight",
+				target, id, healthyTasks(current), current.TasksUnhealthy, inFlight)
+		}
+		time.Sleep(dep.PollInterval)
+	}
+}
+
+// Rollback restores the previous version of the app and waits for the deployment to complete
+func (dep *MarathonDeployer) Rollback(jsonContent []byte) (*ExpectedDeployment, error) {
+	app, err := parseContent(jsonContent)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dep.client()
+	if err != nil {
+		return nil, err
+	}
+	id, err := running(client, app)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := client.ApplicationVersions(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions.Versions) < 2 {
+		return nil, errors.New("no previous version of " + id + " to roll back to")
+	}
+	deployment, err := client.SetApplicationVersion(id, &marathon.ApplicationVersion{Version: versions.Versions[1]})
+	if err != nil {
+		return nil, err
+	}
+	if err := client.WaitOnDeployment(deployment.DeploymentID, dep.Timeout); err != nil {
+		return nil, err
+	}
+	return &ExpectedDeployment{AppId: id, DeploymentIds: []string{deployment.DeploymentID}}, nil
+}
@@ -0,0 +1,57 @@
+package deployer
+
+import (
+	"testing"
+	"time"
+
+	marathon "github.com/gambol99/go-marathon"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitsForAllInstancesToBeHealthy(t *testing.T) {
+	app := &marathon.Application{ID: "/web", Instances: 3, TasksHealthy: 3, HealthChecks: []*marathon.HealthCheck{{}}}
+	dep := &MarathonDeployer{Client: newFakeClient(app), Timeout: time.Second}
+
+	assert.Nil(t, dep.WaitForHealthyTasks([]byte(strategySpec), 0), "should be healthy")
+}
+
+func TestCountsRunningTasksWithoutHealthChecks(t *testing.T) {
+	app := &marathon.Application{ID: "/web", Instances: 3, TasksRunning: 2}
+	dep := &MarathonDeployer{Client: newFakeClient(app), Timeout: time.Second}
+
+	assert.Nil(t, dep.WaitForHealthyTasks([]byte(strategySpec), 2), "should be healthy")
+}
+
+func TestWaitsForDeploymentsInFlight(t *testing.T) {
+	app := &marathon.Application{ID: "/web", Instances: 3, TasksHealthy: 3, HealthChecks: []*marathon.HealthCheck{{}},
+		DeploymentID: []map[string]string{{"id": "d1"}}}
+	dep := &MarathonDeployer{Client: newFakeClient(app), Timeout: time.Millisecond, PollInterval: time.Millisecond}
+
+	assert.NotNil(t, dep.WaitForHealthyTasks([]byte(strategySpec), 0), "should not count the tasks of the previous version")
+}
+
+func TestTimesOutWaitingForHealthyTasks(t *testing.T) {
+	app := &marathon.Application{ID: "/web", Instances: 3, TasksHealthy: 1, TasksUnhealthy: 2, HealthChecks: []*marathon.HealthCheck{{}}}
+	dep := &MarathonDeployer{Client: newFakeClient(app), Timeout: time.Millisecond, PollInterval: time.Millisecond}
+
+	assert.NotNil(t, dep.WaitForHealthyTasks([]byte(strategySpec), 0), "should time out")
+}
+
+func TestCanRollbackToPreviousVersion(t *testing.T) {
+	client := newFakeClient(&marathon.Application{ID: "/web"})
+	client.versions = []string{"v3", "v2", "v1"}
+	dep := &MarathonDeployer{Client: client}
+
+	rolledBack, err := dep.Rollback([]byte(strategySpec))
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, []string{"v-/web"}, rolledBack.DeploymentIds)
+	assert.Equal(t, []string{"revert /web to v2"}, client.calls)
+}
+
+func TestCannotRollbackWithoutPreviousVersion(t *testing.T) {
+	client := newFakeClient(&marathon.Application{ID: "/web"})
+	client.versions = []string{"v1"}
+
+	_, err := (&MarathonDeployer{Client: client}).Rollback([]byte(strategySpec))
+	assert.NotNil(t, err, "should fail")
+}
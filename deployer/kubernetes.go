@@ -0,0 +1,324 @@
+package deployer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const fieldManager = "dpipeliner"
+
+// KubernetesDeployer applies kubernetes manifests and waits for their rollout
+type KubernetesDeployer struct {
+	URL          string
+	Token        string
+	Namespace    string
+	Timeout      time.Duration
+	PollInterval time.Duration
+	Client       *http.Client
+}
+
+type kubeMetadata struct {
+	Name        string            `yaml:"name" json:"name"`
+	Namespace   string            `yaml:"namespace" json:"namespace"`
+	Generation  int64             `yaml:"generation" json:"generation"`
+	Annotations map[string]string `yaml:"annotations" json:"annotations"`
+}
+
+type kubeObject struct {
+	APIVersion string       `yaml:"apiVersion"`
+	Kind       string       `yaml:"kind"`
+	Metadata   kubeMetadata `yaml:"metadata"`
+	content    []byte
+}
+
+type kubeCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+type kubeDeployment struct {
+	Metadata kubeMetadata `json:"metadata"`
+	Spec     struct {
+		Replicas *int32 `json:"replicas"`
+	} `json:"spec"`
+	Status struct {
+		ObservedGeneration int64           `json:"observedGeneration"`
+		Replicas           int32           `json:"replicas"`
+		UpdatedReplicas    int32           `json:"updatedReplicas"`
+		AvailableReplicas  int32           `json:"availableReplicas"`
+		Conditions         []kubeCondition `json:"conditions"`
+	} `json:"status"`
+}
+
+var kubeResources = map[string]string{
+	"Deployment": "deployments",
+	"Service":    "services",
+	"ConfigMap":  "configmaps",
+	"Secret":     "secrets",
+}
+
+// NewKubernetesDeployer initializes a deployer targeting a kubernetes api server
+func NewKubernetesDeployer(url, token, namespace string) IDeployer {
+	return &KubernetesDeployer{
+		URL:          strings.TrimSuffix(url, "/"),
+		Token:        token,
+		Namespace:    namespace,
+		Timeout:      5 * time.Minute,
+		PollInterval: 2 * time.Second,
+		Client:       http.DefaultClient,
+	}
+}
+
+// documentSeparator matches the lines made only of ---, which separate the documents of a yaml stream
+var documentSeparator = regexp.MustCompile(`(?m)^---[ \t]*\r?$`)
+
+func parseManifests(content []byte) ([]kubeObject, error) {
+	var objects []kubeObject
+	for _, doc := range documentSeparator.Split(string(content), -1) {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		obj := kubeObject{}
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+			return nil, err
+		}
+		if obj.Kind == "" || obj.Metadata.Name == "" {
+			return nil, errors.New("manifest is missing a kind or metadata.name")
+		}
+		obj.content = []byte(doc)
+		objects = append(objects, obj)
+	}
+	if len(objects) == 0 {
+		return nil, errors.New("no kubernetes manifest found")
+	}
+	return objects, nil
+}
+
+func (dep *KubernetesDeployer) resourcePath(obj kubeObject) (string, error) {
+	plural, ok := kubeResources[obj.Kind]
+	if !ok {
+		return "", errors.New(obj.Kind + " is not a supported kind")
+	}
+	namespace := obj.Metadata.Namespace
+	if namespace == "" {
+		namespace = dep.Namespace
+	}
+	prefix := "/apis/" + obj.APIVersion
+	if obj.APIVersion == "v1" {
+		prefix = "/api/v1"
+	}
+	return fmt.Sprintf("%s%s/namespaces/%s/%s/%s", dep.URL, prefix, namespace, plural, obj.Metadata.Name), nil
+}
+
+func (dep *KubernetesDeployer) do(method, url, contentType string, body []byte) (int, []byte, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+	if dep.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+dep.Token)
+	}
+	resp, err := dep.Client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, content, err
+}
+
+func (dep *KubernetesDeployer) exists(path string) (bool, error) {
+	status, content, err := dep.do("GET", path, "", nil)
+	if err != nil {
+		return false, err
+	}
+	switch status {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("unexpected status %d from %s: %s", status, path, content)
+}
+
+func (dep *KubernetesDeployer) apply(path string, obj kubeObject) error {
+	status, content, err := dep.do("PATCH", path+"?fieldManager="+fieldManager+"&force=true", "application/apply-patch+yaml", obj.content)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK && status != http.StatusCreated {
+		return fmt.Errorf("failed to apply %s %s (status %d): %s", obj.Kind, obj.Metadata.Name, status, content)
+	}
+	return nil
+}
+
+func (dep *KubernetesDeployer) fetchDeployment(path string) (*kubeDeployment, error) {
+	status, content, err := dep.do("GET", path, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from %s: %s", status, path, content)
+	}
+	res := new(kubeDeployment)
+	err = json.Unmarshal(content, res)
+	return res, err
+}
+
+func rolledOut(d *kubeDeployment) (bool, error) {
+	for _, cond := range d.Status.Conditions {
+		if cond.Type == "Progressing" && cond.Reason == "ProgressDeadlineExceeded" {
+			return false, errors.New("rollout of " + d.Metadata.Name + " failed: " + cond.Message)
+		}
+	}
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	return d.Status.ObservedGeneration >= d.Metadata.Generation &&
+		d.Status.UpdatedReplicas == replicas &&
+		d.Status.Replicas == replicas &&
+		d.Status.AvailableReplicas == replicas, nil
+}
+
+func (dep *KubernetesDeployer) waitForRollout(path string) (*kubeDeployment, error) {
+	deadline := time.Now().Add(dep.Timeout)
+	for {
+		d, err := dep.fetchDeployment(path)
+		if err != nil {
+			return nil, err
+		}
+		if done, err := rolledOut(d); err != nil || done {
+			return d, err
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("timed out waiting for rollout of " + d.Metadata.Name)
+		}
+		time.Sleep(dep.PollInterval)
+	}
+}
+
+// Deploy applies the manifests and waits for the deployment rollout to complete
+func (dep *KubernetesDeployer) Deploy(content []byte) (*ExpectedDeployment, error) {
+	objects, err := parseManifests(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var deployment *kubeObject
+	var deploymentPath string
+	expected := &ExpectedDeployment{}
+	for i, obj := range objects {
+		path, err := dep.resourcePath(obj)
+		if err != nil {
+			return nil, err
+		}
+		if obj.Kind == "Deployment" && deployment == nil {
+			deployment, deploymentPath = &objects[i], path
+			exists, err := dep.exists(path)
+			if err != nil {
+				return nil, err
+			}
+			expected.NewDeployment = !exists
+		}
+		if err := dep.apply(path, obj); err != nil {
+			return nil, err
+		}
+	}
+
+	if deployment == nil {
+		return nil, errors.New("manifest does not contain a Deployment")
+	}
+
+	rollout, err := dep.waitForRollout(deploymentPath)
+	if err != nil {
+		return nil, err
+	}
+	expected.AppId = deployment.Metadata.Name
+	if revision, ok := rollout.Metadata.Annotations["deployment.kubernetes.io/revision"]; ok {
+		expected.DeploymentIds = []string{revision}
+	}
+	return expected, nil
+}
+
+// deploymentPath returns the api path of the Deployment described by the manifests
+func (dep *KubernetesDeployer) deploymentPath(content []byte) (string, string, error) {
+	objects, err := parseManifests(content)
+	if err != nil {
+		return "", "", err
+	}
+	for _, obj := range objects {
+		if obj.Kind == "Deployment" {
+			path, err := dep.resourcePath(obj)
+			return obj.Metadata.Name, path, err
+		}
+	}
+	return "", "", errors.New("manifest does not contain a Deployment")
+}
+
+// patchDeployment merges the patch into the Deployment of the manifests and waits for the rollout
+func (dep *KubernetesDeployer) patchDeployment(content []byte, subresource string, patch interface{}) (*ExpectedDeployment, error) {
+	name, path, err := dep.deploymentPath(content)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	status, res, err := dep.do("PATCH", path+subresource, "application/merge-patch+json", body)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("failed to patch Deployment %s (status %d): %s", name, status, res)
+	}
+	rollout, err := dep.waitForRollout(path)
+	if err != nil {
+		return nil, err
+	}
+	expected := &ExpectedDeployment{AppId: name}
+	if revision, ok := rollout.Metadata.Annotations["deployment.kubernetes.io/revision"]; ok {
+		expected.DeploymentIds = []string{revision}
+	}
+	return expected, nil
+}
+
+// Scale sets the replicas of the deployment and waits for the rollout to complete
+func (dep *KubernetesDeployer) Scale(content []byte, instances int) (*ExpectedDeployment, error) {
+	if instances < 0 {
+		return nil, errors.New("cannot scale to a negative number of replicas")
+	}
+	patch := map[string]interface{}{"spec": map[string]interface{}{"replicas": instances}}
+	return dep.patchDeployment(content, "/scale", patch)
+}
+
+// Restart rolls the pods of the deployment the same way kubectl rollout restart does
+func (dep *KubernetesDeployer) Restart(content []byte) (*ExpectedDeployment, error) {
+	patch := map[string]interface{}{"spec": map[string]interface{}{"template": map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": map[string]string{
+			"kubectl.kubernetes.io/restartedAt": time.Now().Format(time.RFC3339),
+		}},
+	}}}
+	return dep.patchDeployment(content, "", patch)
+}
+
+// Suspend scales the deployment down to 0 replicas
+func (dep *KubernetesDeployer) Suspend(content []byte) (*ExpectedDeployment, error) {
+	return dep.Scale(content, 0)
+}
@@ -0,0 +1,160 @@
+package deployer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const manifestContent = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: elApp
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: elApp
+        image: group/image:1
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: elApp
+  namespace: web
+spec:
+  ports:
+  - port: 80
+`
+
+type fakeKubeAPI struct {
+	applied   []string
+	headers   []string
+	polls     int
+	available int
+	failed    bool
+	exists    bool
+}
+
+func (f *fakeKubeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "PATCH":
+		f.applied = append(f.applied, r.URL.Path)
+		f.headers = append(f.headers, r.Header.Get("Content-Type")+" "+r.URL.Query().Get("fieldManager"))
+		f.exists = true
+		w.WriteHeader(http.StatusOK)
+	case "GET":
+		if !f.exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f.polls++
+		available := 0
+		if f.polls > f.available {
+			available = 2
+		}
+		condition := ""
+		if f.failed {
+			condition = `{"type": "Progressing", "reason": "ProgressDeadlineExceeded", "message": "too slow"}`
+		}
+		fmt.Fprintf(w, `{
+			"metadata": {"name": "elApp", "generation": 3, "annotations": {"deployment.kubernetes.io/revision": "7"}},
+			"spec": {"replicas": 2},
+			"status": {"observedGeneration": 3, "replicas": 2, "updatedReplicas": 2, "availableReplicas": %d, "conditions": [%s]}
+		}`, available, condition)
+	}
+}
+
+func newTestKubernetesDeployer(url string) *KubernetesDeployer {
+	dep := NewKubernetesDeployer(url, "secret", "default").(*KubernetesDeployer)
+	dep.PollInterval = time.Millisecond
+	dep.Timeout = time.Second
+	return dep
+}
+
+func TestCanParseMultipleManifests(t *testing.T) {
+	objects, err := parseManifests([]byte(manifestContent))
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, 2, len(objects), "should find both documents")
+	assert.Equal(t, "Deployment", objects[0].Kind)
+	assert.Equal(t, "web", objects[1].Metadata.Namespace)
+}
+
+func TestSplitsManifestsOnSeparatorLinesOnly(t *testing.T) {
+	content := "---\nkind: ConfigMap\nmetadata:\n  name: banner\ndata:\n  banner: |\n    ----\n    --- welcome ---\n---  \nkind: Service\nmetadata:\n  name: elApp\n"
+	objects, err := parseManifests([]byte(content))
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, 2, len(objects), "should only split on separator lines")
+	assert.Contains(t, string(objects[0].content), "--- welcome ---")
+	assert.Equal(t, "Service", objects[1].Kind)
+}
+
+func TestFailsToParseManifestWithoutName(t *testing.T) {
+	_, err := parseManifests([]byte("kind: Deployment\n"))
+	assert.NotNil(t, err, "should fail without a name")
+}
+
+func TestCanDeployToKubernetesAndWaitForRollout(t *testing.T) {
+	api := &fakeKubeAPI{available: 2}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	expected, err := newTestKubernetesDeployer(server.URL).Deploy([]byte(manifestContent))
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, "elApp", expected.AppId)
+	assert.True(t, expected.NewDeployment, "should be a new deployment")
+	assert.Equal(t, []string{"7"}, expected.DeploymentIds)
+	assert.Equal(t, []string{
+		"/apis/apps/v1/namespaces/default/deployments/elApp",
+		"/api/v1/namespaces/web/services/elApp",
+	}, api.applied)
+	assert.Equal(t, "application/apply-patch+yaml dpipeliner", api.headers[0])
+	assert.Equal(t, 3, api.polls, "should poll until available")
+}
+
+func TestKubernetesDeployFailsWhenRolloutExceedsDeadline(t *testing.T) {
+	api := &fakeKubeAPI{exists: true, failed: true}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	_, err := newTestKubernetesDeployer(server.URL).Deploy([]byte(manifestContent))
+	assert.NotNil(t, err, "should fail")
+}
+
+func TestKubernetesDeployFailsOnUnsupportedKind(t *testing.T) {
+	_, err := newTestKubernetesDeployer("http://localhost").Deploy([]byte("apiVersion: batch/v1\nkind: CronJob\nmetadata:\n  name: job\n"))
+	assert.NotNil(t, err, "should fail")
+}
+
+func TestCanScaleKubernetesDeployment(t *testing.T) {
+	api := &fakeKubeAPI{exists: true}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	expected, err := newTestKubernetesDeployer(server.URL).Scale([]byte(manifestContent), 2)
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, "elApp", expected.AppId)
+	assert.Equal(t, []string{"7"}, expected.DeploymentIds)
+	assert.Equal(t, []string{"/apis/apps/v1/namespaces/default/deployments/elApp/scale"}, api.applied)
+	assert.Equal(t, "application/merge-patch+json ", api.headers[0])
+}
+
+func TestCanRestartKubernetesDeployment(t *testing.T) {
+	api := &fakeKubeAPI{exists: true}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	_, err := newTestKubernetesDeployer(server.URL).Restart([]byte(manifestContent))
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, []string{"/apis/apps/v1/namespaces/default/deployments/elApp"}, api.applied)
+}
+
+func TestKubernetesCannotScaleWithoutDeployment(t *testing.T) {
+	_, err := newTestKubernetesDeployer("http://localhost").Suspend([]byte("apiVersion: v1\nkind: Service\nmetadata:\n  name: svc\n"))
+	assert.NotNil(t, err, "should fail")
+}
@@ -0,0 +1,130 @@
+package deployer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	marathon "github.com/gambol99/go-marathon"
+)
+
+// endpoints lists the marathon masters, which can be given as a comma separated list
+func (dep *MarathonDeployer) endpoints() []string {
+	var res []string
+	for _, url := range strings.Split(dep.URL, ",") {
+		if url = strings.TrimSuffix(strings.TrimSpace(url), "/"); url != "" {
+			res = append(res, url)
+		}
+	}
+	return res
+}
+
+func (dep *MarathonDeployer) http() (*http.Client, error) {
+	if dep.httpClient != nil {
+		return dep.httpClient, nil
+	}
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if dep.CACert != "" {
+		pem, err := ioutil.ReadFile(dep.CACert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate found in " + dep.CACert)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	dep.httpClient = &http.Client{Transport: transport, Timeout: dep.RequestTimeout}
+	return dep.httpClient, nil
+}
+
+// client returns the marathon client, building it on first use so that a single one serves the whole process
+func (dep *MarathonDeployer) client() (marathon.Marathon, error) {
+	dep.mu.Lock()
+	defer dep.mu.Unlock()
+	if dep.Client != nil {
+		return dep.Client, nil
+	}
+	httpClient, err := dep.http()
+	if err != nil {
+		return nil, err
+	}
+	config := marathon.NewDefaultConfig()
+	config.URL = strings.Join(dep.endpoints(), ",")
+	config.HTTPBasicAuthUser = dep.Username
+	config.HTTPBasicPassword = dep.Password
+	config.DCOSToken = dep.DCOSToken
+	config.HTTPClient = httpClient
+	if dep.LogOutput != nil {
+		config.LogOutput = dep.LogOutput
+	}
+	client, err := marathon.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	dep.Client = client
+	return client, nil
+}
+
+func (dep *MarathonDeployer) authorize(req *http.Request) {
+	if dep.DCOSToken != "" {
+		req.Header.Set("Authorization", "token="+dep.DCOSToken)
+	} else if dep.Username != "" {
+		req.SetBasicAuth(dep.Username, dep.Password)
+	}
+}
+
+// request calls marathon endpoints that the go-marathon client does not cover, failing over between
+// masters on connection errors; not found is reported through the status
+func (dep *MarathonDeployer) request(method, p string, body interface{}, res interface{}) (int, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return 0, err
+		}
+	}
+	dep.mu.Lock()
+	httpClient, err := dep.http()
+	dep.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	var resp *http.Response
+	err = errors.New("no marathon endpoint configured")
+	for _, endpoint := range dep.endpoints() {
+		req, reqErr := http.NewRequest(method, endpoint+p, bytes.NewReader(payload))
+		if reqErr != nil {
+			return 0, reqErr
+		}
+		req.Header.Set("Content-Type", "application/json")
+		dep.authorize(req)
+		if resp, err = httpClient.Do(req); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode == http.StatusNotFound {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("marathon %s %s failed (status %d): %s", method, p, resp.StatusCode, content)
+	}
+	if res != nil && len(content) > 0 {
+		err = json.Unmarshal(content, res)
+	}
+	return resp.StatusCode, err
+}
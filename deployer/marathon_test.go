@@ -0,0 +1,91 @@
+package deployer
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func authEcho(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(`"` + r.Header.Get("Authorization") + `"`))
+}
+
+func TestCanSplitMarathonEndpoints(t *testing.T) {
+	dep := &MarathonDeployer{URL: "http://m1:8080/, http://m2:8080,,"}
+	assert.Equal(t, []string{"http://m1:8080", "http://m2:8080"}, dep.endpoints())
+}
+
+func TestRequestFailsOverToNextMaster(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(authEcho))
+	down.Close()
+	up := httptest.NewServer(http.HandlerFunc(authEcho))
+	defer up.Close()
+
+	dep := &MarathonDeployer{URL: down.URL + "," + up.URL}
+	var auth string
+	status, err := dep.request("GET", "/v2/info", nil, &auth)
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestRequestFailsWhenAllMastersAreDown(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(authEcho))
+	down.Close()
+
+	dep := &MarathonDeployer{URL: down.URL}
+	_, err := dep.request("GET", "/v2/info", nil, nil)
+	assert.NotNil(t, err, "should fail")
+}
+
+func TestRequestUsesBasicAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(authEcho))
+	defer server.Close()
+
+	dep := &MarathonDeployer{URL: server.URL, Username: "user", Password: "pass"}
+	var auth string
+	_, err := dep.request("GET", "/v2/info", nil, &auth)
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, "Basic dXNlcjpwYXNz", auth)
+}
+
+func TestRequestPrefersDCOSToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(authEcho))
+	defer server.Close()
+
+	dep := &MarathonDeployer{URL: server.URL, Username: "user", DCOSToken: "tok"}
+	var auth string
+	_, err := dep.request("GET", "/v2/info", nil, &auth)
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, "token=tok", auth)
+}
+
+func TestRequestTrustsConfiguredCA(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(authEcho))
+	defer server.Close()
+
+	ca, err := ioutil.TempFile("", "marathon-ca")
+	assert.Nil(t, err, "should not throw")
+	defer os.Remove(ca.Name())
+	pem.Encode(ca, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	ca.Close()
+
+	untrusted := &MarathonDeployer{URL: server.URL}
+	_, err = untrusted.request("GET", "/v2/info", nil, nil)
+	assert.NotNil(t, err, "should reject unknown certificates")
+
+	trusted := &MarathonDeployer{URL: server.URL, CACert: ca.Name()}
+	_, err = trusted.request("GET", "/v2/info", nil, nil)
+	assert.Nil(t, err, "should trust the configured CA")
+}
+
+func TestReusesHTTPClient(t *testing.T) {
+	dep := &MarathonDeployer{URL: "http://m1"}
+	first, _ := dep.http()
+	second, _ := dep.http()
+	assert.True(t, first == second, "should build the http client once")
+}
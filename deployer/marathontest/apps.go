@@ -0,0 +1,231 @@
+package marathontest
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+func (s *Server) serveApps(w http.ResponseWriter, r *http.Request, rest string) {
+	switch {
+	case strings.Trim(rest, "/") == "":
+		switch r.Method {
+		case "GET":
+			s.listApps(w, r)
+		case "POST":
+			s.createApp(w, r)
+		default:
+			reply(w, http.StatusMethodNotAllowed, nil)
+		}
+	case r.Method == "POST" && strings.HasSuffix(rest, "/restart"):
+		s.restartApp(w, r, normalize(strings.TrimSuffix(rest, "/restart")))
+	case strings.Contains(rest, "/versions"):
+		parts := strings.SplitN(rest, "/versions", 2)
+		s.appVersions(w, normalize(parts[0]), strings.Trim(parts[1], "/"))
+	default:
+		id := normalize(rest)
+		switch r.Method {
+		case "GET":
+			app, ok := s.apps[id]
+			if !ok {
+				notFound(w, "App '"+id+"'")
+				return
+			}
+			reply(w, http.StatusOK, map[string]interface{}{"app": app})
+		case "PUT":
+			s.updateApp(w, r, id)
+		case "DELETE":
+			s.deleteApp(w, r, id)
+		default:
+			reply(w, http.StatusMethodNotAllowed, nil)
+		}
+	}
+}
+
+func (s *Server) listApps(w http.ResponseWriter, r *http.Request) {
+	filter := r.URL.Query().Get("id")
+	var ids []string
+	for id := range s.apps {
+		if strings.Contains(id, filter) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	apps := []App{}
+	for _, id := range ids {
+		apps = append(apps, s.apps[id])
+	}
+	reply(w, http.StatusOK, map[string]interface{}{"apps": apps})
+}
+
+func decodeApp(r *http.Request) (App, error) {
+	app := App{}
+	err := json.NewDecoder(r.Body).Decode(&app)
+	return app, err
+}
+
+func (s *Server) createApp(w http.ResponseWriter, r *http.Request) {
+	app, err := decodeApp(r)
+	if err != nil || app.id() == "" {
+		reply(w, http.StatusBadRequest, map[string]string{"message": "invalid app definition"})
+		return
+	}
+	id := normalize(app.id())
+	if _, exists := s.apps[id]; exists {
+		reply(w, http.StatusConflict, map[string]string{"message": "An app with id [" + id + "] already exists."})
+		return
+	}
+	s.store(app)
+	s.publish("api_post_event", map[string]interface{}{"uri": r.URL.Path, "appDefinition": s.apps[id]})
+	d := s.deploy([]string{id}, map[string]App{id: nil})
+	created := s.apps[id].copy()
+	created["deployments"] = []map[string]string{{"id": d.ID}}
+	reply(w, http.StatusCreated, created)
+}
+
+// updateApp merges the given fields into the app, or restores a previous version when only a version
+// is given, creating the app when it does not exist yet
+func (s *Server) updateApp(w http.ResponseWriter, r *http.Request, id string) {
+	update, err := decodeApp(r)
+	if err != nil {
+		reply(w, http.StatusBadRequest, map[string]string{"message": "invalid app definition"})
+		return
+	}
+	if !s.unlock(w, r, id) {
+		return
+	}
+	previous, exists := s.apps[id]
+
+	next := previous.copy()
+	if version, ok := update["version"].(string); ok && len(update) == 1 {
+		next = nil
+		for _, old := range s.history[id] {
+			if old["version"] == version {
+				next = old.copy()
+			}
+		}
+		if next == nil {
+			notFound(w, "Version '"+version+"' of app '"+id+"'")
+			return
+		}
+	} else {
+		if next == nil {
+			next = App{}
+		}
+		for k, v := range update {
+			if v != nil {
+				next[k] = v
+			}
+		}
+	}
+	next["id"] = id
+	delete(next, "deployments")
+
+	s.store(next)
+	s.publish("api_post_event", map[string]interface{}{"uri": r.URL.Path, "appDefinition": s.apps[id]})
+	d := s.deploy([]string{id}, map[string]App{id: previous})
+	status := http.StatusOK
+	if !exists {
+		status = http.StatusCreated
+	}
+	reply(w, status, deployed(d))
+}
+
+func (s *Server) deleteApp(w http.ResponseWriter, r *http.Request, id string) {
+	previous, ok := s.apps[id]
+	if !ok {
+		notFound(w, "App '"+id+"'")
+		return
+	}
+	if !s.unlock(w, r, id) {
+		return
+	}
+	d := s.deploy([]string{id}, map[string]App{id: previous})
+	delete(s.apps, id)
+	delete(s.history, id)
+	reply(w, http.StatusOK, deployed(d))
+}
+
+func (s *Server) restartApp(w http.ResponseWriter, r *http.Request, id string) {
+	app, ok := s.apps[id]
+	if !ok {
+		notFound(w, "App '"+id+"'")
+		return
+	}
+	if !s.unlock(w, r, id) {
+		return
+	}
+	d := s.deploy([]string{id}, map[string]App{id: app.copy()})
+	reply(w, http.StatusOK, deployed(d))
+}
+
+func (s *Server) appVersions(w http.ResponseWriter, id, version string) {
+	history, ok := s.history[id]
+	if !ok {
+		notFound(w, "App '"+id+"'")
+		return
+	}
+	if version == "" {
+		versions := []string{}
+		for _, app := range history {
+			versions = append(versions, app["version"].(string))
+		}
+		reply(w, http.StatusOK, map[string]interface{}{"versions": versions})
+		return
+	}
+	for _, app := range history {
+		if app["version"] == version {
+			reply(w, http.StatusOK, app)
+			return
+		}
+	}
+	notFound(w, "Version '"+version+"' of app '"+id+"'")
+}
+
+func (s *Server) serveDeployments(w http.ResponseWriter, r *http.Request, id string) {
+	switch {
+	case id == "" && r.Method == "GET":
+		s.advance()
+		deployments := []*Deployment{}
+		deployments = append(deployments, s.deployments...)
+		reply(w, http.StatusOK, deployments)
+	case id != "" && r.Method == "DELETE":
+		d := s.remove(id)
+		if d == nil {
+			notFound(w, "Deployment '"+id+"'")
+			return
+		}
+		s.publish("deployment_failed", map[string]interface{}{"id": d.ID})
+		if r.URL.Query().Get("force") == "true" {
+			reply(w, http.StatusAccepted, nil)
+			return
+		}
+		s.rollback(w, d)
+	default:
+		reply(w, http.StatusMethodNotAllowed, nil)
+	}
+}
+
+// rollback restores the apps affected by the cancelled deployment with a new deployment
+func (s *Server) rollback(w http.ResponseWriter, cancelled *Deployment) {
+	var ids []string
+	for id, previous := range cancelled.previous {
+		ids = append(ids, id)
+		if previous == nil {
+			delete(s.apps, id)
+			delete(s.history, id)
+		} else {
+			s.store(previous)
+		}
+	}
+	sort.Strings(ids)
+	d := &Deployment{ID: cancelled.ID + "-rollback", Version: s.nextVersion(), AffectedApps: ids, CurrentStep: 1, TotalSteps: 1}
+	s.publish("deployment_info", map[string]interface{}{
+		"plan":        map[string]interface{}{"id": d.ID, "version": d.Version, "affectedApps": ids},
+		"currentStep": d.CurrentStep,
+	})
+	s.deployments = append(s.deployments, d)
+	s.finish(d)
+	reply(w, http.StatusOK, deployed(d))
+}
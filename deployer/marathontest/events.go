@@ -0,0 +1,83 @@
+package marathontest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Event is a marathon event as published on /v2/events
+type Event struct {
+	Type string
+	Data map[string]interface{}
+}
+
+// Events returns every event published so far
+func (s *Server) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+// publish records the event and sends it to the subscribers of the event stream
+func (s *Server) publish(eventType string, data map[string]interface{}) {
+	data["eventType"] = eventType
+	data["timestamp"] = time.Date(2016, 1, 1, 0, 0, s.clock, 0, time.UTC).Format("2006-01-02T15:04:05.000Z")
+	event := Event{Type: eventType, Data: data}
+	s.events = append(s.events, event)
+	for _, subscriber := range s.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// stream serves the server sent events of /v2/events until the client goes away or the server closes
+func (s *Server) stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		reply(w, http.StatusNotAcceptable, nil)
+		return
+	}
+	events := make(chan Event, 64)
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	if s.Authorization != "" && r.Header.Get("Authorization") != s.Authorization {
+		s.mu.Unlock()
+		reply(w, http.StatusUnauthorized, map[string]string{"message": "not authorized"})
+		return
+	}
+	s.subscribers = append(s.subscribers, events)
+	s.mu.Unlock()
+	defer s.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case event, open := <-events:
+			if !open {
+				return
+			}
+			data, _ := json.Marshal(event.Data)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) unsubscribe(events chan Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, subscriber := range s.subscribers {
+		if subscriber == events {
+			s.subscribers = append(s.subscribers[:i], s.subscribers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package marathontest
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+type groupDefinition struct {
+	ID      string `json:"id"`
+	Apps    []App  `json:"apps"`
+	Version string `json:"version"`
+}
+
+func (s *Server) serveGroups(w http.ResponseWriter, r *http.Request, rest string) {
+	if strings.HasSuffix(rest, "/versions") {
+		id := normalize(strings.TrimSuffix(rest, "/versions"))
+		g, ok := s.groups[id]
+		if !ok {
+			notFound(w, "Group '"+id+"'")
+			return
+		}
+		versions := []string{}
+		for _, v := range g.Versions {
+			versions = append(versions, v.Version)
+		}
+		reply(w, http.StatusOK, versions)
+		return
+	}
+
+	id := normalize(rest)
+	switch r.Method {
+	case "GET":
+		g, ok := s.groups[id]
+		if !ok {
+			notFound(w, "Group '"+id+"'")
+			return
+		}
+		apps := []App{}
+		for _, appID := range g.Apps {
+			apps = append(apps, s.apps[appID])
+		}
+		reply(w, http.StatusOK, map[string]interface{}{"id": g.ID, "apps": apps, "version": g.Versions[0].Version})
+	case "POST", "PUT":
+		definition := groupDefinition{}
+		if err := json.NewDecoder(r.Body).Decode(&definition); err != nil {
+			reply(w, http.StatusBadRequest, map[string]string{"message": "invalid group definition"})
+			return
+		}
+		if r.Method == "POST" {
+			id = normalize(definition.ID)
+			if _, exists := s.groups[id]; exists {
+				reply(w, http.StatusConflict, map[string]string{"message": "Group " + id + " is already created."})
+				return
+			}
+		}
+		s.updateGroup(w, r, id, definition)
+	case "DELETE":
+		g, ok := s.groups[id]
+		if !ok {
+			notFound(w, "Group '"+id+"'")
+			return
+		}
+		previous := make(map[string]App)
+		for _, appID := range g.Apps {
+			previous[appID] = s.apps[appID]
+			delete(s.apps, appID)
+			delete(s.history, appID)
+		}
+		delete(s.groups, id)
+		reply(w, http.StatusOK, deployed(s.deploy(g.Apps, previous)))
+	default:
+		reply(w, http.StatusMethodNotAllowed, nil)
+	}
+}
+
+// updateGroup replaces the apps of the group, or restores a previous version of the group, as a
+// single deployment affecting all of its apps
+func (s *Server) updateGroup(w http.ResponseWriter, r *http.Request, id string, definition groupDefinition) {
+	g, exists := s.groups[id]
+	if !exists {
+		if r.Method == "PUT" && definition.Version != "" {
+			notFound(w, "Group '"+id+"'")
+			return
+		}
+		g = &group{ID: id}
+	}
+
+	apps := definition.Apps
+	if definition.Version != "" {
+		apps = nil
+		for _, v := range g.Versions {
+			if v.Version == definition.Version {
+				apps = v.Apps
+			}
+		}
+		if apps == nil {
+			notFound(w, "Version '"+definition.Version+"' of group '"+id+"'")
+			return
+		}
+	}
+
+	members := make(map[string]App)
+	for _, app := range apps {
+		appID := app.id()
+		if !strings.HasPrefix(appID, "/") {
+			appID = path.Join(id, appID)
+		}
+		member := app.copy()
+		member["id"] = appID
+		members[appID] = member
+	}
+	for _, appID := range g.Apps {
+		if _, kept := members[appID]; !kept {
+			members[appID] = nil
+		}
+	}
+
+	var affected []string
+	for appID := range members {
+		if !s.unlock(w, r, appID) {
+			return
+		}
+		affected = append(affected, appID)
+	}
+	sort.Strings(affected)
+
+	previous := make(map[string]App)
+	var stored []App
+	g.Apps = nil
+	for _, appID := range affected {
+		previous[appID] = s.apps[appID].copy()
+		if members[appID] == nil {
+			delete(s.apps, appID)
+			delete(s.history, appID)
+			continue
+		}
+		member := members[appID]
+		delete(member, "deployments")
+		s.store(member)
+		g.Apps = append(g.Apps, appID)
+		stored = append(stored, s.apps[appID].copy())
+	}
+
+	d := s.deploy(affected, previous)
+	g.Versions = append([]groupVersion{{Version: d.Version, Apps: stored}}, g.Versions...)
+	s.groups[id] = g
+	status := http.StatusOK
+	if !exists {
+		status = http.StatusCreated
+	}
+	reply(w, status, deployed(d))
+}
@@ -0,0 +1,389 @@
+// Package marathontest serves an in-memory subset of the marathon REST API so that deployers can be
+// tested end to end. Apps, deployments, groups and the event stream are stateful, and the outcome
+// of the deployments of each app can be scripted.
+package marathontest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcome decides how the deployments touching an app end
+type Outcome string
+
+const (
+	// Succeed completes the deployment and reports all the tasks of the apps as healthy
+	Succeed Outcome = "succeed"
+	// Fail removes the deployment with a deployment_failed event and reports all the tasks as unhealthy,
+	// or as not running for apps without health checks
+	Fail Outcome = "fail"
+	// Hang never completes the deployment, so that waiting on it times out
+	Hang Outcome = "hang"
+)
+
+type script struct {
+	outcome Outcome
+	steps   int
+}
+
+// Deployment is an in-flight deployment of the fake server
+type Deployment struct {
+	ID           string   `json:"id"`
+	Version      string   `json:"version"`
+	AffectedApps []string `json:"affectedApps"`
+	CurrentStep  int      `json:"currentStep"`
+	TotalSteps   int      `json:"totalSteps"`
+
+	outcome  Outcome
+	previous map[string]App
+}
+
+// App is the json definition of a marathon app as stored by the fake server
+type App map[string]interface{}
+
+type group struct {
+	ID       string
+	Apps     []string
+	Versions []groupVersion
+}
+
+type groupVersion struct {
+	Version string
+	Apps    []App
+}
+
+// Server is a fake marathon listening on a local port
+type Server struct {
+	URL string
+	// Authorization, when set, is the Authorization header every request must carry
+	Authorization string
+
+	mu          sync.Mutex
+	server      *httptest.Server
+	apps        map[string]App
+	history     map[string][]App
+	groups      map[string]*group
+	deployments []*Deployment
+	scripts     map[string]script
+	events      []Event
+	subscribers []chan Event
+	requests    []string
+	clock       int
+	sequence    int
+}
+
+// NewServer starts a fake marathon without any app
+func NewServer() *Server {
+	s := &Server{
+		apps:    make(map[string]App),
+		history: make(map[string][]App),
+		groups:  make(map[string]*group),
+		scripts: make(map[string]script),
+	}
+	s.server = httptest.NewServer(s)
+	s.URL = s.server.URL
+	return s
+}
+
+// Close ends the event streams and stops the server
+func (s *Server) Close() {
+	s.mu.Lock()
+	for _, subscriber := range s.subscribers {
+		close(subscriber)
+	}
+	s.subscribers = nil
+	s.mu.Unlock()
+	s.server.Close()
+}
+
+// Script sets the outcome of the next deployments of the app, reached after the given number of
+// polls of /v2/deployments. Unscripted apps succeed immediately.
+func (s *Server) Script(appID string, outcome Outcome, steps int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[normalize(appID)] = script{outcome: outcome, steps: steps}
+}
+
+// AddApp registers an app as already deployed and healthy, without going through a deployment
+func (s *Server) AddApp(definition string) error {
+	app := App{}
+	if err := json.Unmarshal([]byte(definition), &app); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.store(app)
+	s.settle(id, Succeed)
+	return nil
+}
+
+// App returns a copy of the current definition of the app
+func (s *Server) App(id string) (App, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	app, ok := s.apps[normalize(id)]
+	return app.copy(), ok
+}
+
+// Deployments returns the deployments still in flight
+func (s *Server) Deployments() []Deployment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []Deployment
+	for _, d := range s.deployments {
+		res = append(res, *d)
+	}
+	return res
+}
+
+// Requests returns the method and path of every request received so far
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func normalize(id string) string {
+	return "/" + strings.Trim(id, "/")
+}
+
+func (a App) copy() App {
+	if a == nil {
+		return nil
+	}
+	res := App{}
+	for k, v := range a {
+		res[k] = v
+	}
+	return res
+}
+
+func (a App) id() string {
+	id, _ := a["id"].(string)
+	return id
+}
+
+func (a App) instances() int {
+	if n, ok := a["instances"].(float64); ok {
+		return int(n)
+	}
+	return 1
+}
+
+// nextVersion returns a new, strictly increasing, marathon version timestamp
+func (s *Server) nextVersion() string {
+	s.clock++
+	return time.Date(2016, 1, 1, 0, 0, s.clock, 0, time.UTC).Format("2006-01-02T15:04:05.000Z")
+}
+
+// store saves the app as its new version, keeping the previous ones for /versions and rollbacks
+func (s *Server) store(app App) string {
+	app = app.copy()
+	id := normalize(app.id())
+	app["id"] = id
+	app["version"] = s.nextVersion()
+	s.apps[id] = app
+	s.history[id] = append([]App{app.copy()}, s.history[id]...)
+	return id
+}
+
+// settle updates the task counts of an app once its deployment ended with the outcome
+func (s *Server) settle(id string, outcome Outcome) {
+	app, ok := s.apps[id]
+	if !ok {
+		return
+	}
+	instances := app.instances()
+	checks, _ := app["healthChecks"].([]interface{})
+	app["tasksStaged"] = 0
+	app["tasksRunning"] = instances
+	app["tasksHealthy"] = 0
+	app["tasksUnhealthy"] = 0
+	switch outcome {
+	case Fail:
+		if len(checks) > 0 {
+			app["tasksUnhealthy"] = instances
+		} else {
+			app["tasksRunning"] = 0
+		}
+	case Hang:
+		app["tasksStaged"] = instances
+		app["tasksRunning"] = 0
+	default:
+		if len(checks) > 0 {
+			app["tasksHealthy"] = instances
+		}
+	}
+	deployments := []map[string]string{}
+	for _, d := range s.deployments {
+		for _, affected := range d.AffectedApps {
+			if affected == id {
+				deployments = append(deployments, map[string]string{"id": d.ID})
+			}
+		}
+	}
+	app["deployments"] = deployments
+}
+
+// deploy starts a deployment of the apps, finishing it straight away unless it was scripted otherwise
+func (s *Server) deploy(ids []string, previous map[string]App) *Deployment {
+	s.sequence++
+	d := &Deployment{
+		ID:           fmt.Sprintf("deployment-%d", s.sequence),
+		Version:      s.nextVersion(),
+		AffectedApps: ids,
+		CurrentStep:  1,
+		TotalSteps:   1,
+		outcome:      Succeed,
+		previous:     previous,
+	}
+	for _, id := range ids {
+		if sc, ok := s.scripts[id]; ok {
+			if sc.outcome != Succeed {
+				d.outcome = sc.outcome
+			}
+			if sc.steps+1 > d.TotalSteps {
+				d.TotalSteps = sc.steps + 1
+			}
+		}
+	}
+	s.deployments = append(s.deployments, d)
+	s.publish("deployment_info", map[string]interface{}{
+		"plan":        map[string]interface{}{"id": d.ID, "version": d.Version, "affectedApps": ids},
+		"currentStep": d.CurrentStep,
+	})
+	for _, id := range ids {
+		s.settle(id, Hang)
+	}
+	if d.CurrentStep >= d.TotalSteps {
+		s.finish(d)
+	}
+	return d
+}
+
+// finish ends the deployment according to its outcome, hanging ones stay in flight
+func (s *Server) finish(d *Deployment) {
+	if d.outcome == Hang {
+		return
+	}
+	s.remove(d.ID)
+	for _, id := range d.AffectedApps {
+		s.settle(id, d.outcome)
+	}
+	if d.outcome == Fail {
+		s.publish("deployment_failed", map[string]interface{}{"id": d.ID})
+	} else {
+		s.publish("deployment_success", map[string]interface{}{"id": d.ID})
+	}
+}
+
+// advance moves every deployment one step further
+func (s *Server) advance() {
+	for _, d := range append([]*Deployment(nil), s.deployments...) {
+		if d.CurrentStep < d.TotalSteps {
+			d.CurrentStep++
+		}
+		if d.CurrentStep >= d.TotalSteps {
+			s.finish(d)
+		}
+	}
+}
+
+func (s *Server) remove(deploymentID string) *Deployment {
+	for i, d := range s.deployments {
+		if d.ID == deploymentID {
+			s.deployments = append(s.deployments[:i], s.deployments[i+1:]...)
+			return d
+		}
+	}
+	return nil
+}
+
+// locking returns the deployments in flight for the app
+func (s *Server) locking(id string) []*Deployment {
+	var res []*Deployment
+	for _, d := range s.deployments {
+		for _, affected := range d.AffectedApps {
+			if affected == id {
+				res = append(res, d)
+			}
+		}
+	}
+	return res
+}
+
+// unlock fails with a conflict when the app is locked by a deployment, unless forced in which case
+// the locking deployments are dropped
+func (s *Server) unlock(w http.ResponseWriter, r *http.Request, id string) bool {
+	locks := s.locking(id)
+	if len(locks) == 0 {
+		return true
+	}
+	if r.URL.Query().Get("force") == "true" {
+		for _, d := range locks {
+			s.remove(d.ID)
+		}
+		return true
+	}
+	var ids []map[string]string
+	for _, d := range locks {
+		ids = append(ids, map[string]string{"id": d.ID})
+	}
+	reply(w, http.StatusConflict, map[string]interface{}{
+		"message":     "App is locked by one or more deployments. Override with the option '?force=true'.",
+		"deployments": ids,
+	})
+	return false
+}
+
+func reply(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if body != nil {
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+func notFound(w http.ResponseWriter, what string) {
+	reply(w, http.StatusNotFound, map[string]string{"message": what + " does not exist"})
+}
+
+func deployed(d *Deployment) map[string]string {
+	return map[string]string{"deploymentId": d.ID, "version": d.Version}
+}
+
+// ServeHTTP routes the marathon endpoints
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v2/events" {
+		s.stream(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	if s.Authorization != "" && r.Header.Get("Authorization") != s.Authorization {
+		reply(w, http.StatusUnauthorized, map[string]string{"message": "not authorized"})
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/ping":
+		w.Write([]byte("pong"))
+	case r.URL.Path == "/v2/info":
+		reply(w, http.StatusOK, map[string]interface{}{"name": "marathon", "version": "1.1.1", "leader": r.Host})
+	case strings.HasPrefix(r.URL.Path, "/v2/apps"):
+		s.serveApps(w, r, strings.TrimPrefix(r.URL.Path, "/v2/apps"))
+	case strings.HasPrefix(r.URL.Path, "/v2/deployments"):
+		s.serveDeployments(w, r, strings.Trim(strings.TrimPrefix(r.URL.Path, "/v2/deployments"), "/"))
+	case strings.HasPrefix(r.URL.Path, "/v2/groups"):
+		s.serveGroups(w, r, strings.TrimPrefix(r.URL.Path, "/v2/groups"))
+	default:
+		notFound(w, r.URL.Path)
+	}
+}
@@ -0,0 +1,139 @@
+package marathontest
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func call(t *testing.T, s *Server, method, path, body string, res interface{}) int {
+	req, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+	assert.Nil(t, err, "should build the request")
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err, "should reach the server")
+	defer resp.Body.Close()
+	if res != nil {
+		json.NewDecoder(resp.Body).Decode(res)
+	}
+	return resp.StatusCode
+}
+
+func TestCreatesAppsAndCompletesTheirDeployment(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	created := App{}
+	assert.Equal(t, http.StatusCreated, call(t, s, "POST", "/v2/apps", `{"id": "web", "instances": 2, "healthChecks": [{}]}`, &created))
+	assert.Equal(t, "/web", created.id())
+	assert.Empty(t, s.Deployments())
+
+	app, ok := s.App("/web")
+	assert.True(t, ok, "should store the app")
+	assert.Equal(t, 2, app["tasksHealthy"])
+	assert.Equal(t, http.StatusConflict, call(t, s, "POST", "/v2/apps", `{"id": "/web"}`, nil))
+
+	var types []string
+	for _, event := range s.Events() {
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []string{"api_post_event", "deployment_info", "deployment_success"}, types)
+}
+
+func TestLocksAppsWithHangingDeployments(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Script("/web", Hang, 0)
+
+	assert.Equal(t, http.StatusCreated, call(t, s, "PUT", "/v2/apps/web", `{"instances": 1}`, nil))
+	assert.Equal(t, 1, len(s.Deployments()))
+	assert.Equal(t, http.StatusConflict, call(t, s, "PUT", "/v2/apps/web", `{"instances": 2}`, nil))
+	assert.Equal(t, http.StatusOK, call(t, s, "PUT", "/v2/apps/web?force=true", `{"instances": 2}`, nil))
+
+	app, _ := s.App("web")
+	assert.Equal(t, float64(2), app["instances"])
+}
+
+func TestCompletesDeploymentsAfterScriptedSteps(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Script("/web", Fail, 2)
+
+	call(t, s, "PUT", "/v2/apps/web", `{"instances": 3, "healthChecks": [{}]}`, nil)
+	var deployments []Deployment
+	call(t, s, "GET", "/v2/deployments", "", &deployments)
+	assert.Equal(t, 1, len(deployments), "should still be in flight")
+	call(t, s, "GET", "/v2/deployments", "", &deployments)
+	assert.Empty(t, deployments)
+
+	app, _ := s.App("web")
+	assert.Equal(t, 3, app["tasksUnhealthy"])
+	events := s.Events()
+	assert.Equal(t, "deployment_failed", events[len(events)-1].Type)
+}
+
+func TestRollsBackCancelledDeployments(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	assert.Nil(t, s.AddApp(`{"id": "/web", "instances": 1}`))
+	s.Script("/web", Hang, 0)
+
+	update := map[string]string{}
+	call(t, s, "PUT", "/v2/apps/web", `{"instances": 5}`, &update)
+	assert.Equal(t, http.StatusOK, call(t, s, "DELETE", "/v2/deployments/"+update["deploymentId"], "", nil))
+
+	app, _ := s.App("web")
+	assert.Equal(t, float64(1), app["instances"])
+	assert.Empty(t, s.Deployments())
+
+	versions := map[string][]string{}
+	call(t, s, "GET", "/v2/apps/web/versions", "", &versions)
+	assert.Equal(t, 3, len(versions["versions"]))
+}
+
+func TestDeploysAndRestoresGroups(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	assert.Equal(t, http.StatusCreated, call(t, s, "POST", "/v2/groups", `{"id": "/shop", "apps": [{"id": "/shop/web"}, {"id": "api"}]}`, nil))
+	_, ok := s.App("/shop/api")
+	assert.True(t, ok, "should place relative apps in the group")
+
+	call(t, s, "PUT", "/v2/groups/shop", `{"apps": [{"id": "/shop/web", "instances": 4}]}`, nil)
+	_, ok = s.App("/shop/api")
+	assert.False(t, ok, "should remove apps left out of the group")
+
+	var versions []string
+	call(t, s, "GET", "/v2/groups/shop/versions", "", &versions)
+	assert.Equal(t, 2, len(versions))
+	call(t, s, "PUT", "/v2/groups/shop", `{"version": "`+versions[1]+`"}`, nil)
+	_, ok = s.App("/shop/api")
+	assert.True(t, ok, "should restore the previous version")
+}
+
+func TestStreamsEvents(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/v2/events")
+	assert.Nil(t, err, "should subscribe")
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	s.AddApp(`{"id": "/web"}`)
+	call(t, s, "DELETE", "/v2/apps/web", "", nil)
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	assert.Nil(t, err, "should read an event")
+	assert.Equal(t, "event: deployment_info\n", line)
+}
+
+func TestRequiresAuthorizationWhenConfigured(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Authorization = "token=secret"
+
+	assert.Equal(t, http.StatusUnauthorized, call(t, s, "GET", "/v2/apps", "", nil))
+}
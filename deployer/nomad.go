@@ -0,0 +1,281 @@
+package deployer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NomadDeployer registers nomad jobs and follows their deployment until it settles
+type NomadDeployer struct {
+	URL          string
+	Token        string
+	Timeout      time.Duration
+	PollInterval time.Duration
+	Client       *http.Client
+}
+
+type nomadRegistration struct {
+	EvalID string
+}
+
+type nomadEvaluation struct {
+	ID                string
+	Status            string
+	StatusDescription string
+	DeploymentID      string
+}
+
+type nomadDeployment struct {
+	ID                string
+	Status            string
+	StatusDescription string
+}
+
+// NewNomadDeployer initializes a deployer targeting a nomad agent
+func NewNomadDeployer(url, token string) IDeployer {
+	return &NomadDeployer{
+		URL:          strings.TrimSuffix(url, "/"),
+		Token:        token,
+		Timeout:      5 * time.Minute,
+		PollInterval: 2 * time.Second,
+		Client:       http.DefaultClient,
+	}
+}
+
+// do calls the nomad API. Not found is reported through the status of GET lookups, and is an error for
+// every other method since it then means a wrong path, namespace or region rather than an absent object.
+func (dep *NomadDeployer) do(method, path string, body interface{}, res interface{}) (int, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return 0, err
+		}
+	}
+	req, err := http.NewRequest(method, dep.URL+path, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if dep.Token != "" {
+		req.Header.Set("X-Nomad-Token", dep.Token)
+	}
+	resp, err := dep.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode == http.StatusNotFound && method == "GET" {
+		return resp.StatusCode, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("nomad %s %s failed (status %d): %s", method, path, resp.StatusCode, content)
+	}
+	if res != nil && len(content) > 0 {
+		err = json.Unmarshal(content, res)
+	}
+	return resp.StatusCode, err
+}
+
+// parseJob turns an HCL or JSON job spec into the JSON job definition expected by nomad
+func (dep *NomadDeployer) parseJob(content []byte) (map[string]interface{}, error) {
+	job := make(map[string]interface{})
+	if strings.HasPrefix(strings.TrimSpace(string(content)), "{") {
+		if err := json.Unmarshal(content, &job); err != nil {
+			return nil, err
+		}
+		if wrapped, ok := job["Job"].(map[string]interface{}); ok {
+			job = wrapped
+		}
+	} else {
+		parse := map[string]interface{}{"JobHCL": string(content), "Canonicalize": true}
+		if _, err := dep.do("POST", "/v1/jobs/parse", parse, &job); err != nil {
+			return nil, err
+		}
+	}
+	if id, ok := job["ID"].(string); !ok || id == "" {
+		return nil, errors.New("job spec is missing an ID")
+	}
+	return job, nil
+}
+
+func (dep *NomadDeployer) waitForEvaluation(id string) (*nomadEvaluation, error) {
+	deadline := time.Now().Add(dep.Timeout)
+	for {
+		eval := new(nomadEvaluation)
+		status, err := dep.do("GET", "/v1/evaluation/"+id, nil, eval)
+		if err != nil {
+			return nil, err
+		}
+		if status == http.StatusNotFound {
+			return nil, errors.New("evaluation " + id + " not found")
+		}
+		switch eval.Status {
+		case "complete":
+			return eval, nil
+		case "failed", "canceled":
+			return nil, errors.New("evaluation " + id + " " + eval.Status + ": " + eval.StatusDescription)
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("timed out waiting for evaluation " + id)
+		}
+		time.Sleep(dep.PollInterval)
+	}
+}
+
+func (dep *NomadDeployer) waitForDeployment(path string) (*nomadDeployment, error) {
+	deadline := time.Now().Add(dep.Timeout)
+	for {
+		var deployment *nomadDeployment
+		if _, err := dep.do("GET", path, nil, &deployment); err != nil {
+			return nil, err
+		}
+		if deployment == nil {
+			return nil, nil
+		}
+		switch deployment.Status {
+		case "successful":
+			return deployment, nil
+		case "failed", "cancelled":
+			return nil, errors.New("deployment " + deployment.ID + " " + deployment.Status + ": " + deployment.StatusDescription)
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("timed out waiting for deployment " + deployment.ID)
+		}
+		time.Sleep(dep.PollInterval)
+	}
+}
+
+// Deploy registers the job and waits until its deployment is healthy or failed
+func (dep *NomadDeployer) Deploy(content []byte) (*ExpectedDeployment, error) {
+	job, err := dep.parseJob(content)
+	if err != nil {
+		return nil, err
+	}
+	jobID := job["ID"].(string)
+
+	status, err := dep.do("GET", "/v1/job/"+jobID, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	reg := new(nomadRegistration)
+	if _, err := dep.do("POST", "/v1/jobs", map[string]interface{}{"Job": job}, reg); err != nil {
+		return nil, err
+	}
+	expected := &ExpectedDeployment{AppId: jobID, NewDeployment: status == http.StatusNotFound}
+	expected.DeploymentIds, err = dep.follow(jobID, reg.EvalID)
+	if err != nil {
+		return nil, err
+	}
+	return expected, nil
+}
+
+// follow waits for the evaluation and the deployment it triggered, returning the id of the deployment
+func (dep *NomadDeployer) follow(jobID, evalID string) ([]string, error) {
+	if evalID == "" {
+		return nil, nil
+	}
+	eval, err := dep.waitForEvaluation(evalID)
+	if err != nil {
+		return nil, err
+	}
+	path := "/v1/job/" + jobID + "/deployment"
+	if eval.DeploymentID != "" {
+		path = "/v1/deployment/" + eval.DeploymentID
+	}
+	deployment, err := dep.waitForDeployment(path)
+	if err != nil || deployment == nil {
+		return nil, err
+	}
+	return []string{deployment.ID}, nil
+}
+
+func taskGroupsOf(job map[string]interface{}) []string {
+	var names []string
+	groups, _ := job["TaskGroups"].([]interface{})
+	for _, group := range groups {
+		if g, ok := group.(map[string]interface{}); ok {
+			if name, ok := g["Name"].(string); ok && name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// Scale sets the count of every task group of the job and waits for the resulting deployments
+func (dep *NomadDeployer) Scale(content []byte, instances int) (*ExpectedDeployment, error) {
+	if instances < 0 {
+		return nil, errors.New("cannot scale to a negative count")
+	}
+	job, err := dep.parseJob(content)
+	if err != nil {
+		return nil, err
+	}
+	jobID := job["ID"].(string)
+	groups := taskGroupsOf(job)
+	if len(groups) == 0 {
+		return nil, errors.New("job " + jobID + " has no task group to scale")
+	}
+
+	expected := &ExpectedDeployment{AppId: jobID}
+	for _, group := range groups {
+		reg := new(nomadRegistration)
+		scale := map[string]interface{}{"Count": instances, "Target": map[string]string{"Group": group}}
+		if _, err := dep.do("POST", "/v1/job/"+jobID+"/scale", scale, reg); err != nil {
+			return nil, err
+		}
+		ids, err := dep.follow(jobID, reg.EvalID)
+		if err != nil {
+			return nil, err
+		}
+		expected.DeploymentIds = append(expected.DeploymentIds, ids...)
+	}
+	return expected, nil
+}
+
+// Restart restarts the tasks of every running allocation of the job in place
+func (dep *NomadDeployer) Restart(content []byte) (*ExpectedDeployment, error) {
+	job, err := dep.parseJob(content)
+	if err != nil {
+		return nil, err
+	}
+	jobID := job["ID"].(string)
+
+	var allocations []struct {
+		ID           string
+		ClientStatus string
+	}
+	status, err := dep.do("GET", "/v1/job/"+jobID+"/allocations", nil, &allocations)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, errors.New("job " + jobID + " is not registered")
+	}
+	for _, alloc := range allocations {
+		if alloc.ClientStatus != "running" {
+			continue
+		}
+		if _, err := dep.do("POST", "/v1/client/allocation/"+alloc.ID+"/restart", map[string]string{}, nil); err != nil {
+			return nil, err
+		}
+	}
+	return &ExpectedDeployment{AppId: jobID}, nil
+}
+
+// Suspend scales every task group of the job down to 0
+func (dep *NomadDeployer) Suspend(content []byte) (*ExpectedDeployment, error) {
+	return dep.Scale(content, 0)
+}
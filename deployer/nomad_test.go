@@ -0,0 +1,158 @@
+package deployer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const nomadJobJSON = `{"Job": {"ID": "elApp", "Name": "elApp", "TaskGroups": []}}`
+
+const nomadGroupsJSON = `{"Job": {"ID": "elApp", "TaskGroups": [{"Name": "web"}, {"Name": "worker"}]}}`
+
+const nomadJobHCL = `job "elApp" {
+  group "web" {}
+}`
+
+type fakeNomadAPI struct {
+	registered   map[string]interface{}
+	parsed       bool
+	evalPolls    int
+	deployStatus string
+	token        string
+	existing     bool
+	paths        []string
+	scaled       []map[string]interface{}
+}
+
+func (f *fakeNomadAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.token = r.Header.Get("X-Nomad-Token")
+	f.paths = append(f.paths, r.Method+" "+r.URL.Path)
+	switch r.URL.Path {
+	case "/v1/jobs/parse":
+		f.parsed = true
+		w.Write([]byte(`{"ID": "elApp", "Name": "elApp"}`))
+	case "/v1/jobs":
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.registered = body["Job"].(map[string]interface{})
+		w.Write([]byte(`{"EvalID": "eval-1"}`))
+	case "/v1/job/elApp":
+		if !f.existing {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"ID": "elApp"}`))
+	case "/v1/job/elApp/scale":
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.scaled = append(f.scaled, body)
+		w.Write([]byte(`{"EvalID": "eval-1"}`))
+	case "/v1/job/elApp/allocations":
+		w.Write([]byte(`[{"ID": "a1", "ClientStatus": "running"}, {"ID": "a2", "ClientStatus": "complete"}]`))
+	case "/v1/client/allocation/a1/restart":
+		w.Write([]byte(`{}`))
+	case "/v1/evaluation/eval-1":
+		f.evalPolls++
+		if f.evalPolls < 2 {
+			w.Write([]byte(`{"ID": "eval-1", "Status": "pending"}`))
+			return
+		}
+		w.Write([]byte(`{"ID": "eval-1", "Status": "complete", "DeploymentID": "dep-1"}`))
+	case "/v1/deployment/dep-1":
+		w.Write([]byte(`{"ID": "dep-1", "Status": "` + f.deployStatus + `", "StatusDescription": "done"}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestNomadDeployer(url string) *NomadDeployer {
+	dep := NewNomadDeployer(url, "secret").(*NomadDeployer)
+	dep.PollInterval = time.Millisecond
+	dep.Timeout = time.Second
+	return dep
+}
+
+func TestCanDeployJSONJobToNomad(t *testing.T) {
+	api := &fakeNomadAPI{deployStatus: "successful"}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	expected, err := newTestNomadDeployer(server.URL).Deploy([]byte(nomadJobJSON))
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, "elApp", expected.AppId)
+	assert.True(t, expected.NewDeployment, "should be a new job")
+	assert.Equal(t, []string{"dep-1"}, expected.DeploymentIds)
+	assert.Equal(t, "elApp", api.registered["ID"])
+	assert.Equal(t, "secret", api.token)
+	assert.False(t, api.parsed, "should not parse json jobs")
+	assert.Equal(t, 2, api.evalPolls, "should follow the evaluation")
+}
+
+func TestCanDeployHCLJobToNomad(t *testing.T) {
+	api := &fakeNomadAPI{deployStatus: "successful", existing: true}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	expected, err := newTestNomadDeployer(server.URL).Deploy([]byte(nomadJobHCL))
+	assert.Nil(t, err, "should not throw")
+	assert.True(t, api.parsed, "should parse hcl jobs")
+	assert.False(t, expected.NewDeployment, "should be an update")
+}
+
+func TestNomadDeployFailsWhenDeploymentFails(t *testing.T) {
+	api := &fakeNomadAPI{deployStatus: "failed"}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	_, err := newTestNomadDeployer(server.URL).Deploy([]byte(nomadJobJSON))
+	assert.NotNil(t, err, "should fail")
+}
+
+func TestNomadDeployFailsWhenRegistrationIsNotFound(t *testing.T) {
+	api := &fakeNomadAPI{deployStatus: "successful"}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	_, err := newTestNomadDeployer(server.URL + "/elsewhere").Deploy([]byte(nomadJobJSON))
+	assert.NotNil(t, err, "should fail")
+	assert.Contains(t, api.paths, "POST /elsewhere/v1/jobs")
+}
+
+func TestNomadDeployFailsWithoutJobID(t *testing.T) {
+	_, err := newTestNomadDeployer("http://localhost").Deploy([]byte(`{"Name": "nope"}`))
+	assert.NotNil(t, err, "should fail")
+}
+
+func TestCanScaleEveryNomadTaskGroup(t *testing.T) {
+	api := &fakeNomadAPI{deployStatus: "successful"}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	expected, err := newTestNomadDeployer(server.URL).Scale([]byte(nomadGroupsJSON), 4)
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, []string{"dep-1", "dep-1"}, expected.DeploymentIds)
+	assert.Equal(t, 2, len(api.scaled))
+	assert.Equal(t, float64(4), api.scaled[0]["Count"])
+	assert.Equal(t, "worker", api.scaled[1]["Target"].(map[string]interface{})["Group"])
+}
+
+func TestNomadCannotScaleJobWithoutGroups(t *testing.T) {
+	_, err := newTestNomadDeployer("http://localhost").Suspend([]byte(nomadJobJSON))
+	assert.NotNil(t, err, "should fail")
+}
+
+func TestCanRestartRunningNomadAllocations(t *testing.T) {
+	api := &fakeNomadAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	_, err := newTestNomadDeployer(server.URL).Restart([]byte(nomadJobJSON))
+	assert.Nil(t, err, "should not throw")
+	assert.Contains(t, api.paths, "POST /v1/client/allocation/a1/restart")
+	assert.NotContains(t, api.paths, "POST /v1/client/allocation/a2/restart")
+}
@@ -0,0 +1,127 @@
+package deployer
+
+import (
+	"errors"
+
+	marathon "github.com/gambol99/go-marathon"
+)
+
+// running returns the id of the marathon app serving the spec, following the live colour of blue/green apps
+func running(client marathon.Marathon, app *marathon.Application) (string, error) {
+	exists, err := client.HasApplication(app.ID)
+	if err != nil || exists {
+		return app.ID, err
+	}
+	live, _, err := colours(client, app.ID)
+	if err != nil {
+		return "", err
+	}
+	if live == "" {
+		return "", errors.New(app.ID + " is not deployed")
+	}
+	return live, nil
+}
+
+// Scale sets the number of instances of the app and waits for the deployment to complete
+func (dep *MarathonDeployer) Scale(jsonContent []byte, instances int) (*ExpectedDeployment, error) {
+	if instances < 0 {
+		return nil, errors.New("cannot scale to a negative number of instances")
+	}
+	app, err := parseContent(jsonContent)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dep.client()
+	if err != nil {
+		return nil, err
+	}
+	id, err := running(client, app)
+	if err != nil {
+		return nil, err
+	}
+	deploymentID, err := dep.scale(client, id, instances)
+	if err != nil {
+		return nil, err
+	}
+	return &ExpectedDeployment{AppId: id, DeploymentIds: []string{deploymentID}}, nil
+}
+
+// Restart replaces all the tasks of the app and waits for the deployment to complete
+func (dep *MarathonDeployer) Restart(jsonContent []byte) (*ExpectedDeployment, error) {
+	app, err := parseContent(jsonContent)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dep.client()
+	if err != nil {
+		return nil, err
+	}
+	id, err := running(client, app)
+	if err != nil {
+		return nil, err
+	}
+	deployment, err := client.RestartApplication(id, dep.Force)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.WaitOnDeployment(deployment.DeploymentID, dep.Timeout); err != nil {
+		return nil, err
+	}
+	return &ExpectedDeployment{AppId: id, DeploymentIds: []string{deployment.DeploymentID}}, nil
+}
+
+// Suspend scales the app down to 0 instances
+func (dep *MarathonDeployer) Suspend(jsonContent []byte) (*ExpectedDeployment, error) {
+	return dep.Scale(jsonContent, 0)
+}
+
+// IDeploymentCanceller cancels the in-flight deployments of an app
+type IDeploymentCanceller interface {
+	CancelDeployments(jsonContent []byte, rollback bool) (*CancelledDeployment, error)
+}
+
+// CancelledDeployment lists the deployments cancelled for an app and the deployments rolling them back
+type CancelledDeployment struct {
+	AppId     string
+	Cancelled []string
+	Rollbacks []string
+}
+
+// CancelDeployments stops the in-flight deployments of the app. With rollback marathon reverts the
+// changes they made and the rollbacks are awaited, otherwise the deployments are simply deleted.
+func (dep *MarathonDeployer) CancelDeployments(jsonContent []byte, rollback bool) (*CancelledDeployment, error) {
+	app, err := parseContent(jsonContent)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dep.client()
+	if err != nil {
+		return nil, err
+	}
+	id, err := running(client, app)
+	if err != nil {
+		return nil, err
+	}
+	deployments, err := client.ApplicationDeployments(id)
+	if err != nil {
+		return nil, err
+	}
+
+	cancelled := &CancelledDeployment{AppId: id}
+	for _, deployment := range deployments {
+		reverted, err := client.DeleteDeployment(deployment.DeploymentID, !rollback)
+		if err != nil {
+			return nil, err
+		}
+		cancelled.Cancelled = append(cancelled.Cancelled, deployment.DeploymentID)
+		if rollback && reverted != nil && reverted.DeploymentID != "" {
+			cancelled.Rollbacks = append(cancelled.Rollbacks, reverted.DeploymentID)
+		}
+	}
+	for _, rollbackID := range cancelled.Rollbacks {
+		if err := client.WaitOnDeployment(rollbackID, dep.Timeout); err != nil {
+			return nil, err
+		}
+	}
+	return cancelled, nil
+}
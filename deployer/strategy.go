@@ -0,0 +1,345 @@
+package deployer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+
+	marathon "github.com/gambol99/go-marathon"
+)
+
+const (
+	// InPlaceStrategy creates or updates the app directly
+	InPlaceStrategy = "inplace"
+	// BlueGreenStrategy deploys a parallel app and switches traffic to it once healthy
+	BlueGreenStrategy = "bluegreen"
+	// CanaryStrategy runs a few instances of the new version next to the old one until promoted
+	CanaryStrategy = "canary"
+)
+
+const (
+	lbGroupLabel         = "HAPROXY_GROUP"
+	lbDeploymentGroup    = "HAPROXY_DEPLOYMENT_GROUP"
+	lbDeploymentColour   = "HAPROXY_DEPLOYMENT_COLOUR"
+	lbPortLabel          = "HAPROXY_%d_PORT"
+	defaultLbGroup       = "external"
+	canarySuffix         = "-canary"
+	blueSuffix           = "-blue"
+	greenSuffix          = "-green"
+	defaultCanaryTargets = 1
+)
+
+// IRolloutDeployer deploys with progressive strategies that are later promoted or aborted
+type IRolloutDeployer interface {
+	DeployBlueGreen(jsonContent []byte) (*ExpectedDeployment, error)
+	DeployCanary(jsonContent []byte, instances int) (*ExpectedDeployment, error)
+	Promote(strategy string, jsonContent []byte) (*ExpectedDeployment, error)
+	Abort(strategy string, jsonContent []byte) (*ExpectedDeployment, error)
+}
+
+// variant copies the app under a new id. The fixed service ports stay with the live app, marathon assigns
+// the variant its own and marathon-lb keeps serving it on the original ones through the HAPROXY_{n}_PORT labels.
+func variant(app *marathon.Application, id string) (*marathon.Application, error) {
+	content, err := json.Marshal(app)
+	if err != nil {
+		return nil, err
+	}
+	var spec map[string]interface{}
+	if err := json.Unmarshal(content, &spec); err != nil {
+		return nil, err
+	}
+	ports := clearServicePorts(spec)
+	if content, err = json.Marshal(spec); err != nil {
+		return nil, err
+	}
+	copied, err := parseContent(content)
+	if err != nil {
+		return nil, err
+	}
+	copied.ID = id
+	if copied.Labels == nil {
+		copied.Labels = make(map[string]string)
+	}
+	for i, port := range ports {
+		label := fmt.Sprintf(lbPortLabel, i)
+		if _, ok := copied.Labels[label]; !ok && port != 0 {
+			copied.Labels[label] = strconv.Itoa(port)
+		}
+	}
+	return copied, nil
+}
+
+// clearServicePorts zeroes the service ports of a marathon spec, returning them by port index
+func clearServicePorts(spec map[string]interface{}) []int {
+	var ports []int
+	keep := func(i int, port interface{}) {
+		for len(ports) <= i {
+			ports = append(ports, 0)
+		}
+		if value, ok := port.(float64); ok && value != 0 {
+			ports[i] = int(value)
+		}
+	}
+	clear := func(list interface{}, field string) {
+		entries, _ := list.([]interface{})
+		for i, entry := range entries {
+			if mapping, ok := entry.(map[string]interface{}); ok {
+				keep(i, mapping[field])
+				mapping[field] = 0
+			}
+		}
+	}
+
+	if list, ok := spec["ports"].([]interface{}); ok {
+		for i, port := range list {
+			keep(i, port)
+			list[i] = 0
+		}
+	}
+	clear(spec["portDefinitions"], "port")
+	if container, ok := spec["container"].(map[string]interface{}); ok {
+		if docker, ok := container["docker"].(map[string]interface{}); ok {
+			clear(docker["portMappings"], "servicePort")
+		}
+	}
+	return ports
+}
+
+func lbGroupOf(app *marathon.Application) string {
+	if group, ok := app.Labels[lbGroupLabel]; ok && group != "" {
+		return group
+	}
+	return defaultLbGroup
+}
+
+// colours returns the id of the live colour, if any, and the id of the colour to deploy
+func colours(client marathon.Marathon, id string) (string, string, error) {
+	blue, green := id+blueSuffix, id+greenSuffix
+	for _, pair := range [][2]string{{blue, green}, {green, blue}} {
+		exists, err := client.HasApplication(pair[0])
+		if err != nil {
+			return "", "", err
+		}
+		if exists {
+			live, err := client.Application(pair[0])
+			if err != nil {
+				return "", "", err
+			}
+			if live.Instances > 0 {
+				return pair[0], pair[1], nil
+			}
+		}
+	}
+	return "", blue, nil
+}
+
+func (dep *MarathonDeployer) apply(client marathon.Marathon, app *marathon.Application) (*ExpectedDeployment, error) {
+	exists, err := client.HasApplication(app.ID)
+	if err != nil {
+		return nil, err
+	}
+	var deployed *marathon.Application
+	if exists {
+		deployed, err = client.UpdateApplication(app, dep.Force)
+	} else {
+		deployed, err = client.CreateApplication(app, dep.Force)
+	}
+	if err != nil {
+		return nil, err
+	}
+	expected := &ExpectedDeployment{AppId: app.ID, AppVersion: deployed.Version, NewDeployment: !exists, DeploymentIds: deploymentIdsOf(deployed)}
+	for _, id := range expected.DeploymentIds {
+		if err := client.WaitOnDeployment(id, dep.Timeout); err != nil {
+			return nil, err
+		}
+	}
+	return expected, nil
+}
+
+func (dep *MarathonDeployer) scale(client marathon.Marathon, id string, instances int) (string, error) {
+	deployment, err := client.ScaleApplicationInstances(id, instances, dep.Force)
+	if err != nil {
+		return "", err
+	}
+	return deployment.DeploymentID, client.WaitOnDeployment(deployment.DeploymentID, dep.Timeout)
+}
+
+func (dep *MarathonDeployer) remove(client marathon.Marathon, id string) (string, error) {
+	deployment, err := client.DeleteApplication(id)
+	if err != nil {
+		return "", err
+	}
+	return deployment.DeploymentID, client.WaitOnDeployment(deployment.DeploymentID, dep.Timeout)
+}
+
+// DeployBlueGreen deploys the idle colour without traffic, waits for it to be healthy, then moves
+// the marathon-lb labels over and scales the previous colour down to 0. Promote removes the
+// previous colour, Abort scales it back up and removes the new one.
+func (dep *MarathonDeployer) DeployBlueGreen(jsonContent []byte) (*ExpectedDeployment, error) {
+	app, err := parseContent(jsonContent)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dep.client()
+	if err != nil {
+		return nil, err
+	}
+	live, idle, err := colours(client, app.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	next, err := variant(app, idle)
+	if err != nil {
+		return nil, err
+	}
+	next.Labels[lbDeploymentGroup] = path.Base(app.ID)
+	next.Labels[lbDeploymentColour] = idle[len(app.ID)+1:]
+	delete(next.Labels, lbGroupLabel)
+	expected, err := dep.apply(client, next)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.WaitOnApplication(next.ID, dep.Timeout); err != nil {
+		return nil, err
+	}
+
+	next.Labels[lbGroupLabel] = lbGroupOf(app)
+	switched, err := dep.apply(client, next)
+	if err != nil {
+		return nil, err
+	}
+	expected.AppVersion = switched.AppVersion
+	expected.DeploymentIds = append(expected.DeploymentIds, switched.DeploymentIds...)
+
+	if live != "" {
+		id, err := dep.scale(client, live, 0)
+		if err != nil {
+			return nil, err
+		}
+		expected.DeploymentIds = append(expected.DeploymentIds, id)
+	}
+	return expected, nil
+}
+
+// DeployCanary runs the new version as a separate app with the given number of instances next to the current one
+func (dep *MarathonDeployer) DeployCanary(jsonContent []byte, instances int) (*ExpectedDeployment, error) {
+	app, err := parseContent(jsonContent)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dep.client()
+	if err != nil {
+		return nil, err
+	}
+	if instances <= 0 {
+		instances = defaultCanaryTargets
+	}
+	canary, err := variant(app, app.ID+canarySuffix)
+	if err != nil {
+		return nil, err
+	}
+	canary.Instances = instances
+	canary.Labels[lbDeploymentGroup] = path.Base(app.ID)
+	expected, err := dep.apply(client, canary)
+	if err != nil {
+		return nil, err
+	}
+	return expected, client.WaitOnApplication(canary.ID, dep.Timeout)
+}
+
+// Promote completes a blue/green or canary rollout started for the app
+func (dep *MarathonDeployer) Promote(strategy string, jsonContent []byte) (*ExpectedDeployment, error) {
+	app, err := parseContent(jsonContent)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dep.client()
+	if err != nil {
+		return nil, err
+	}
+
+	switch strategy {
+	case CanaryStrategy:
+		expected, err := dep.apply(client, app)
+		if err != nil {
+			return nil, err
+		}
+		id, err := dep.remove(client, app.ID+canarySuffix)
+		if err != nil {
+			return nil, err
+		}
+		expected.DeploymentIds = append(expected.DeploymentIds, id)
+		return expected, nil
+	case BlueGreenStrategy:
+		live, idle, err := colours(client, app.ID)
+		if err != nil {
+			return nil, err
+		}
+		if live == "" {
+			return nil, errors.New("no live colour found for " + app.ID)
+		}
+		expected := &ExpectedDeployment{AppId: live}
+		if exists, err := client.HasApplication(idle); err != nil || !exists {
+			return expected, err
+		}
+		id, err := dep.remove(client, idle)
+		if err != nil {
+			return nil, err
+		}
+		expected.DeploymentIds = []string{id}
+		return expected, nil
+	}
+	return nil, errors.New(strategy + " rollouts cannot be promoted")
+}
+
+// Abort reverts a blue/green or canary rollout started for the app
+func (dep *MarathonDeployer) Abort(strategy string, jsonContent []byte) (*ExpectedDeployment, error) {
+	app, err := parseContent(jsonContent)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dep.client()
+	if err != nil {
+		return nil, err
+	}
+
+	switch strategy {
+	case CanaryStrategy:
+		id, err := dep.remove(client, app.ID+canarySuffix)
+		if err != nil {
+			return nil, err
+		}
+		return &ExpectedDeployment{AppId: app.ID, DeploymentIds: []string{id}}, nil
+	case BlueGreenStrategy:
+		live, previous, err := colours(client, app.ID)
+		if err != nil {
+			return nil, err
+		}
+		if live == "" {
+			return nil, errors.New("no live colour found for " + app.ID)
+		}
+		if exists, err := client.HasApplication(previous); err != nil || !exists {
+			if err == nil {
+				err = errors.New("no previous colour of " + app.ID + " to restore")
+			}
+			return nil, err
+		}
+		instances := app.Instances
+		if instances <= 0 {
+			instances = 1
+		}
+		scaled, err := dep.scale(client, previous, instances)
+		if err != nil {
+			return nil, err
+		}
+		removed, err := dep.remove(client, live)
+		if err != nil {
+			return nil, err
+		}
+		return &ExpectedDeployment{AppId: previous, DeploymentIds: []string{scaled, removed}}, nil
+	}
+	return nil, errors.New(strategy + " rollouts cannot be aborted")
+}
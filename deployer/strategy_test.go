@@ -0,0 +1,265 @@
+package deployer
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	marathon "github.com/gambol99/go-marathon"
+	"github.com/stretchr/testify/assert"
+)
+
+const strategySpec = `{"id": "/web", "instances": 3, "labels": {"HAPROXY_GROUP": "internal"}}`
+
+// fakeClient implements the parts of the marathon client used by the strategies
+type fakeClient struct {
+	marathon.Marathon
+	apps        map[string]*marathon.Application
+	calls       []string
+	deployments []*marathon.DeploymentID
+	forced      bool
+	versions    []string
+}
+
+func newFakeClient(apps ...*marathon.Application) *fakeClient {
+	client := &fakeClient{apps: make(map[string]*marathon.Application)}
+	for _, app := range apps {
+		client.apps[app.ID] = app
+	}
+	return client
+}
+
+func (f *fakeClient) HasApplication(name string) (bool, error) {
+	_, ok := f.apps[name]
+	return ok, nil
+}
+
+func (f *fakeClient) Application(name string) (*marathon.Application, error) {
+	return f.apps[name], nil
+}
+
+func (f *fakeClient) CreateApplication(app *marathon.Application, force bool) (*marathon.Application, error) {
+	f.forced = force
+	f.calls = append(f.calls, "create "+app.ID)
+	f.apps[app.ID] = app
+	return &marathon.Application{ID: app.ID, DeploymentID: []map[string]string{{"id": "c-" + app.ID}}}, nil
+}
+
+func (f *fakeClient) UpdateApplication(app *marathon.Application, force bool) (*marathon.Application, error) {
+	f.forced = force
+	f.calls = append(f.calls, "update "+app.ID)
+	f.apps[app.ID] = app
+	return &marathon.Application{ID: app.ID, DeploymentID: []map[string]string{{"id": "u-" + app.ID}}}, nil
+}
+
+func (f *fakeClient) ScaleApplicationInstances(name string, instances int, force bool) (*marathon.DeploymentID, error) {
+	f.calls = append(f.calls, "scale "+name)
+	f.apps[name].Instances = instances
+	return &marathon.DeploymentID{DeploymentID: "s-" + name}, nil
+}
+
+func (f *fakeClient) DeleteApplication(name string) (*marathon.DeploymentID, error) {
+	f.calls = append(f.calls, "delete "+name)
+	delete(f.apps, name)
+	return &marathon.DeploymentID{DeploymentID: "d-" + name}, nil
+}
+
+func (f *fakeClient) RestartApplication(name string, force bool) (*marathon.DeploymentID, error) {
+	f.calls = append(f.calls, "restart "+name)
+	return &marathon.DeploymentID{DeploymentID: "r-" + name}, nil
+}
+
+func (f *fakeClient) ApplicationDeployments(name string) ([]*marathon.DeploymentID, error) {
+	return f.deployments, nil
+}
+
+func (f *fakeClient) DeleteDeployment(id string, force bool) (*marathon.DeploymentID, error) {
+	f.calls = append(f.calls, fmt.Sprintf("cancel %s %t", id, force))
+	if force {
+		return nil, nil
+	}
+	return &marathon.DeploymentID{DeploymentID: "rb-" + id}, nil
+}
+
+func (f *fakeClient) ApplicationVersions(name string) (*marathon.ApplicationVersions, error) {
+	return &marathon.ApplicationVersions{Versions: f.versions}, nil
+}
+
+func (f *fakeClient) SetApplicationVersion(name string, version *marathon.ApplicationVersion) (*marathon.DeploymentID, error) {
+	f.calls = append(f.calls, "revert "+name+" to "+version.Version)
+	return &marathon.DeploymentID{DeploymentID: "v-" + name}, nil
+}
+
+func (f *fakeClient) WaitOnDeployment(id string, timeout time.Duration) error {
+	return nil
+}
+
+func (f *fakeClient) WaitOnApplication(name string, timeout time.Duration) error {
+	return nil
+}
+
+func TestBlueGreenDeploysIdleColourAndSwitchesTraffic(t *testing.T) {
+	client := newFakeClient(&marathon.Application{ID: "/web-blue", Instances: 3, Labels: map[string]string{"HAPROXY_GROUP": "internal"}})
+	dep := &MarathonDeployer{Client: client}
+
+	expected, err := dep.DeployBlueGreen([]byte(strategySpec))
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, "/web-green", expected.AppId)
+	assert.Equal(t, []string{"create /web-green", "update /web-green", "scale /web-blue"}, client.calls)
+	assert.Equal(t, "internal", client.apps["/web-green"].Labels["HAPROXY_GROUP"])
+	assert.Equal(t, "green", client.apps["/web-green"].Labels["HAPROXY_DEPLOYMENT_COLOUR"])
+	assert.Equal(t, 0, client.apps["/web-blue"].Instances)
+	assert.Equal(t, []string{"c-/web-green", "u-/web-green", "s-/web-blue"}, expected.DeploymentIds)
+}
+
+func TestBlueGreenStartsWithBlue(t *testing.T) {
+	client := newFakeClient()
+	dep := &MarathonDeployer{Client: client}
+
+	expected, err := dep.DeployBlueGreen([]byte(strategySpec))
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, "/web-blue", expected.AppId)
+	assert.Equal(t, []string{"create /web-blue", "update /web-blue"}, client.calls)
+}
+
+func TestBlueGreenPromoteRemovesPreviousColour(t *testing.T) {
+	client := newFakeClient(
+		&marathon.Application{ID: "/web-blue", Instances: 0},
+		&marathon.Application{ID: "/web-green", Instances: 3})
+	dep := &MarathonDeployer{Client: client}
+
+	_, err := dep.Promote(BlueGreenStrategy, []byte(strategySpec))
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, []string{"delete /web-blue"}, client.calls)
+}
+
+func TestBlueGreenAbortRestoresPreviousColour(t *testing.T) {
+	client := newFakeClient(
+		&marathon.Application{ID: "/web-blue", Instances: 0},
+		&marathon.Application{ID: "/web-green", Instances: 3})
+	dep := &MarathonDeployer{Client: client}
+
+	_, err := dep.Abort(BlueGreenStrategy, []byte(strategySpec))
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, []string{"scale /web-blue", "delete /web-green"}, client.calls)
+	assert.Equal(t, 3, client.apps["/web-blue"].Instances)
+}
+
+func TestCanaryRunsNextToCurrentApp(t *testing.T) {
+	client := newFakeClient(&marathon.Application{ID: "/web", Instances: 3})
+	dep := &MarathonDeployer{Client: client}
+
+	expected, err := dep.DeployCanary([]byte(strategySpec), 2)
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, "/web-canary", expected.AppId)
+	assert.Equal(t, 2, client.apps["/web-canary"].Instances)
+	assert.Equal(t, 3, client.apps["/web"].Instances)
+}
+
+func TestVariantsLeaveServicePortsToTheLiveApp(t *testing.T) {
+	app, _ := parseContent([]byte(`{"id": "/web", "labels": {"HAPROXY_1_PORT": "9000"},
+		"container": {"docker": {"image": "web", "portMappings": [
+			{"containerPort": 8080, "servicePort": 10001}, {"containerPort": 8081, "servicePort": 10002}]}}}`))
+
+	canary, err := variant(app, "/web-canary")
+	assert.Nil(t, err, "should not throw")
+	content, _ := json.Marshal(canary)
+	assert.Contains(t, string(content), `"servicePort":0`)
+	assert.NotContains(t, string(content), `"servicePort":10001`)
+	assert.Equal(t, "10001", canary.Labels["HAPROXY_0_PORT"])
+	assert.Equal(t, "9000", canary.Labels["HAPROXY_1_PORT"])
+	assert.Equal(t, "9000", app.Labels["HAPROXY_1_PORT"])
+	assert.Empty(t, app.Labels["HAPROXY_0_PORT"], "should not change the live app")
+}
+
+func TestCanaryPromoteUpdatesAppAndRemovesCanary(t *testing.T) {
+	client := newFakeClient(&marathon.Application{ID: "/web"}, &marathon.Application{ID: "/web-canary"})
+	dep := &MarathonDeployer{Client: client}
+
+	_, err := dep.Promote(CanaryStrategy, []byte(strategySpec))
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, []string{"update /web", "delete /web-canary"}, client.calls)
+	assert.Equal(t, 3, client.apps["/web"].Instances)
+}
+
+func TestCanaryAbortRemovesCanary(t *testing.T) {
+	client := newFakeClient(&marathon.Application{ID: "/web"}, &marathon.Application{ID: "/web-canary"})
+	dep := &MarathonDeployer{Client: client}
+
+	_, err := dep.Abort(CanaryStrategy, []byte(strategySpec))
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, []string{"delete /web-canary"}, client.calls)
+}
+
+func TestInPlaceRolloutsCannotBePromoted(t *testing.T) {
+	dep := &MarathonDeployer{Client: newFakeClient()}
+	_, err := dep.Promote(InPlaceStrategy, []byte(strategySpec))
+	assert.NotNil(t, err, "should fail")
+}
+
+func TestCanScaleAndRestartMarathonApp(t *testing.T) {
+	client := newFakeClient(&marathon.Application{ID: "/web", Instances: 3})
+	dep := &MarathonDeployer{Client: client}
+
+	scaled, err := dep.Scale([]byte(strategySpec), 5)
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, []string{"s-/web"}, scaled.DeploymentIds)
+	assert.Equal(t, 5, client.apps["/web"].Instances)
+
+	restarted, err := dep.Restart([]byte(strategySpec))
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, []string{"r-/web"}, restarted.DeploymentIds)
+
+	_, err = dep.Suspend([]byte(strategySpec))
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, 0, client.apps["/web"].Instances)
+}
+
+func TestScalesLiveColourOfBlueGreenApp(t *testing.T) {
+	client := newFakeClient(&marathon.Application{ID: "/web-green", Instances: 3})
+	dep := &MarathonDeployer{Client: client}
+
+	scaled, err := dep.Scale([]byte(strategySpec), 1)
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, "/web-green", scaled.AppId)
+	assert.Equal(t, 1, client.apps["/web-green"].Instances)
+}
+
+func TestCannotScaleAppThatIsNotDeployed(t *testing.T) {
+	_, err := (&MarathonDeployer{Client: newFakeClient()}).Restart([]byte(strategySpec))
+	assert.NotNil(t, err, "should fail")
+}
+
+func TestCanRollBackInFlightDeployments(t *testing.T) {
+	client := newFakeClient(&marathon.Application{ID: "/web"})
+	client.deployments = []*marathon.DeploymentID{{DeploymentID: "d1"}, {DeploymentID: "d2"}}
+	dep := &MarathonDeployer{Client: client}
+
+	cancelled, err := dep.CancelDeployments([]byte(strategySpec), true)
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, []string{"d1", "d2"}, cancelled.Cancelled)
+	assert.Equal(t, []string{"rb-d1", "rb-d2"}, cancelled.Rollbacks)
+	assert.Equal(t, []string{"cancel d1 false", "cancel d2 false"}, client.calls)
+}
+
+func TestCanDeleteInFlightDeploymentsWithoutRollback(t *testing.T) {
+	client := newFakeClient(&marathon.Application{ID: "/web"})
+	client.deployments = []*marathon.DeploymentID{{DeploymentID: "d1"}}
+	dep := &MarathonDeployer{Client: client}
+
+	cancelled, err := dep.CancelDeployments([]byte(strategySpec), false)
+	assert.Nil(t, err, "should not throw")
+	assert.Equal(t, []string{"d1"}, cancelled.Cancelled)
+	assert.Empty(t, cancelled.Rollbacks)
+	assert.Equal(t, []string{"cancel d1 true"}, client.calls)
+}
+
+func TestForceIsPassedToMarathon(t *testing.T) {
+	client := newFakeClient(&marathon.Application{ID: "/web"})
+	dep := &MarathonDeployer{Client: client, Force: true}
+
+	_, err := dep.Deploy([]byte(strategySpec))
+	assert.Nil(t, err, "should not throw")
+	assert.True(t, client.forced, "should force the update")
+}
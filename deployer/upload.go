@@ -2,26 +2,57 @@ package deployer
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"sync"
+	"time"
 
 	marathon "github.com/gambol99/go-marathon"
-	"github.com/golang/glog"
-	//"time"
+)
+
+const (
+	// MarathonBackend identifies the marathon deployer
+	MarathonBackend = "marathon"
+	// KubernetesBackend identifies the kubernetes deployer
+	KubernetesBackend = "kubernetes"
+	// NomadBackend identifies the nomad deployer
+	NomadBackend = "nomad"
+	// DockerBackend identifies the local docker engine deployer
+	DockerBackend = "docker"
 )
 
 //IDeployer deploys application
 type IDeployer interface {
 	Deploy(jsonContent []byte) (*ExpectedDeployment, error)
+	Scale(jsonContent []byte, instances int) (*ExpectedDeployment, error)
+	Restart(jsonContent []byte) (*ExpectedDeployment, error)
+	Suspend(jsonContent []byte) (*ExpectedDeployment, error)
 }
 
 //MarathonDeployer deploys marathon apps
 type MarathonDeployer struct {
-	URL string
+	URL            string
+	Username       string
+	Password       string
+	DCOSToken      string
+	CACert         string
+	RequestTimeout time.Duration
+	Timeout        time.Duration
+	PollInterval   time.Duration
+	Force          bool
+	LogOutput      io.Writer
+	Client         marathon.Marathon
+
+	mu         sync.Mutex
+	httpClient *http.Client
 }
 
 // ExpectedDeployment expected marathon deployment
 type ExpectedDeployment struct {
 	AppId         string
+	AppVersion    string
 	NewDeployment bool
 	DeploymentIds []string
 }
@@ -32,64 +63,62 @@ func parseContent(jsonContent []byte) (res *marathon.Application, err error) {
 	return
 }
 
-func createNewApplication(client marathon.Marathon, app *marathon.Application) (deployed *ExpectedDeployment, err error) {
+func deploymentIdsOf(app *marathon.Application) []string {
+	deps := app.DeploymentID
+	ln := len(deps)
+	ids := make([]string, ln, ln)
+	for i, el := range deps {
+		ids[i] = el["id"]
+	}
+	return ids
+}
+
+func createNewApplication(client marathon.Marathon, app *marathon.Application, force bool) (deployed *ExpectedDeployment, err error) {
 	deployed = &ExpectedDeployment{}
-	if created, err := client.CreateApplication(app, false); err == nil {
+	var created *marathon.Application
+	if created, err = client.CreateApplication(app, force); err == nil {
 		deployed.AppId = created.ID
+		deployed.AppVersion = created.Version
 		deployed.NewDeployment = true
-		deps := created.DeploymentID
-		ln := len(deps)
-		deployed.DeploymentIds = make([]string, ln, ln)
-		for i, el := range deps {
-			deployed.DeploymentIds[i] = el["id"]
-		}
+		deployed.DeploymentIds = deploymentIdsOf(created)
 	}
 
 	return
 }
 
-func updateApplication(client marathon.Marathon, app *marathon.Application) (updated *ExpectedDeployment, err error) {
+func updateApplication(client marathon.Marathon, app *marathon.Application, force bool) (updated *ExpectedDeployment, err error) {
 	updated = &ExpectedDeployment{}
-	if updatedApp, err := client.UpdateApplication(app, false); err == nil {
+	var updatedApp *marathon.Application
+	if updatedApp, err = client.UpdateApplication(app, force); err == nil {
 		updated.AppId = app.ID
+		updated.AppVersion = updatedApp.Version
 		updated.NewDeployment = false
-
-		deps := updatedApp.DeploymentID
-		ln := len(deps)
-		updated.DeploymentIds = make([]string, ln, ln)
-		for i, el := range deps {
-			updated.DeploymentIds[i] = el["id"]
-		}
+		updated.DeploymentIds = deploymentIdsOf(updatedApp)
 	}
 	return
 }
 
 // NewDeployer iniitializes a deployer
 func NewDeployer(url string) IDeployer {
-	return &MarathonDeployer{URL: url}
+	return &MarathonDeployer{URL: url, Timeout: 5 * time.Minute, PollInterval: 2 * time.Second, LogOutput: os.Stdout}
 }
 
 //Deploy deploys the marathon app
 func (dep *MarathonDeployer) Deploy(jsonContent []byte) (*ExpectedDeployment, error) {
-	if app, err := parseContent(jsonContent); err == nil {
-		config := marathon.NewDefaultConfig()
-		config.URL = dep.URL
-		config.LogOutput = os.Stdout
-		if client, err := marathon.NewClient(config); err == nil {
-			if alreadyExists, err := client.HasApplication(app.ID); err == nil && alreadyExists {
-				return updateApplication(client, app)
-			} else if err == nil && !alreadyExists {
-				return createNewApplication(client, app)
-			} else {
-				return nil, err
-			}
-
-		} else {
-
-			glog.Fatalf("Failed to create a client for marathon, error: %s", err)
-			return nil, err
-		}
-
+	app, err := parseContent(jsonContent)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dep.client()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a client for marathon: %v", err)
+	}
+	alreadyExists, err := client.HasApplication(app.ID)
+	if err != nil {
+		return nil, err
+	}
+	if alreadyExists {
+		return updateApplication(client, app, dep.Force)
 	}
-	return nil, nil
+	return createNewApplication(client, app, dep.Force)
 }
@@ -112,3 +112,8 @@ func TestCanProduceApplicationFromJson(t *testing.T) {
 
 	assert.Equal(t, "elApp", app.ID, "should have the same id")
 }
+
+func TestDeployFailsOnInvalidSpec(t *testing.T) {
+	_, err := NewDeployer("http://localhost").Deploy([]byte(`{"id": `))
+	assert.NotNil(t, err, "should fail")
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+
+	"github.com/bhameyie/dpipeliner/deployer"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Environment is a named deployment target with its own marathon endpoint and spec overlay.
+// Environments are promoted in the order they are declared.
+type Environment struct {
+	Name     string                 `yaml:"name"`
+	Marathon string                 `yaml:"marathon"`
+	Overlay  map[string]interface{} `yaml:"overlay"`
+	Deployer deployer.IDeployer     `yaml:"-"`
+}
+
+// LoadEnvironments reads the ordered list of environments from a yaml file
+func LoadEnvironments(file string) ([]Environment, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var envs []Environment
+	if err := yaml.Unmarshal(b, &envs); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, env := range envs {
+		if env.Name == "" || env.Marathon == "" {
+			return nil, errors.New("environments need a name and a marathon endpoint")
+		}
+		if seen[env.Name] {
+			return nil, errors.New("environment " + env.Name + " is declared twice")
+		}
+		seen[env.Name] = true
+	}
+	return envs, nil
+}
+
+func (c *Controller) environment(name string) int {
+	for i, env := range c.Environments {
+		if env.Name == name {
+			return i
+		}
+	}
+	return -1
+}
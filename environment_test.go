@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "gopkg.in/check.v1"
+)
+
+type EnvironmentSuite struct{}
+
+var _ = Suite(&EnvironmentSuite{})
+
+const environmentsFile = "environments.test.yml"
+
+func (s *EnvironmentSuite) TearDownTest(c *C) {
+	os.Remove(environmentsFile)
+}
+
+func (s *EnvironmentSuite) TestCanLoadEnvironmentsInOrder(c *C) {
+	content := `
+- name: staging
+  marathon: http://staging:8080
+- name: production
+  marathon: http://prod1:8080,http://prod2:8080
+  overlay:
+    instances: 4
+    env:
+      LOG: warn
+`
+	c.Assert(ioutil.WriteFile(environmentsFile, []byte(content), 0644), IsNil)
+	envs, err := LoadEnvironments(environmentsFile)
+	c.Assert(err, IsNil)
+	c.Assert(len(envs), Equals, 2)
+	c.Assert(envs[0].Name, Equals, "staging")
+	c.Assert(envs[1].Marathon, Equals, "http://prod1:8080,http://prod2:8080")
+	c.Assert(envs[1].Overlay["instances"], Equals, 4)
+}
+
+func (s *EnvironmentSuite) TestCannotLoadDuplicateOrIncompleteEnvironments(c *C) {
+	c.Assert(ioutil.WriteFile(environmentsFile, []byte("- name: staging\n"), 0644), IsNil)
+	_, err := LoadEnvironments(environmentsFile)
+	c.Assert(err, NotNil)
+
+	dup := "- {name: a, marathon: m}\n- {name: a, marathon: n}\n"
+	c.Assert(ioutil.WriteFile(environmentsFile, []byte(dup), 0644), IsNil)
+	_, err = LoadEnvironments(environmentsFile)
+	c.Assert(err, NotNil)
+}
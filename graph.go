@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	outcomeDeployed = "deployed"
+	outcomeFailed   = "failed"
+	outcomeSkipped  = "skipped"
+	outcomeCycle    = "cycle"
+	outcomeRefused  = "refused"
+)
+
+// deployNode is a snapshot candidate placed in the dependency graph of the snapshot
+type deployNode struct {
+	service      string
+	version      string
+	appID        string
+	dependencies []*deployNode
+	outcome      string
+	err          error
+}
+
+// specDependencies reads the id and dependencies of a marathon spec, relative dependencies being
+// resolved against the group of the app. Specs of other backends have none.
+func specDependencies(content []byte) (string, []string) {
+	app := struct {
+		ID           string   `json:"id"`
+		Dependencies []string `json:"dependencies"`
+	}{}
+	if err := json.Unmarshal(content, &app); err != nil || app.ID == "" {
+		return "", nil
+	}
+	id := "/" + strings.Trim(app.ID, "/")
+	var deps []string
+	for _, dep := range app.Dependencies {
+		if !strings.HasPrefix(dep, "/") {
+			dep = path.Join(path.Dir(id), dep)
+		}
+		deps = append(deps, path.Clean(dep))
+	}
+	return id, deps
+}
+
+// reaches tells whether target can be reached from the node by following dependencies
+func (n *deployNode) reaches(target *deployNode, visited map[*deployNode]bool) bool {
+	for _, dep := range n.dependencies {
+		if dep == target {
+			return true
+		}
+		if !visited[dep] {
+			visited[dep] = true
+			if dep.reaches(target, visited) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// linkDependencies connects the nodes through the dependencies of their specs, ignoring the apps that
+// are not part of the snapshot, and marks the nodes that are part of a cycle
+func linkDependencies(nodes []*deployNode, dependencies map[*deployNode][]string) {
+	byApp := make(map[string]*deployNode)
+	for _, n := range nodes {
+		if n.appID != "" {
+			byApp[n.appID] = n
+		}
+	}
+	for _, n := range nodes {
+		for _, dep := range dependencies[n] {
+			if target, ok := byApp[dep]; ok && target != n {
+				n.dependencies = append(n.dependencies, target)
+			}
+		}
+	}
+	for _, n := range nodes {
+		if n.outcome == "" && n.reaches(n, make(map[*deployNode]bool)) {
+			n.outcome = outcomeCycle
+			n.err = errors.New(n.appID + " is part of a dependency cycle")
+		}
+	}
+}
+
+// deployInOrder deploys the nodes once their dependencies are deployed, running at most parallel
+// deployments at a time. The dependents of a node that is not deployed are skipped.
+func deployInOrder(nodes []*deployNode, parallel int, deploy func(service, version string) error) {
+	if parallel < 1 {
+		parallel = 1
+	}
+	waiting := make(map[*deployNode]int)
+	dependents := make(map[*deployNode][]*deployNode)
+	for _, n := range nodes {
+		waiting[n] = len(n.dependencies)
+		for _, dep := range n.dependencies {
+			dependents[dep] = append(dependents[dep], n)
+		}
+	}
+
+	var queue []*deployNode
+	var settle func(n *deployNode)
+	settle = func(n *deployNode) {
+		for _, dependent := range dependents[n] {
+			if dependent.outcome != "" {
+				continue
+			}
+			if n.outcome != outcomeDeployed {
+				dependent.outcome = outcomeSkipped
+				dependent.err = errors.New("dependency " + n.service + " was not deployed")
+				settle(dependent)
+				continue
+			}
+			waiting[dependent]--
+			if waiting[dependent] == 0 {
+				queue = append(queue, dependent)
+			}
+		}
+	}
+	for _, n := range nodes {
+		if n.outcome != "" {
+			settle(n)
+		} else if waiting[n] == 0 {
+			queue = append(queue, n)
+		}
+	}
+
+	done := make(chan *deployNode)
+	running := 0
+	for len(queue) > 0 || running > 0 {
+		for len(queue) > 0 && running < parallel {
+			n := queue[0]
+			queue = queue[1:]
+			running++
+			go func(n *deployNode) {
+				n.err = deploy(n.service, n.version)
+				done <- n
+			}(n)
+		}
+		n := <-done
+		running--
+		n.outcome = outcomeDeployed
+		if n.err != nil {
+			n.outcome = outcomeFailed
+		}
+		settle(n)
+	}
+}
+
+// summarize prints the outcome of every node and reports whether any of them was not deployed
+func summarize(nodes []*deployNode) error {
+	failed := 0
+	fmt.Println("Snapshot deployment summary:")
+	for _, n := range nodes {
+		line := fmt.Sprintf("  %-10s %s %s", n.outcome, n.service, n.version)
+		if n.err != nil {
+			line += ": " + n.err.Error()
+			failed++
+		}
+		fmt.Println(line)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d services were not deployed", failed, len(nodes))
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type GraphSuite struct{}
+
+var _ = Suite(&GraphSuite{})
+
+func graphOf(specs map[string]string, order ...string) []*deployNode {
+	var nodes []*deployNode
+	dependencies := make(map[*deployNode][]string)
+	for _, service := range order {
+		n := &deployNode{service: service, version: "1"}
+		n.appID, dependencies[n] = specDependencies([]byte(specs[service]))
+		nodes = append(nodes, n)
+	}
+	linkDependencies(nodes, dependencies)
+	return nodes
+}
+
+func outcomes(nodes []*deployNode) map[string]string {
+	result := make(map[string]string)
+	for _, n := range nodes {
+		result[n.service] = n.outcome
+	}
+	return result
+}
+
+func (s *GraphSuite) TestResolvesRelativeDependencies(c *C) {
+	id, deps := specDependencies([]byte(`{"id": "shop/web", "dependencies": ["api", "../db", "/cache"]}`))
+	c.Assert(id, Equals, "/shop/web")
+	c.Assert(deps, DeepEquals, []string{"/shop/api", "/db", "/cache"})
+
+	id, deps = specDependencies([]byte("kind: Deployment"))
+	c.Assert(id, Equals, "")
+	c.Assert(len(deps), Equals, 0)
+}
+
+func (s *GraphSuite) TestFailureHaltsOnlyItsBranch(c *C) {
+	nodes := graphOf(map[string]string{
+		"db":    `{"id": "/db"}`,
+		"api":   `{"id": "/api", "dependencies": ["/db"]}`,
+		"web":   `{"id": "/web", "dependencies": ["/api"]}`,
+		"cache": `{"id": "/cache"}`,
+	}, "web", "api", "db", "cache")
+
+	var mu sync.Mutex
+	var deployed []string
+	deployInOrder(nodes, 2, func(service, version string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		deployed = append(deployed, service)
+		if service == "api" {
+			return errors.New("unhealthy")
+		}
+		return nil
+	})
+
+	c.Assert(len(deployed), Equals, 3)
+	c.Assert(outcomes(nodes), DeepEquals, map[string]string{
+		"db": outcomeDeployed, "api": outcomeFailed, "web": outcomeSkipped, "cache": outcomeDeployed,
+	})
+	c.Assert(summarize(nodes), NotNil)
+}
+
+func (s *GraphSuite) TestCycleHaltsOnlyItsBranch(c *C) {
+	nodes := graphOf(map[string]string{
+		"a":   `{"id": "/a", "dependencies": ["/b"]}`,
+		"b":   `{"id": "/b", "dependencies": ["/a"]}`,
+		"c":   `{"id": "/c", "dependencies": ["/a"]}`,
+		"web": `{"id": "/web"}`,
+	}, "a", "b", "c", "web")
+
+	deployInOrder(nodes, 1, func(service, version string) error { return nil })
+
+	c.Assert(outcomes(nodes), DeepEquals, map[string]string{
+		"a": outcomeCycle, "b": outcomeCycle, "c": outcomeSkipped, "web": outcomeDeployed,
+	})
+}
+
+func (s *GraphSuite) TestLimitsParallelDeployments(c *C) {
+	specs := map[string]string{}
+	order := []string{"a", "b", "c", "d", "e"}
+	for _, service := range order {
+		specs[service] = `{"id": "/` + service + `"}`
+	}
+	nodes := graphOf(specs, order...)
+
+	var mu sync.Mutex
+	running, peak := 0, 0
+	deployInOrder(nodes, 2, func(service, version string) error {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+
+	c.Assert(peak, Equals, 2)
+	c.Assert(summarize(nodes), IsNil)
+}
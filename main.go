@@ -4,11 +4,15 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/bhameyie/dpipeliner/composition"
 	"github.com/bhameyie/dpipeliner/data"
 	"github.com/bhameyie/dpipeliner/deployer"
+	"github.com/bhameyie/dpipeliner/spec"
 )
 
 func ensureValidSpec(serviceName, serviceVersion string) error {
@@ -25,6 +29,48 @@ func notNegative(s, e string) error {
 	return nil
 }
 
+// keyValues collects repeated key=value flags
+type keyValues map[string]string
+
+func (kv keyValues) String() string {
+	var pairs []string
+	for k, v := range kv {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (kv keyValues) Set(value string) error {
+	pair := strings.SplitN(value, "=", 2)
+	if len(pair) != 2 {
+		return errors.New(value + " is not of the form key=value")
+	}
+	kv[pair[0]] = pair[1]
+	return nil
+}
+
+// fileList collects repeated file flags in order
+type fileList []string
+
+func (fl *fileList) String() string {
+	return strings.Join(*fl, ",")
+}
+
+func (fl *fileList) Set(value string) error {
+	*fl = append(*fl, value)
+	return nil
+}
+
+func splitList(s string) []string {
+	var res []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
+
 func fileExists(f string) bool {
 	_, err := os.Stat(f)
 	return err == nil
@@ -32,8 +78,21 @@ func fileExists(f string) bool {
 
 func main() {
 
-	modePtr := flag.String("mode", "deploy", "e.g. deploy, init_test, complete_state, compose")
-	marathonPtr := flag.String("marathon", "-1", "marathon host")
+	modePtr := flag.String("mode", "deploy", "e.g. deploy, init_test, complete_state, compose, compose_baseline, accept_snapshot, reject_snapshot, promote, promote_rollout, abort_rollout, scale, restart, suspend, cancel_deployment, deployments, find_build")
+	marathonPtr := flag.String("marathon", "-1", "marathon host, or comma separated list of masters")
+	marathonUser := flag.String("marathon-user", "", "user for marathon basic auth")
+	marathonPassword := flag.String("marathon-password", "", "password for marathon basic auth")
+	dcosToken := flag.String("dcos-token", "", "DC/OS authentication token for marathon")
+	marathonCA := flag.String("marathon-ca", "", "PEM file of the CA used to verify marathon")
+	marathonTimeout := flag.Duration("marathon-timeout", 30*time.Second, "timeout of each request to marathon")
+	kubernetesPtr := flag.String("kubernetes", "-1", "kubernetes api server")
+	kubeToken := flag.String("kube-token", "", "bearer token for the kubernetes api server")
+	kubeNamespace := flag.String("kube-namespace", "default", "namespace used for manifests without one")
+	nomadPtr := flag.String("nomad", "-1", "nomad agent address")
+	nomadToken := flag.String("nomad-token", "", "ACL token for the nomad agent")
+	dockerPtr := flag.String("docker", "-1", "docker engine host, e.g. unix:///var/run/docker.sock")
+	deployTimeout := flag.Duration("deploy-timeout", 5*time.Minute, "how long to wait for a rollout to complete")
+	smokeTimeout := flag.Duration("smoke-timeout", 10*time.Second, "timeout of each smoke check probe")
 	mongoPtr := flag.String("mongo", "-1", "location of mongo server")
 	file := flag.String("file", "marathon.spec.js", "location of spec file for attach_spec mode")
 
@@ -42,6 +101,39 @@ func main() {
 	serviceVersion := flag.String("version", "-1", "service version")
 	stage := flag.String("stage", "-1", "e.g. unit, e2e, deployment")
 	catalog := flag.String("catalog", "-1", "tracked service collection (e.g. fire_trackedservices)")
+	environment := flag.String("env", "", "environment to deploy to (or promote from), also available to spec templates")
+	environments := flag.String("environments", "", "yaml file listing the environments in promotion order")
+	labels := keyValues{}
+	flag.Var(labels, "label", "label available to spec templates as key=value (repeatable)")
+	vars := keyValues{}
+	flag.Var(vars, "var", "variable available to spec templates as key=value (repeatable)")
+	lint := flag.String("lint", strings.Join(spec.DefaultRules, ","), "comma separated lint rules applied by attach_spec")
+	atomic := flag.Bool("atomic", false, "deploy_snapshot as a single marathon group update")
+	group := flag.String("group", "-1", "marathon group used by atomic deployments, holding the snapshot apps (defaults to /<catalog>)")
+	instances := flag.Int("instances", -1, "number of instances for scale mode")
+	appVersion := flag.String("app-version", "-1", "marathon app version looked up by find_build")
+	composerKind := flag.String("composer", "docker-compose", "output of compose mode: docker-compose, or kubernetes manifests of an ephemeral namespace")
+	kubeManifests := flag.String("kube-manifests", "e2e-manifests.yml", "file receiving the kubernetes manifests of compose mode, unless -compose-file is given")
+	kubeE2ENamespace := flag.String("kube-e2e-namespace", "", "namespace of the kubernetes manifests of compose mode (e2e- followed by the start of the snapshot id by default)")
+	outDir := flag.String("out-dir", "", "directory of the snapshot and compose files, the current directory by default")
+	snapshotPath := flag.String("snapshot-file", snapshotFile, "snapshot file written by compose mode and read by the *_snapshot modes, relative to -out-dir")
+	composePath := flag.String("compose-file", "", "file receiving the output of compose mode, relative to -out-dir ("+composerFile+" or -kube-manifests by default)")
+	runID := flag.String("run-id", "", "identifies the run owning the files of -out-dir, the stages of a pipeline share it (ownership is not tracked by default)")
+	signKey := flag.String("snapshot-sign-key", "", "ed25519 private key (PKCS #8 PEM) or HMAC secret file signing the snapshot of compose mode")
+	verifyKey := flag.String("snapshot-verify-key", "", "ed25519 public key (PKIX PEM) or HMAC secret file; when set, snapshots must be signed by the matching key")
+	snapshotID := flag.String("snapshot", "-1", "id (or unique id prefix) of the stored snapshot used by the *_snapshot modes instead of the snapshot file")
+	exportSnapshot := flag.Bool("export-snapshot", true, "compose mode also writes the stored snapshot to the snapshot file")
+	composeBases := &fileList{}
+	flag.Var(composeBases, "compose-base", "compose file the generated services are merged into, later files and then the generated services take precedence (repeatable)")
+	composeFormat := flag.String("compose-format", composition.FormatV1, "docker-compose file format produced by compose mode: 1 (legacy), 2 or 3")
+	rulesFile := flag.String("rules", "", "yaml file of the selection rules of compose mode (pin, exclude, only, fallback)")
+	pins := keyValues{}
+	flag.Var(pins, "pin", "compose the given version of a service as service=version (repeatable)")
+	exclude := flag.String("exclude", "", "comma separated services left out of compose mode")
+	only := flag.String("only", "", "comma separated services compose mode is restricted to")
+	fallback := flag.Bool("fallback", false, "compose mode uses the version deployed to -env of the services without a candidate")
+	parallel := flag.Int("parallel", 1, "number of snapshot services deployed at the same time by deploy_snapshot")
+	force := flag.Bool("force", false, "override marathon deployment locks; cancel_deployment deletes instead of rolling back; overwrite the -out-dir files of another run")
 
 	flag.Parse()
 
@@ -52,17 +144,121 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	newMarathon := func(url string) *deployer.MarathonDeployer {
+		marathon := deployer.NewDeployer(url).(*deployer.MarathonDeployer)
+		marathon.Timeout = *deployTimeout
+		marathon.Username = *marathonUser
+		marathon.Password = *marathonPassword
+		marathon.DCOSToken = *dcosToken
+		marathon.CACert = *marathonCA
+		marathon.RequestTimeout = *marathonTimeout
+		marathon.Force = *force
+		return marathon
+	}
+	composer := &composition.SystemComposer{
+		Catalog:     *catalog,
+		Environment: *environment,
+		Labels:      labels,
+		Vars:        vars,
+		Format:      *composeFormat,
+		Bases:       *composeBases,
+		LogOutput:   os.Stdout,
+	}
 	controller := &Controller{
-		Repo:     repo,
-		Deployer: deployer.NewDeployer(*marathonPtr),
-		Composer: composition.NewComposer(),
+		Repo:        repo,
+		Deployer:    newMarathon(*marathonPtr),
+		Deployers:   make(map[string]deployer.IDeployer),
+		Composer:    composer,
+		Environment: *environment,
+		Labels:      labels,
+		Vars:        vars,
+		LintRules:   splitList(*lint),
+		HTTPClient:  &http.Client{Timeout: *smokeTimeout},
+		Parallel:    *parallel,
+		Workspace: Workspace{
+			Dir:          *outDir,
+			SnapshotFile: *snapshotPath,
+			ComposeFile:  *composePath,
+			RunID:        *runID,
+			Force:        *force,
+		},
+	}
+	if *signKey != "" {
+		if composer.Signer, err = composition.LoadSnapshotSigner(*signKey); err != nil {
+			panic(err)
+		}
+	}
+	if *verifyKey != "" {
+		if controller.SnapshotVerifier, err = composition.LoadSnapshotVerifier(*verifyKey); err != nil {
+			panic(err)
+		}
+	}
+	controller.SkipSnapshotFile = !*exportSnapshot
+	if *snapshotID != "-1" {
+		controller.SnapshotID = *snapshotID
+	}
+	snapshotAvailable := func() bool {
+		return controller.SnapshotID != "" || fileExists(controller.Workspace.SnapshotPath())
+	}
+	missingSnapshot := errors.New(controller.Workspace.SnapshotPath() + " doesnt exist")
+	switch *composerKind {
+	case "docker-compose":
+	case "kubernetes":
+		controller.Composer = &composition.KubernetesComposer{SystemComposer: *composer, Namespace: *kubeE2ENamespace}
+		if controller.Workspace.ComposeFile == "" {
+			controller.Workspace.ComposeFile = *kubeManifests
+		}
+	default:
+		panic("unknown composer " + *composerKind)
+	}
+	if *kubernetesPtr != "-1" {
+		kube := deployer.NewKubernetesDeployer(*kubernetesPtr, *kubeToken, *kubeNamespace).(*deployer.KubernetesDeployer)
+		kube.Timeout = *deployTimeout
+		controller.Deployers[deployer.KubernetesBackend] = kube
+	}
+	if *nomadPtr != "-1" {
+		nomad := deployer.NewNomadDeployer(*nomadPtr, *nomadToken).(*deployer.NomadDeployer)
+		nomad.Timeout = *deployTimeout
+		controller.Deployers[deployer.NomadBackend] = nomad
+	}
+	if *dockerPtr != "-1" {
+		docker := deployer.NewDockerDeployer(*dockerPtr).(*deployer.DockerDeployer)
+		docker.Timeout = *deployTimeout
+		controller.Deployers[deployer.DockerBackend] = docker
 	}
 
+	if *environments != "" {
+		envs, err := LoadEnvironments(*environments)
+		if err != nil {
+			panic(err)
+		}
+		for i := range envs {
+			envs[i].Deployer = newMarathon(envs[i].Marathon)
+			if envs[i].Name == *environment {
+				controller.Deployer = envs[i].Deployer
+			}
+		}
+		controller.Environments = envs
+	}
+
+	if *rulesFile != "" {
+		if controller.Selection, err = LoadSelectionRules(*rulesFile); err != nil {
+			panic(err)
+		}
+	}
+	controller.Selection.Merge(SelectionRules{Pins: pins, Exclude: splitList(*exclude), Only: splitList(*only), Fallback: *fallback})
+
 	defer controller.Dispose()
 
+	groupID := *group
+	if groupID == "-1" {
+		groupID = "/" + *catalog
+	}
+
 	validateSpec := ensureValidSpec(*serviceName, *serviceVersion)
 	validateImage := notNegative(*serviceImage, "invalid image")
 	validateStage := notNegative(*stage, "invalid stage")
+	validateService := notNegative(*serviceName, "invalid service")
 
 	var e error
 	switch *modePtr {
@@ -74,36 +270,131 @@ func main() {
 			e = validateSpec
 		}
 
+	case "promote":
+		fmt.Println("promoting from " + *environment)
+		if validateService == nil {
+			e = controller.PromoteCandidate(*serviceName, *environment)
+		} else {
+			e = validateService
+		}
+
+	case "promote_rollout":
+		fmt.Println("promoting rollout")
+		if validateSpec == nil {
+			e = controller.PromoteRollout(*serviceName, *serviceVersion)
+		} else {
+			e = validateSpec
+		}
+
+	case "abort_rollout":
+		fmt.Println("aborting rollout")
+		if validateSpec == nil {
+			e = controller.AbortRollout(*serviceName, *serviceVersion)
+		} else {
+			e = validateSpec
+		}
+
+	case "scale":
+		fmt.Println("scaling")
+		if validateService != nil {
+			e = validateService
+		} else if *instances < 0 {
+			e = errors.New("invalid instances")
+		} else {
+			e = controller.ScaleService(*serviceName, *serviceVersion, *instances)
+		}
+
+	case "restart":
+		fmt.Println("restarting")
+		if validateService == nil {
+			e = controller.RestartService(*serviceName, *serviceVersion)
+		} else {
+			e = validateService
+		}
+
+	case "suspend":
+		fmt.Println("suspending")
+		if validateService == nil {
+			e = controller.SuspendService(*serviceName, *serviceVersion)
+		} else {
+			e = validateService
+		}
+
+	case "cancel_deployment":
+		fmt.Println("cancelling deployments")
+		if validateService == nil {
+			e = controller.CancelDeployments(*serviceName, *serviceVersion, !*force)
+		} else {
+			e = validateService
+		}
+
+	case "deployments":
+		if validateSpec == nil {
+			e = controller.ShowDeployments(*serviceName, *serviceVersion)
+		} else {
+			e = validateSpec
+		}
+
+	case "find_build":
+		if validateService != nil {
+			e = validateService
+		} else if *appVersion == "-1" {
+			e = errors.New("invalid app version")
+		} else {
+			e = controller.FindBuildOf(*serviceName, *appVersion)
+		}
+
 	case "compose":
 		fmt.Println("composing")
 		e = controller.ProduceCompositionAndSnapshotFiles()
 
+	case "compose_baseline":
+		fmt.Println("composing baseline")
+		if validateSpec == nil {
+			e = controller.ProduceBaselineComposition(*serviceName, *serviceVersion)
+		} else {
+			e = validateSpec
+		}
+
 	case "complete_snapshot":
-		if fileExists(snapshotFile) {
+		if snapshotAvailable() {
 			e = controller.CompleteCandidateSnapshot()
 			if e == nil {
 				e = controller.ChangeCandidateState("Deployed")
 			}
 		} else {
-			e = errors.New(snapshotFile + " doesnt exist")
+			e = missingSnapshot
 		}
 
 	case "deploy_snapshot":
-		if fileExists(snapshotFile) {
-			e = controller.DeploySnapshot()
+		if !snapshotAvailable() {
+			e = missingSnapshot
+		} else if *atomic {
+			e = controller.DeploySnapshotAsGroup(groupID)
 		} else {
-			e = errors.New(snapshotFile + " doesnt exist")
+			e = controller.DeploySnapshot()
 		}
 
+	case "rollback_snapshot":
+		fmt.Println("rolling back " + groupID)
+		e = controller.RollbackSnapshotGroup(groupID)
+
 	case "accept_snapshot":
-		if fileExists(snapshotFile) {
+		if snapshotAvailable() {
 			e = controller.AcceptCandidateSnapshot()
 		} else {
-			e = errors.New(snapshotFile + " doesnt exist")
+			e = missingSnapshot
+		}
+
+	case "reject_snapshot":
+		if snapshotAvailable() {
+			e = controller.RejectCandidateSnapshot()
+		} else {
+			e = missingSnapshot
 		}
 
 	case "attach_spec":
-		fmt.Println("Attaching marathon spec")
+		fmt.Println("Attaching spec")
 		if fileExists(*file) {
 			e = controller.AssignMarathonSpecificationFor(*serviceName, *serviceVersion, *file)
 		} else {
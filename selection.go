@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/bhameyie/dpipeliner/data"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	selectedCandidate = "candidate"
+	selectedPinned    = "pinned"
+	selectedDeployed  = "deployed"
+)
+
+// SelectionRules narrow down the candidates composed for E2E testing. Pins replace the candidates of a
+// service by the given version, Exclude and Only filter services by name, and Fallback composes the version
+// deployed to the environment of the services left without a candidate.
+type SelectionRules struct {
+	Pins     map[string]string `yaml:"pin"`
+	Exclude  []string          `yaml:"exclude"`
+	Only     []string          `yaml:"only"`
+	Fallback bool              `yaml:"fallback"`
+}
+
+// LoadSelectionRules reads the selection rules from a yaml file
+func LoadSelectionRules(file string) (SelectionRules, error) {
+	rules := SelectionRules{}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return rules, err
+	}
+	if err := yaml.UnmarshalStrict(b, &rules); err != nil {
+		return rules, errors.New(file + ": " + err.Error())
+	}
+	return rules, nil
+}
+
+// Merge adds the rules of other, its pins taking precedence
+func (r *SelectionRules) Merge(other SelectionRules) {
+	for service, version := range other.Pins {
+		if r.Pins == nil {
+			r.Pins = make(map[string]string)
+		}
+		r.Pins[service] = version
+	}
+	r.Exclude = append(r.Exclude, other.Exclude...)
+	r.Only = append(r.Only, other.Only...)
+	r.Fallback = r.Fallback || other.Fallback
+}
+
+func (r SelectionRules) validate() error {
+	for _, service := range r.Exclude {
+		if _, ok := r.Pins[service]; ok {
+			return errors.New(service + " is both pinned and excluded")
+		}
+	}
+	if len(r.Only) == 0 {
+		return nil
+	}
+	only := setOf(r.Only)
+	for service := range r.Pins {
+		if !only[service] {
+			return errors.New(service + " is pinned but not part of the only services")
+		}
+	}
+	return nil
+}
+
+// selects tells whether the service is part of the composition
+func (r SelectionRules) selects(service string) bool {
+	if setOf(r.Exclude)[service] {
+		return false
+	}
+	return len(r.Only) == 0 || setOf(r.Only)[service]
+}
+
+func setOf(items []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
+
+type selectedCandidates struct {
+	candidates []data.DeploymentCandidate
+	reasons    []string
+}
+
+func (s *selectedCandidates) add(candidate data.DeploymentCandidate, reason string) {
+	s.candidates = append(s.candidates, candidate)
+	s.reasons = append(s.reasons, reason)
+}
+
+func (s *selectedCandidates) has(service string) bool {
+	for _, candidate := range s.candidates {
+		if candidate.ServiceName == service {
+			return true
+		}
+	}
+	return false
+}
+
+// selectCandidates applies the Selection rules to the candidates awaiting E2E testing
+func (c *Controller) selectCandidates(candidates []data.DeploymentCandidate) ([]data.DeploymentCandidate, error) {
+	return c.applyRules(c.Selection, candidates)
+}
+
+// applyRules selects the candidates following the rules and prints the resolved set
+func (c *Controller) applyRules(rules SelectionRules, candidates []data.DeploymentCandidate) ([]data.DeploymentCandidate, error) {
+	if err := rules.validate(); err != nil {
+		return nil, err
+	}
+
+	selected := &selectedCandidates{}
+	for _, candidate := range candidates {
+		if _, pinned := rules.Pins[candidate.ServiceName]; !pinned && rules.selects(candidate.ServiceName) {
+			selected.add(candidate, selectedCandidate)
+		}
+	}
+	var pinned []string
+	for service := range rules.Pins {
+		pinned = append(pinned, service)
+	}
+	sort.Strings(pinned)
+	for _, service := range pinned {
+		candidate, err := c.Repo.FindCandidate(service, rules.Pins[service])
+		if err != nil {
+			return nil, fmt.Errorf("%s %s cannot be pinned: %v", service, rules.Pins[service], err)
+		}
+		// like the candidates awaiting E2E testing, a pinned version is validated by the snapshot and needs a spec
+		if candidate.E2E || candidate.Completed {
+			return nil, fmt.Errorf("%s %s cannot be pinned: it is already validated", service, rules.Pins[service])
+		}
+		if candidate.Spec == "" {
+			return nil, fmt.Errorf("%s %s cannot be pinned: it has no spec", service, rules.Pins[service])
+		}
+		selected.add(candidate, selectedPinned)
+	}
+
+	if rules.Fallback {
+		services, err := c.Repo.GetTrackedServices()
+		if err != nil {
+			return nil, err
+		}
+		for _, service := range services {
+			if selected.has(service) || !rules.selects(service) {
+				continue
+			}
+			candidate, err := c.Repo.FindDeployedCandidate(service, c.Environment)
+			if err != nil {
+				fmt.Println("  " + service + " has neither a candidate nor a deployed version")
+				continue
+			}
+			if candidate.Spec == "" {
+				fmt.Println("  " + service + " " + candidate.Version + " is deployed without a spec")
+				continue
+			}
+			// the deployed version is not under validation, keep it out of the snapshot
+			candidate.E2E = true
+			selected.add(candidate, selectedDeployed)
+		}
+	}
+	for _, service := range rules.Only {
+		if !selected.has(service) {
+			return nil, errors.New(service + " has no candidate to compose")
+		}
+	}
+
+	fmt.Println("Composing:")
+	for i, candidate := range selected.candidates {
+		fmt.Printf("  %-10s %s %s\n", selected.reasons[i], candidate.ServiceName, candidate.Version)
+	}
+	return selected.candidates, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/bhameyie/dpipeliner/data"
+
+	. "gopkg.in/check.v1"
+)
+
+type SelectionSuite struct{}
+
+var _ = Suite(&SelectionSuite{})
+
+func servicesOf(candidates []data.DeploymentCandidate) []string {
+	var services []string
+	for _, candidate := range candidates {
+		services = append(services, candidate.ServiceName+"@"+candidate.Version)
+	}
+	return services
+}
+
+func (s *SelectionSuite) TestComposesAllCandidatesWithoutRules(c *C) {
+	rep := &AllGoodRepo{}
+	sut := &Controller{Repo: rep}
+	e2e := []data.DeploymentCandidate{{ServiceName: "boom", Version: "1"}, {ServiceName: "doom", Version: "12"}}
+
+	selected, err := sut.selectCandidates(e2e)
+	c.Assert(err, IsNil)
+	c.Assert(servicesOf(selected), DeepEquals, []string{"boom@1", "doom@12"})
+}
+
+func (s *SelectionSuite) TestAppliesPinsExclusionsAndFallback(c *C) {
+	rep := &AllGoodRepo{
+		Tracked:    []string{"boom", "doom", "zoom", "room"},
+		Candidates: map[string]data.DeploymentCandidate{"doom": {ServiceName: "doom", Version: "9", Spec: `{"id": "/doom"}`}},
+		Candidate:  data.DeploymentCandidate{ServiceName: "room", Version: "3", Spec: `{"id": "/room"}`},
+	}
+	sut := &Controller{Repo: rep, Selection: SelectionRules{
+		Pins:     map[string]string{"doom": "9"},
+		Exclude:  []string{"zoom"},
+		Fallback: true,
+	}}
+	e2e := []data.DeploymentCandidate{
+		{ServiceName: "boom", Version: "1"}, {ServiceName: "doom", Version: "12"}, {ServiceName: "zoom", Version: "2"},
+	}
+
+	selected, err := sut.selectCandidates(e2e)
+	c.Assert(err, IsNil)
+	c.Assert(servicesOf(selected), DeepEquals, []string{"boom@1", "doom@9", "room@3"})
+	c.Assert(selected[2].E2E, Equals, true)
+}
+
+func (s *SelectionSuite) TestRestrictsToOnlyServices(c *C) {
+	sut := &Controller{Repo: &AllGoodRepo{}, Selection: SelectionRules{Only: []string{"doom"}}}
+	e2e := []data.DeploymentCandidate{{ServiceName: "boom", Version: "1"}, {ServiceName: "doom", Version: "12"}}
+
+	selected, err := sut.selectCandidates(e2e)
+	c.Assert(err, IsNil)
+	c.Assert(servicesOf(selected), DeepEquals, []string{"doom@12"})
+
+	sut.Selection.Only = append(sut.Selection.Only, "room")
+	_, err = sut.selectCandidates(e2e)
+	c.Assert(err, ErrorMatches, "room has no candidate to compose")
+}
+
+func (s *SelectionSuite) TestRefusesContradictoryRules(c *C) {
+	sut := &Controller{Repo: &AllGoodRepo{}, Selection: SelectionRules{Pins: map[string]string{"boom": "1"}, Exclude: []string{"boom"}}}
+	_, err := sut.selectCandidates(nil)
+	c.Assert(err, ErrorMatches, "boom is both pinned and excluded")
+
+	sut.Selection = SelectionRules{Pins: map[string]string{"boom": "1"}, Only: []string{"doom"}}
+	_, err = sut.selectCandidates(nil)
+	c.Assert(err, ErrorMatches, "boom is pinned but not part of the only services")
+}
+
+func (s *SelectionSuite) TestRefusesPinsToValidatedVersionsOrVersionsWithoutSpec(c *C) {
+	rep := &AllGoodRepo{Candidates: map[string]data.DeploymentCandidate{
+		"boom": {ServiceName: "boom", Version: "1", Spec: `{"id": "/boom"}`, E2E: true},
+		"doom": {ServiceName: "doom", Version: "12"},
+	}}
+	sut := &Controller{Repo: rep, Selection: SelectionRules{Pins: map[string]string{"boom": "1"}}}
+	_, err := sut.selectCandidates(nil)
+	c.Assert(err, ErrorMatches, "boom 1 cannot be pinned: it is already validated")
+
+	sut.Selection = SelectionRules{Pins: map[string]string{"doom": "12"}}
+	_, err = sut.selectCandidates(nil)
+	c.Assert(err, ErrorMatches, "doom 12 cannot be pinned: it has no spec")
+}
+
+func (s *SelectionSuite) TestFallbackSkipsDeployedVersionsWithoutSpec(c *C) {
+	rep := &AllGoodRepo{Tracked: []string{"boom", "room"}, Candidate: data.DeploymentCandidate{ServiceName: "room", Version: "3"}}
+	sut := &Controller{Repo: rep, Selection: SelectionRules{Fallback: true}}
+
+	selected, err := sut.selectCandidates([]data.DeploymentCandidate{{ServiceName: "boom", Version: "1"}})
+	c.Assert(err, IsNil)
+	c.Assert(servicesOf(selected), DeepEquals, []string{"boom@1"})
+}
+
+func (s *SelectionSuite) TestLoadsRulesAndMergesCommandLineRules(c *C) {
+	file := filepath.Join(c.MkDir(), "rules.yml")
+	content := "pin:\n  boom: \"1\"\n  doom: \"2\"\nexclude: [zoom]\nfallback: true\n"
+	c.Assert(ioutil.WriteFile(file, []byte(content), 0644), IsNil)
+
+	rules, err := LoadSelectionRules(file)
+	c.Assert(err, IsNil)
+	rules.Merge(SelectionRules{Pins: map[string]string{"doom": "3"}, Exclude: []string{"room"}})
+	c.Assert(rules, DeepEquals, SelectionRules{
+		Pins:     map[string]string{"boom": "1", "doom": "3"},
+		Exclude:  []string{"zoom", "room"},
+		Fallback: true,
+	})
+
+	c.Assert(ioutil.WriteFile(file, []byte("pins: {}\n"), 0644), IsNil)
+	_, err = LoadSelectionRules(file)
+	c.Assert(err, ErrorMatches, "(?s).*rules.yml: .*field pins not found.*")
+}
+
+func (s *SelectionSuite) TestComposesBaselineOfDeployedVersionsAroundTheCandidate(c *C) {
+	rep := &AllGoodRepo{
+		Tracked:    []string{"boom", "doom", "zoom"},
+		Candidates: map[string]data.DeploymentCandidate{"doom": {ServiceName: "doom", Version: "13", Spec: `{"id": "/doom"}`}},
+		Candidate:  data.DeploymentCandidate{ServiceName: "boom", Version: "1", Deployed: true, Spec: `{"id": "/boom"}`},
+		E2E:        []data.DeploymentCandidate{{ServiceName: "boom", Version: "2"}},
+	}
+	composer := &ComposerSpy{}
+	sut := &Controller{Repo: rep, Composer: composer, Selection: SelectionRules{Exclude: []string{"zoom"}}, SkipSnapshotFile: true}
+
+	c.Assert(sut.ProduceBaselineComposition("doom", "13"), IsNil)
+	c.Assert(servicesOf(composer.Candidates), DeepEquals, []string{"doom@13", "boom@1"})
+	c.Assert(composer.Candidates[0].E2E, Equals, false)
+	c.Assert(composer.Candidates[1].E2E, Equals, true)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+
+	"github.com/bhameyie/dpipeliner/data"
+	"github.com/bhameyie/dpipeliner/spec"
+)
+
+// smokeCheck probes the check's URL, rendered against the template context, and verifies the response
+func smokeCheck(client *http.Client, check data.SmokeCheck, ctx spec.Context) error {
+	url, err := spec.Render(check.URL, ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Get(string(url))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	expected := check.ExpectedStatus
+	if expected == 0 {
+		expected = http.StatusOK
+	}
+	if resp.StatusCode != expected {
+		return fmt.Errorf("smoke check %s returned %d instead of %d", url, resp.StatusCode, expected)
+	}
+	if check.BodyMatch == "" {
+		return nil
+	}
+	match, err := regexp.Compile(check.BodyMatch)
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if !match.Match(body) {
+		return fmt.Errorf("smoke check %s response does not match %s", url, check.BodyMatch)
+	}
+	return nil
+}
+
+// runSmokeChecks runs all the checks, stopping at the first failure
+func runSmokeChecks(client *http.Client, checks []data.SmokeCheck, ctx spec.Context) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	for _, check := range checks {
+		if err := smokeCheck(client, check, ctx); err != nil {
+			return err
+		}
+		fmt.Println("Smoke check " + check.URL + " passed")
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/bhameyie/dpipeliner/data"
+	"github.com/bhameyie/dpipeliner/spec"
+	. "gopkg.in/check.v1"
+)
+
+type SmokeSuite struct {
+	server *httptest.Server
+}
+
+var _ = Suite(&SmokeSuite{})
+
+func (s *SmokeSuite) SetUpTest(c *C) {
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/staging/health" {
+			w.Write([]byte(`{"status": "UP"}`))
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+}
+
+func (s *SmokeSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *SmokeSuite) TestPassesWhenStatusAndBodyMatch(c *C) {
+	checks := []data.SmokeCheck{{URL: s.server.URL + "/{{.Environment}}/health", BodyMatch: `"status":\s*"UP"`}}
+	c.Assert(runSmokeChecks(nil, checks, spec.Context{Environment: "staging"}), IsNil)
+}
+
+func (s *SmokeSuite) TestFailsOnUnexpectedStatus(c *C) {
+	checks := []data.SmokeCheck{{URL: s.server.URL + "/other"}}
+	c.Assert(runSmokeChecks(nil, checks, spec.Context{}), NotNil)
+
+	checks[0].ExpectedStatus = http.StatusServiceUnavailable
+	c.Assert(runSmokeChecks(nil, checks, spec.Context{}), IsNil)
+}
+
+func (s *SmokeSuite) TestFailsWhenBodyDoesNotMatch(c *C) {
+	checks := []data.SmokeCheck{{URL: s.server.URL + "/staging/health", BodyMatch: "DOWN"}}
+	c.Assert(runSmokeChecks(nil, checks, spec.Context{}), NotNil)
+}
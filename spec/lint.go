@@ -0,0 +1,105 @@
+package spec
+
+import (
+	"encoding/json"
+	"strings"
+
+	marathon "github.com/gambol99/go-marathon"
+)
+
+// Violation describes a problem found in a marathon spec
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+// Report gathers the violations found while validating a marathon spec
+type Report struct {
+	Service    string
+	Violations []Violation
+}
+
+// Rule inspects a parsed marathon app and returns a message when the app breaks it
+type Rule func(app *marathon.Application) string
+
+// Rules are the lint rules that can be enabled by name
+var Rules = map[string]Rule{
+	"health": func(app *marathon.Application) string {
+		if len(app.HealthChecks) == 0 {
+			return "no health checks defined"
+		}
+		return ""
+	},
+	"resources": func(app *marathon.Application) string {
+		if app.CPUs <= 0 || app.Mem <= 0 {
+			return "cpus and mem limits must be set"
+		}
+		return ""
+	},
+	"upgrade": func(app *marathon.Application) string {
+		if app.UpgradeStrategy == nil {
+			return "no upgradeStrategy defined"
+		}
+		return ""
+	},
+	"privileged": func(app *marathon.Application) string {
+		if app.Container != nil && app.Container.Docker != nil && app.Container.Docker.Privileged {
+			return "privileged containers are not allowed"
+		}
+		return ""
+	},
+}
+
+// DefaultRules lists the rules enabled when none are configured
+var DefaultRules = []string{"health", "resources", "upgrade", "privileged"}
+
+// Failed indicates whether any violation was found
+func (r *Report) Failed() bool {
+	return len(r.Violations) > 0
+}
+
+func (r *Report) add(rule, message string) {
+	r.Violations = append(r.Violations, Violation{Rule: rule, Message: message})
+}
+
+// Error lists the violations, one per line
+func (r *Report) Error() string {
+	lines := []string{"invalid spec for " + r.Service + ":"}
+	for _, v := range r.Violations {
+		lines = append(lines, "  ["+v.Rule+"] "+v.Message)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// matchesService accepts ids such as "svc", "/svc" or "/group/svc"
+func matchesService(id, service string) bool {
+	id = strings.Trim(id, "/")
+	return id == service || strings.HasSuffix(id, "/"+service)
+}
+
+// Lint checks that the rendered spec is a marathon app for the service and runs the given rules against it
+func Lint(service string, content []byte, rules []string) *Report {
+	report := &Report{Service: service}
+	app := new(marathon.Application)
+	if err := json.Unmarshal(content, app); err != nil {
+		report.add("parse", err.Error())
+		return report
+	}
+	if !matchesService(app.ID, service) {
+		report.add("id", "id "+app.ID+" does not match service "+service)
+	}
+	if app.Container == nil || app.Container.Docker == nil || app.Container.Docker.Image == "" {
+		report.add("image", "no docker image defined")
+	}
+	for _, name := range rules {
+		rule, ok := Rules[name]
+		if !ok {
+			report.add(name, "unknown lint rule")
+			continue
+		}
+		if message := rule(app); message != "" {
+			report.add(name, message)
+		}
+	}
+	return report
+}
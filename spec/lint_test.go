@@ -0,0 +1,54 @@
+package spec
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type LintSuite struct{}
+
+var _ = Suite(&LintSuite{})
+
+const validSpec = `{
+	"id": "/fire/elApp",
+	"cpus": 0.5,
+	"mem": 128,
+	"container": {"type": "DOCKER", "docker": {"image": "group/image"}},
+	"healthChecks": [{"protocol": "HTTP", "path": "/health"}],
+	"upgradeStrategy": {"minimumHealthCapacity": 0.5, "maximumOverCapacity": 0.2}
+}`
+
+func rulesOf(r *Report) []string {
+	var rules []string
+	for _, v := range r.Violations {
+		rules = append(rules, v.Rule)
+	}
+	return rules
+}
+
+func (s *LintSuite) TestAcceptsValidSpec(c *C) {
+	report := Lint("elApp", []byte(validSpec), DefaultRules)
+	c.Assert(report.Failed(), Equals, false)
+}
+
+func (s *LintSuite) TestRejectsUnparsableSpec(c *C) {
+	report := Lint("elApp", []byte(`{"id": `), DefaultRules)
+	c.Assert(rulesOf(report), DeepEquals, []string{"parse"})
+}
+
+func (s *LintSuite) TestRejectsMismatchedIdAndMissingImage(c *C) {
+	report := Lint("other", []byte(`{"id": "/fire/elApp"}`), nil)
+	c.Assert(rulesOf(report), DeepEquals, []string{"id", "image"})
+	c.Assert(report.Error(), Matches, "(?s)invalid spec for other:.*\\[id\\].*")
+}
+
+func (s *LintSuite) TestReportsEachBrokenRule(c *C) {
+	spec := `{"id": "elApp", "container": {"docker": {"image": "group/image", "privileged": true}}}`
+	report := Lint("elApp", []byte(spec), DefaultRules)
+	c.Assert(rulesOf(report), DeepEquals, []string{"health", "resources", "upgrade", "privileged"})
+}
+
+func (s *LintSuite) TestRunsOnlyConfiguredRules(c *C) {
+	spec := `{"id": "elApp", "container": {"docker": {"image": "group/image"}}}`
+	report := Lint("elApp", []byte(spec), []string{"upgrade", "bogus"})
+	c.Assert(rulesOf(report), DeepEquals, []string{"upgrade", "bogus"})
+}
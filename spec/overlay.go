@@ -0,0 +1,56 @@
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Overlay deep merges the overlay into a JSON spec: nested objects are merged, any other value replaces the original
+func Overlay(content []byte, overlay map[string]interface{}) ([]byte, error) {
+	if len(overlay) == 0 {
+		return content, nil
+	}
+	doc := make(map[string]interface{})
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	merge(doc, normalize(overlay).(map[string]interface{}))
+	return json.Marshal(doc)
+}
+
+func merge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := dst[k].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			merge(dstMap, srcMap)
+		} else {
+			dst[k] = v
+		}
+	}
+}
+
+// normalize converts yaml decoded maps into maps that can be marshalled to JSON
+func normalize(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{})
+		for k, item := range value {
+			res[fmt.Sprint(k)] = normalize(item)
+		}
+		return res
+	case map[string]interface{}:
+		res := make(map[string]interface{})
+		for k, item := range value {
+			res[k] = normalize(item)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(value))
+		for i, item := range value {
+			res[i] = normalize(item)
+		}
+		return res
+	}
+	return v
+}
@@ -0,0 +1,31 @@
+package spec
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type OverlaySuite struct{}
+
+var _ = Suite(&OverlaySuite{})
+
+func (s *OverlaySuite) TestCanDeepMergeOverlay(c *C) {
+	overlay := map[string]interface{}{
+		"instances": 5,
+		"env":       map[interface{}]interface{}{"LOG": "warn"},
+		"args":      []interface{}{"--prod"},
+	}
+	content, err := Overlay([]byte(`{"id": "elApp", "instances": 1, "env": {"LOG": "debug", "DB": "mongo"}, "args": ["--dev"]}`), overlay)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, `{"args":["--prod"],"env":{"DB":"mongo","LOG":"warn"},"id":"elApp","instances":5}`)
+}
+
+func (s *OverlaySuite) TestLeavesSpecUntouchedWithoutOverlay(c *C) {
+	content, err := Overlay([]byte(`{"id": "elApp"}`), nil)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, `{"id": "elApp"}`)
+}
+
+func (s *OverlaySuite) TestFailsToOverlayInvalidSpec(c *C) {
+	_, err := Overlay([]byte(`kind: Deployment`), map[string]interface{}{"instances": 2})
+	c.Assert(err, NotNil)
+}
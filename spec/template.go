@@ -0,0 +1,117 @@
+package spec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"text/template"
+	"text/template/parse"
+)
+
+// Context holds the candidate data that can be referenced from a spec template, e.g. {{.Image}}
+type Context struct {
+	Image       string
+	Version     string
+	Service     string
+	Environment string
+	Labels      map[string]string
+	Vars        map[string]string
+}
+
+var funcs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func parseTemplate(content, missingKey string) (*template.Template, error) {
+	return template.New("spec").Funcs(funcs).Option("missingkey=" + missingKey).Parse(content)
+}
+
+// Render produces the spec to deploy by substituting the placeholders of the template
+func Render(content string, ctx Context) ([]byte, error) {
+	return render(content, "error", ctx)
+}
+
+// Preview renders the template ahead of deploy time, the Vars and Labels missing from the context rendering empty
+func Preview(content string, ctx Context) ([]byte, error) {
+	return render(content, "zero", ctx)
+}
+
+func render(content, missingKey string, ctx Context) ([]byte, error) {
+	tmpl, err := parseTemplate(content, missingKey)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Labels == nil {
+		ctx.Labels = map[string]string{}
+	}
+	if ctx.Vars == nil {
+		ctx.Vars = map[string]string{}
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, ctx); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Validate ensures the template is well formed and renders against the given context
+func Validate(content string, ctx Context) error {
+	_, err := Render(content, ctx)
+	return err
+}
+
+// Check ensures the template is well formed and only references fields of the Context, without rendering it
+// since the values it is rendered with are only known at deploy time
+func Check(content string) error {
+	tmpl, err := parseTemplate(content, "error")
+	if err != nil {
+		return err
+	}
+	return checkFields(tmpl.Tree.Root)
+}
+
+// checkFields looks for references to unknown Context fields, leaving out the range and with blocks where
+// the dot is no longer the Context
+func checkFields(node parse.Node) error {
+	var children []parse.Node
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n != nil {
+			children = n.Nodes
+		}
+	case *parse.ActionNode:
+		children = []parse.Node{n.Pipe}
+	case *parse.TemplateNode:
+		children = []parse.Node{n.Pipe}
+	case *parse.IfNode:
+		children = []parse.Node{n.Pipe, n.List, n.ElseList}
+	case *parse.RangeNode:
+		children = []parse.Node{n.Pipe, n.ElseList}
+	case *parse.WithNode:
+		children = []parse.Node{n.Pipe, n.ElseList}
+	case *parse.PipeNode:
+		if n != nil {
+			for _, cmd := range n.Cmds {
+				children = append(children, cmd)
+			}
+		}
+	case *parse.CommandNode:
+		children = n.Args
+	case *parse.ChainNode:
+		children = []parse.Node{n.Node}
+	case *parse.FieldNode:
+		if _, ok := reflect.TypeOf(Context{}).FieldByName(n.Ident[0]); !ok {
+			return fmt.Errorf("template references unknown field %s", n)
+		}
+	}
+	for _, child := range children {
+		if err := checkFields(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package spec
+
+import (
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TemplateSuite struct{}
+
+var _ = Suite(&TemplateSuite{})
+
+var ctx = Context{
+	Image:       "group/image:2",
+	Version:     "2",
+	Service:     "elApp",
+	Environment: "staging",
+	Labels:      map[string]string{"team": "fire"},
+	Vars:        map[string]string{"instances": "3"},
+}
+
+func (s *TemplateSuite) TestCanRenderCandidatePlaceholders(c *C) {
+	tmpl := `{"id": "{{.Service}}", "instances": {{.Vars.instances}}, "labels": {{json .Labels}}, ` +
+		`"env": {"VERSION": "{{.Version}}", "ENV": "{{.Environment}}"}, "container": {"docker": {"image": "{{.Image}}"}}}`
+
+	content, err := Render(tmpl, ctx)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, `{"id": "elApp", "instances": 3, "labels": {"team":"fire"}, `+
+		`"env": {"VERSION": "2", "ENV": "staging"}, "container": {"docker": {"image": "group/image:2"}}}`)
+}
+
+func (s *TemplateSuite) TestRendersPlainSpecsUnchanged(c *C) {
+	content, err := Render(`{"id": "elApp"}`, Context{})
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, `{"id": "elApp"}`)
+}
+
+func (s *TemplateSuite) TestFailsToRenderMissingVariable(c *C) {
+	_, err := Render(`{"instances": {{.Vars.nope}}}`, ctx)
+	c.Assert(err, NotNil)
+}
+
+func (s *TemplateSuite) TestChecksTemplateWithoutRendering(c *C) {
+	c.Assert(Check(`{"id": "{{.Service"}`), NotNil)
+	c.Assert(Check(`{"id": "{{.Owner}}"}`), ErrorMatches, "template references unknown field .Owner")
+	c.Assert(Check(`{"id": "{{if .Vars.prefix}}{{.Vars.prefix}}-{{end}}{{.Service}}", "n": {{.Vars.nope}}}`), IsNil)
+	c.Assert(Check(`{"labels": {{range $k, $v := .Labels}}"{{$k}}": "{{.}}"{{end}}}`), IsNil)
+}
+
+func (s *TemplateSuite) TestPreviewsTemplateWithMissingVariables(c *C) {
+	content, err := Preview(`{"id": "{{.Service}}", "team": "{{.Vars.team}}"}`, ctx)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, `{"id": "elApp", "team": ""}`)
+}
+
+func (s *TemplateSuite) TestFailsToValidateMalformedTemplate(c *C) {
+	c.Assert(Validate(`{"id": "{{.Service"}`, ctx), NotNil)
+	c.Assert(Validate(`{"id": "{{.Owner}}"}`, ctx), NotNil)
+	c.Assert(Validate(`{"id": "{{.Service}}"}`, ctx), IsNil)
+}
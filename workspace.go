@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ownerFile records the run owning the files of a workspace directory
+const ownerFile = ".dpipeliner-run"
+
+// Workspace locates the files produced and read by a pipeline run. Runs given a RunID own the directory
+// they write to: a run refuses to overwrite the files of another run unless forced. Without a RunID every
+// run may write to the directory. The zero value is the current directory, without ownership.
+type Workspace struct {
+	Dir          string
+	SnapshotFile string
+	ComposeFile  string
+	// RunID identifies the run owning the files it writes, ownership is not tracked when empty
+	RunID string
+	// Force overwrites the files of another run
+	Force bool
+}
+
+type workspaceOwner struct {
+	RunID   string
+	Claimed time.Time
+}
+
+func (w Workspace) path(file, fallback string) string {
+	if file == "" {
+		file = fallback
+	}
+	if filepath.IsAbs(file) || w.Dir == "" {
+		return file
+	}
+	return filepath.Join(w.Dir, file)
+}
+
+// SnapshotPath is the location of the snapshot file
+func (w Workspace) SnapshotPath() string {
+	return w.path(w.SnapshotFile, snapshotFile)
+}
+
+// ComposePath is the location of the file receiving the composer content
+func (w Workspace) ComposePath() string {
+	return w.path(w.ComposeFile, composerFile)
+}
+
+// owner reads the run owning the workspace directory, nil when there is none
+func (w Workspace) owner() (*workspaceOwner, error) {
+	b, err := ioutil.ReadFile(w.path(ownerFile, ""))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	owner := &workspaceOwner{}
+	if err := json.Unmarshal(b, owner); err != nil {
+		return nil, errors.New(w.path(ownerFile, "") + " is malformed: " + err.Error())
+	}
+	return owner, nil
+}
+
+// claim makes the run the owner of the workspace directory, failing when it belongs to another run and
+// the workspace is not forced. The owner file is created exclusively so that concurrent runs cannot both
+// claim the directory.
+func (w Workspace) claim() error {
+	if w.Dir != "" {
+		if err := os.MkdirAll(w.Dir, 0755); err != nil {
+			return err
+		}
+	}
+	if w.RunID == "" {
+		return nil
+	}
+	b, err := json.Marshal(workspaceOwner{RunID: w.RunID, Claimed: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(w.path(ownerFile, ""), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err == nil {
+		_, err = f.Write(b)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+	if !os.IsExist(err) {
+		return err
+	}
+
+	owner, err := w.owner()
+	if err != nil && !w.Force {
+		return err
+	}
+	if owner != nil && owner.RunID == w.RunID {
+		return nil
+	}
+	if !w.Force {
+		if owner == nil {
+			return errors.New("workspace " + w.dir() + " was claimed by another run at the same time")
+		}
+		return errors.New("workspace " + w.dir() + " belongs to run " + owner.RunID + " since " +
+			owner.Claimed.Format(time.RFC3339) + ", use another directory or force to overwrite its files")
+	}
+	return ioutil.WriteFile(w.path(ownerFile, ""), b, 0644)
+}
+
+func (w Workspace) dir() string {
+	if w.Dir == "" {
+		return "."
+	}
+	return w.Dir
+}
+
+// WriteFile writes a file of the run after claiming the workspace
+func (w Workspace) WriteFile(file string, content []byte) error {
+	if err := w.claim(); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, content, 0644)
+}
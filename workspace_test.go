@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	. "gopkg.in/check.v1"
+)
+
+type WorkspaceSuite struct{}
+
+var _ = Suite(&WorkspaceSuite{})
+
+func (s *WorkspaceSuite) TestLocatesFilesInTheOutputDirectory(c *C) {
+	w := Workspace{Dir: "out", ComposeFile: "e2e.yml"}
+	c.Assert(w.SnapshotPath(), Equals, filepath.Join("out", snapshotFile))
+	c.Assert(w.ComposePath(), Equals, filepath.Join("out", "e2e.yml"))
+
+	w.SnapshotFile = "/shared/snapshot.json"
+	c.Assert(w.SnapshotPath(), Equals, "/shared/snapshot.json")
+	c.Assert(Workspace{}.ComposePath(), Equals, composerFile)
+}
+
+func (s *WorkspaceSuite) TestRefusesToOverwriteFilesOfAnotherRun(c *C) {
+	dir := filepath.Join(c.MkDir(), "run")
+	first := Workspace{Dir: dir, RunID: "first"}
+	c.Assert(first.WriteFile(first.SnapshotPath(), []byte("first")), IsNil)
+	c.Assert(first.WriteFile(first.SnapshotPath(), []byte("again")), IsNil)
+
+	second := Workspace{Dir: dir, RunID: "second"}
+	c.Assert(second.WriteFile(second.SnapshotPath(), []byte("second")), ErrorMatches, "workspace .*/run belongs to run first since .*")
+	b, err := ioutil.ReadFile(first.SnapshotPath())
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "again")
+
+	second.Force = true
+	c.Assert(second.WriteFile(second.SnapshotPath(), []byte("second")), IsNil)
+	c.Assert(first.WriteFile(first.SnapshotPath(), []byte("first")), ErrorMatches, ".* belongs to run second .*")
+}
+
+func (s *WorkspaceSuite) TestIsolatesRunsWritingToDifferentDirectories(c *C) {
+	root := c.MkDir()
+	rep := &AllGoodRepo{}
+	first := &Controller{Composer: &AllGoodComposer{}, Repo: rep, Workspace: Workspace{Dir: filepath.Join(root, "a"), RunID: "a"}}
+	second := &Controller{Composer: &AllGoodComposer{}, Repo: rep, Workspace: Workspace{Dir: filepath.Join(root, "b"), RunID: "b"}}
+
+	c.Assert(first.ProduceCompositionAndSnapshotFiles(), IsNil)
+	c.Assert(second.ProduceCompositionAndSnapshotFiles(), IsNil)
+	for _, dir := range []string{"a", "b"} {
+		b, err := ioutil.ReadFile(filepath.Join(root, dir, composerFile))
+		c.Assert(err, IsNil)
+		c.Assert(string(b), Equals, "hoho")
+	}
+
+	second.Workspace.Dir = first.Workspace.Dir
+	c.Assert(second.ProduceCompositionAndSnapshotFiles(), ErrorMatches, ".* belongs to run a .*")
+}
+
+func (s *WorkspaceSuite) TestDoesNotTrackRunsWithoutID(c *C) {
+	dir := c.MkDir()
+	for _, content := range []string{"first", "second"} {
+		w := Workspace{Dir: dir}
+		c.Assert(w.WriteFile(w.SnapshotPath(), []byte(content)), IsNil)
+	}
+	_, err := os.Stat(filepath.Join(dir, ownerFile))
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *WorkspaceSuite) TestOnlyOneConcurrentRunClaimsTheDirectory(c *C) {
+	dir := c.MkDir()
+	errs := make(chan error, 8)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			errs <- Workspace{Dir: dir, RunID: id}.claim()
+		}(strconv.Itoa(i))
+	}
+	wg.Wait()
+	close(errs)
+	claimed := 0
+	for err := range errs {
+		if err == nil {
+			claimed++
+		}
+	}
+	c.Assert(claimed, Equals, 1)
+}